@@ -0,0 +1,214 @@
+package hcl
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type AdaptiveAlgorithm int
+
+const (
+	// AdaptiveAIMD grows the limit by one while it is used and cuts it by
+	// BackoffRatio on an error or a sample slower than LatencyThreshold.
+	AdaptiveAIMD AdaptiveAlgorithm = iota
+	// AdaptiveGradient follows the ratio between the lowest and the current
+	// latency, like TCP Vegas, and backs off on errors.
+	AdaptiveGradient
+)
+
+type AdaptiveLimiterOption struct {
+	Algorithm AdaptiveAlgorithm
+	// InitialLimit defaults to 20, MinLimit to 1 and MaxLimit to 200.
+	InitialLimit int
+	MinLimit     int
+	MaxLimit     int
+	// BackoffRatio multiplies the limit on a drop, defaults to 0.9.
+	BackoffRatio float64
+	// LatencyThreshold marks slower AIMD samples as drops, defaults to 1s.
+	LatencyThreshold time.Duration
+	// Tolerance is how much slower than the lowest latency a gradient sample
+	// may be before the limit shrinks, defaults to 1.5.
+	Tolerance float64
+	// Smoothing weighs a new gradient limit against the current one, defaults to 0.2.
+	Smoothing float64
+	// MinRTTWindow is how long the lowest gradient latency is kept before it
+	// is measured again, defaults to 30s.
+	MinRTTWindow time.Duration
+	// Clock defaults to time.Now.
+	Clock func() time.Time
+}
+
+// AdaptiveLimiter adjusts the allowed concurrency per host from the latency
+// and errors of the requests, excess requests are shed right away with a
+// BulkheadError. It is an alternative to a fixed Bulkhead, see HCL.AdaptiveLimiter.
+type AdaptiveLimiter struct {
+	options AdaptiveLimiterOption
+
+	mu     sync.Mutex
+	limits map[string]*adaptiveLimit
+}
+
+type adaptiveLimit struct {
+	limit    float64
+	inFlight int
+	minRTT   time.Duration
+	minRTTAt time.Time
+}
+
+func NewAdaptiveLimiter(options AdaptiveLimiterOption) *AdaptiveLimiter {
+	if options.MinLimit <= 0 {
+		options.MinLimit = 1
+	}
+	if options.MaxLimit <= 0 {
+		options.MaxLimit = 200
+	}
+	if options.InitialLimit <= 0 {
+		options.InitialLimit = 20
+	}
+	if options.BackoffRatio <= 0 || options.BackoffRatio >= 1 {
+		options.BackoffRatio = 0.9
+	}
+	if options.LatencyThreshold <= 0 {
+		options.LatencyThreshold = time.Second
+	}
+	if options.Tolerance < 1 {
+		options.Tolerance = 1.5
+	}
+	if options.Smoothing <= 0 || options.Smoothing > 1 {
+		options.Smoothing = 0.2
+	}
+	if options.MinRTTWindow <= 0 {
+		options.MinRTTWindow = 30 * time.Second
+	}
+	if options.Clock == nil {
+		options.Clock = time.Now
+	}
+
+	return &AdaptiveLimiter{
+		options: options,
+		limits:  make(map[string]*adaptiveLimit),
+	}
+}
+
+func (l *AdaptiveLimiter) get(key string) *adaptiveLimit {
+	al, ok := l.limits[key]
+	if !ok {
+		al = &adaptiveLimit{limit: l.clamp(float64(l.options.InitialLimit))}
+		l.limits[key] = al
+	}
+	return al
+}
+
+func (l *AdaptiveLimiter) clamp(limit float64) float64 {
+	return math.Max(float64(l.options.MinLimit), math.Min(float64(l.options.MaxLimit), limit))
+}
+
+// Limit returns the current concurrency limit for key.
+func (l *AdaptiveLimiter) Limit(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.get(key).limit)
+}
+
+// acquire takes a slot for key, the returned func gives it back with the
+// latency of the request and whether it failed.
+func (l *AdaptiveLimiter) acquire(key string) (func(rtt time.Duration, dropped bool), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	al := l.get(key)
+	if al.inFlight >= int(al.limit) {
+		return nil, &BulkheadError{Key: key, Reason: "limit reached"}
+	}
+	al.inFlight++
+
+	var once sync.Once
+	return func(rtt time.Duration, dropped bool) {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			// no latency means the request was abandoned, there is nothing to learn
+			if rtt > 0 || dropped {
+				l.sample(al, rtt, dropped)
+			}
+			al.inFlight--
+		})
+	}, nil
+}
+
+func (l *AdaptiveLimiter) sample(al *adaptiveLimit, rtt time.Duration, dropped bool) {
+	if dropped {
+		al.limit = l.clamp(al.limit * l.options.BackoffRatio)
+		return
+	}
+
+	switch l.options.Algorithm {
+	case AdaptiveGradient:
+		now := l.options.Clock()
+		if al.minRTT <= 0 || rtt < al.minRTT || now.Sub(al.minRTTAt) > l.options.MinRTTWindow {
+			al.minRTT, al.minRTTAt = rtt, now
+		}
+
+		gradient := 1.0
+		if rtt > 0 {
+			gradient = math.Max(0.5, math.Min(1, l.options.Tolerance*float64(al.minRTT)/float64(rtt)))
+		}
+		// sqrt(limit) leaves room for queueing so the limit can still grow
+		next := al.limit*gradient + math.Sqrt(al.limit)
+		al.limit = l.clamp(al.limit*(1-l.options.Smoothing) + next*l.options.Smoothing)
+	default:
+		if rtt > l.options.LatencyThreshold {
+			al.limit = l.clamp(al.limit * l.options.BackoffRatio)
+		} else if float64(al.inFlight*2) >= al.limit {
+			// only grow a limit that is actually used
+			al.limit = l.clamp(al.limit + 1)
+		}
+	}
+}
+
+// adaptiveLimitMiddleware sheds requests above the adaptive limit of the
+// URL host and feeds the outcome of the others back into it. A slot is held
+// until the response body is read to the end or closed. It runs after
+// load balancing and rate limiting so the host is the chosen endpoint and the
+// RTT leaves out the rate limit wait and the token fetch.
+func (r *Request) adaptiveLimitMiddleware(next http.RoundTripper) http.RoundTripper {
+	if r.adaptiveLimiter == nil {
+		return next
+	}
+
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		release, err := r.adaptiveLimiter.acquire(req.URL.Host)
+		if err != nil {
+			if r.metrics != nil {
+				r.metrics.IncRejected(req.URL.Host, "adaptive")
+			}
+			r.tracing.refused(req.Context(), req.Method, req.URL, r.auth.sensitiveQuery(), err)
+			return nil, err
+		}
+
+		clock := r.adaptiveLimiter.options.Clock
+		start := clock()
+		resp, err := next.RoundTrip(req)
+		rtt := clock().Sub(start)
+
+		switch {
+		case err != nil && errors.Is(err, context.Canceled):
+			// the caller gave up, it says nothing about the upstream
+			release(0, false)
+		case err != nil:
+			release(rtt, true)
+		case bodyless(req, resp):
+			release(rtt, r.isErrorStatus(resp.StatusCode))
+		default:
+			// like the bulkhead the slot is held until the body is done, the
+			// latency is the time to the headers
+			dropped := r.isErrorStatus(resp.StatusCode)
+			resp.Body = &releaseBody{ReadCloser: resp.Body, release: func() { release(rtt, dropped) }}
+		}
+		return resp, err
+	})
+}
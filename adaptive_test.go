@@ -0,0 +1,178 @@
+package hcl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptiveLimiterAIMD(t *testing.T) {
+	l := NewAdaptiveLimiter(AdaptiveLimiterOption{InitialLimit: 4, MaxLimit: 6, LatencyThreshold: 100 * time.Millisecond})
+
+	// a used limit grows by one per fast sample
+	releases := make([]func(time.Duration, bool), 0, 4)
+	for i := 0; i < 4; i++ {
+		release, err := l.acquire("svc")
+		assert.NoError(t, err)
+		releases = append(releases, release)
+	}
+	_, err := l.acquire("svc")
+	assert.ErrorIs(t, err, ErrBulkheadFull)
+
+	releases[0](10*time.Millisecond, false)
+	releases[1](10*time.Millisecond, false)
+	assert.Equal(t, 6, l.Limit("svc"))
+
+	// slow samples and errors cut it
+	releases[2](200*time.Millisecond, false)
+	assert.Equal(t, 5, l.Limit("svc"))
+	releases[3](10*time.Millisecond, true)
+	assert.Equal(t, 4, l.Limit("svc"))
+
+	// a released slot is not counted twice
+	releases[3](10*time.Millisecond, true)
+	assert.Equal(t, 4, l.Limit("svc"))
+
+	// a limit that is not used does not grow
+	release, _ := l.acquire("svc")
+	release(10*time.Millisecond, false)
+	assert.Equal(t, 4, l.Limit("svc"))
+}
+
+func TestAdaptiveLimiterGradient(t *testing.T) {
+	clock := newFakeClock()
+	l := NewAdaptiveLimiter(AdaptiveLimiterOption{
+		Algorithm:    AdaptiveGradient,
+		InitialLimit: 16,
+		MinRTTWindow: time.Minute,
+		Clock:        clock.Now,
+	})
+
+	observe := func(rtt time.Duration) int {
+		release, err := l.acquire("svc")
+		assert.NoError(t, err)
+		release(rtt, false)
+		return l.Limit("svc")
+	}
+
+	// latency at the baseline leaves room to grow
+	assert.Equal(t, 16, observe(10*time.Millisecond))
+	assert.Equal(t, 17, observe(10*time.Millisecond))
+
+	// latency far above the baseline shrinks the limit
+	limit := l.Limit("svc")
+	for i := 0; i < 10; i++ {
+		observe(100 * time.Millisecond)
+	}
+	assert.Less(t, l.Limit("svc"), limit)
+
+	// the baseline is measured again after the window
+	clock.Advance(2 * time.Minute)
+	limit = l.Limit("svc")
+	for i := 0; i < 10; i++ {
+		observe(100 * time.Millisecond)
+	}
+	assert.Greater(t, l.Limit("svc"), limit)
+}
+
+func TestAdaptiveLimiterShedsLoad(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	server := newBlockingServer(&calls, release)
+	defer server.Close()
+
+	metrics := newFakeMetrics()
+	hcl := &HCL{
+		AdaptiveLimiter: NewAdaptiveLimiter(AdaptiveLimiterOption{InitialLimit: 1}),
+		Metrics:         metrics,
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := New(hcl).SetUrl(server.URL).Get()
+		assert.NoError(t, err)
+	}()
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&calls) == 1 }, time.Second, 5*time.Millisecond)
+
+	_, err := New(hcl).SetUrl(server.URL).Get()
+	assert.ErrorIs(t, err, ErrBulkheadFull)
+	assert.Equal(t, []string{"adaptive"}, metrics.rejected)
+
+	close(release)
+	wg.Wait()
+}
+
+func TestAdaptiveLimiterBacksOffOnErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	hcl := &HCL{AdaptiveLimiter: NewAdaptiveLimiter(AdaptiveLimiterOption{InitialLimit: 10, BackoffRatio: 0.5})}
+	_, err := New(hcl).SetUrl(server.URL).Get()
+	assert.NoError(t, err)
+
+	assert.Equal(t, 5, hcl.AdaptiveLimiter.Limit(server.URL[len("http://"):]))
+}
+
+func TestAdaptiveLimiterIgnoresRateLimitWait(t *testing.T) {
+	var calls int32
+	server := newCountingServer(&calls)
+	defer server.Close()
+
+	hcl := &HCL{
+		AdaptiveLimiter: NewAdaptiveLimiter(AdaptiveLimiterOption{InitialLimit: 10, LatencyThreshold: 50 * time.Millisecond}),
+		RateLimiter:     NewTokenBucket(TokenBucketOption{Rate: 10, Burst: 1}),
+	}
+
+	// the second request waits about 100ms for a token
+	for i := 0; i < 2; i++ {
+		_, err := New(hcl).SetUrl(server.URL).Get()
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	assert.Equal(t, 10, hcl.AdaptiveLimiter.Limit(server.URL[len("http://"):]))
+}
+
+func TestAdaptiveLimiterHoldsSlotUntilBodyIsRead(t *testing.T) {
+	server := newNamedServer("a")
+	defer server.Close()
+
+	hcl := &HCL{AdaptiveLimiter: NewAdaptiveLimiter(AdaptiveLimiterOption{InitialLimit: 1})}
+
+	resp, err := New(hcl).SetUrl(server.URL).Get()
+	assert.NoError(t, err)
+	_, err = New(hcl).SetUrl(server.URL).Get()
+	assert.ErrorIs(t, err, ErrBulkheadFull)
+
+	_, err = resp.ByteResult()
+	assert.NoError(t, err)
+	_, err = New(hcl).SetUrl(server.URL).Get()
+	assert.NoError(t, err)
+}
+
+func TestAdaptiveLimiterWithHedging(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// the hedged attempts report their status concurrently
+	hcl := &HCL{
+		AdaptiveLimiter: NewAdaptiveLimiter(AdaptiveLimiterOption{}),
+		Hedger:          NewHedger(HedgeOption{Delay: time.Millisecond, MaxHedges: 3, Budget: 1}),
+	}
+	for i := 0; i < 5; i++ {
+		resp, err := New(hcl).SetUrl(server.URL).Get()
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	}
+}
@@ -0,0 +1,197 @@
+package hcl
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+type APIKeyIn string
+
+const (
+	APIKeyInHeader APIKeyIn = "header"
+	APIKeyInQuery  APIKeyIn = "query"
+
+	headerAuthorization = "Authorization"
+)
+
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+type APIKey struct {
+	In    APIKeyIn
+	Name  string
+	Value string
+}
+
+// credentials holds the authentication set through the auth helpers, it is
+// applied when the request is built and redacted from the log.
+type credentials struct {
+	basic       *BasicAuth
+	bearer      string
+	apiKey      *APIKey
+	tokenSource TokenSource
+	digest      *DigestAuth
+}
+
+func (r *Request) SetBasicAuth(username, password string) *Request {
+	// Check if the request object is nil
+	if r == nil {
+		return nil
+	}
+
+	if username == "" {
+		r.errs = append(r.errs, errors.New("username cannot be empty"))
+		return r
+	}
+
+	r.auth.basic = &BasicAuth{Username: username, Password: password}
+	r.auth.bearer = ""
+	r.auth.digest = nil
+	return r
+}
+
+func (r *Request) SetBearerToken(token string) *Request {
+	// Check if the request object is nil
+	if r == nil {
+		return nil
+	}
+
+	if token == "" {
+		r.errs = append(r.errs, errors.New("bearer token cannot be empty"))
+		return r
+	}
+
+	r.auth.bearer = token
+	r.auth.basic = nil
+	r.auth.digest = nil
+	return r
+}
+
+func (r *Request) SetAPIKey(in APIKeyIn, name, value string) *Request {
+	// Check if the request object is nil
+	if r == nil {
+		return nil
+	}
+
+	if name == "" || value == "" {
+		r.errs = append(r.errs, errors.New(msgFailedKeyVal))
+		return r
+	}
+
+	if in != APIKeyInHeader && in != APIKeyInQuery {
+		r.errs = append(r.errs, errors.New("api key must be sent in header or query"))
+		return r
+	}
+
+	r.auth.apiKey = &APIKey{In: in, Name: name, Value: value}
+	return r
+}
+
+func (c *credentials) apply(req *http.Request) {
+	switch {
+	case c.basic != nil:
+		req.SetBasicAuth(c.basic.Username, c.basic.Password)
+	case c.bearer != "":
+		req.Header.Set(headerAuthorization, "Bearer "+c.bearer)
+	}
+
+	if c.apiKey == nil {
+		return
+	}
+
+	switch c.apiKey.In {
+	case APIKeyInHeader:
+		req.Header.Set(c.apiKey.Name, c.apiKey.Value)
+	case APIKeyInQuery:
+		q := req.URL.Query()
+		q.Set(c.apiKey.Name, c.apiKey.Value)
+		req.URL.RawQuery = q.Encode()
+	}
+}
+
+// middleware adds the token source and digest authentication to the pipeline.
+func (c *credentials) middleware(next http.RoundTripper) http.RoundTripper {
+	if c.digest != nil {
+		next = digestMiddleware(c.digest)(next)
+	}
+	if c.tokenSource != nil {
+		next = tokenMiddleware(c.tokenSource)(next)
+	}
+	return next
+}
+
+// masks returns the log masks for the credentials that are set.
+func (c *credentials) masks() []*MaskConfig {
+	var masks []*MaskConfig
+	if c.basic != nil || c.bearer != "" || c.digest != nil {
+		masks = append(masks, &MaskConfig{Field: headerAuthorization, MaskType: Default})
+	}
+	if c.apiKey != nil {
+		masks = append(masks, &MaskConfig{Field: c.apiKey.Name, MaskType: Default})
+	}
+	return masks
+}
+
+// credentialHeaders carry credentials whether or not they were set through
+// the auth helpers.
+var credentialHeaders = []string{headerAuthorization, "Proxy-Authorization", "Cookie", "X-API-Key"}
+
+// headers returns the request headers that carry credentials, including the
+// API key header when one is set.
+func (c *credentials) headers() []string {
+	if c.apiKey == nil || c.apiKey.In != APIKeyInHeader {
+		return credentialHeaders
+	}
+	return append(append([]string(nil), credentialHeaders...), c.apiKey.Name)
+}
+
+// hasCredentials reports whether one of names is set in header.
+func hasCredentials(header http.Header, names []string) bool {
+	for _, name := range names {
+		if header.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// sensitiveQuery returns the query parameters that must not leave the process
+// through telemetry.
+func (c *credentials) sensitiveQuery() []string {
+	if c.apiKey == nil || c.apiKey.In != APIKeyInQuery {
+		return nil
+	}
+	return []string{c.apiKey.Name}
+}
+
+func (lg *Log) addMasks(masks ...*MaskConfig) {
+	if lg == nil {
+		return
+	}
+
+	for _, mask := range masks {
+		if found, _ := shouldMask(mask.Field, lg.maskedConfig); !found {
+			lg.maskedConfig = append(lg.maskedConfig, mask)
+		}
+	}
+}
+
+func redactQuery(rawQuery string, names []string) string {
+	if rawQuery == "" || len(names) <= 0 {
+		return rawQuery
+	}
+
+	pairs := strings.Split(rawQuery, "&")
+	for i, pair := range pairs {
+		key, _, _ := strings.Cut(pair, "=")
+		for _, name := range names {
+			if strings.EqualFold(key, name) {
+				pairs[i] = key + "=REDACTED"
+			}
+		}
+	}
+	return strings.Join(pairs, "&")
+}
@@ -0,0 +1,130 @@
+package hcl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type authCapture struct {
+	authorization string
+	apiKeyHeader  string
+	apiKeyQuery   string
+	basicUser     string
+	basicPass     string
+}
+
+func newAuthServer(capture *authCapture) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		capture.authorization = r.Header.Get("Authorization")
+		capture.apiKeyHeader = r.Header.Get("X-API-KEY")
+		capture.apiKeyQuery = r.URL.Query().Get("api_key")
+		capture.basicUser, capture.basicPass, _ = r.BasicAuth()
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func TestRequestSetBasicAuth(t *testing.T) {
+	capture := &authCapture{}
+	server := newAuthServer(capture)
+	defer server.Close()
+
+	var err error
+	output := captureOutput(func() {
+		_, err = New(nil).EnableLog(false).SetBasicAuth("john", "s3cr3t").SetUrl(server.URL).Get()
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "john", capture.basicUser)
+	assert.Equal(t, "s3cr3t", capture.basicPass)
+	assert.Contains(t, output, `"Authorization":["*****"]`)
+	assert.NotContains(t, output, "am9objpzM2NyM3Q=")
+}
+
+func TestRequestSetBearerToken(t *testing.T) {
+	capture := &authCapture{}
+	server := newAuthServer(capture)
+	defer server.Close()
+
+	var err error
+	output := captureOutput(func() {
+		_, err = New(nil).EnableLog(false).SetBearerToken("token-123").SetUrl(server.URL).Get()
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer token-123", capture.authorization)
+	assert.NotContains(t, output, "token-123")
+}
+
+func TestRequestSetAPIKey(t *testing.T) {
+	capture := &authCapture{}
+	server := newAuthServer(capture)
+	defer server.Close()
+
+	t.Run("header", func(t *testing.T) {
+		var err error
+		output := captureOutput(func() {
+			_, err = New(nil).EnableLog(false).SetAPIKey(APIKeyInHeader, "X-API-KEY", "key-123").SetUrl(server.URL).Get()
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "key-123", capture.apiKeyHeader)
+		assert.NotContains(t, output, "key-123")
+	})
+
+	t.Run("query", func(t *testing.T) {
+		tp, exporter := newTestTracerProvider()
+
+		var err error
+		output := captureOutput(func() {
+			_, err = New(&HCL{TracerProvider: tp}).EnableLog(false).
+				SetAPIKey(APIKeyInQuery, "api_key", "key-456").
+				SetUrl(server.URL).
+				SetQueryParam("a", "b").
+				Get()
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "key-456", capture.apiKeyQuery)
+		assert.NotContains(t, output, "key-456")
+
+		full, _ := spanAttr(exporter.GetSpans()[0], "url.full")
+		assert.NotContains(t, full, "key-456")
+		assert.Contains(t, full, "api_key=REDACTED")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := New(nil).SetAPIKey("cookie", "name", "value").SetUrl(server.URL).Get()
+		assert.Error(t, err)
+
+		_, err = New(nil).SetAPIKey(APIKeyInHeader, "", "value").SetUrl(server.URL).Get()
+		assert.Error(t, err)
+	})
+}
+
+func TestClientDefaultCredentials(t *testing.T) {
+	capture := &authCapture{}
+	server := newAuthServer(capture)
+	defer server.Close()
+
+	hcl := &HCL{
+		BearerToken: "client-token",
+		APIKey:      &APIKey{In: APIKeyInHeader, Name: "X-API-KEY", Value: "client-key"},
+	}
+
+	_, err := New(hcl).SetUrl(server.URL).Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer client-token", capture.authorization)
+	assert.Equal(t, "client-key", capture.apiKeyHeader)
+
+	_, err = New(hcl).SetBasicAuth("john", "doe").SetUrl(server.URL).Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "john", capture.basicUser)
+}
+
+func TestRedactQuery(t *testing.T) {
+	assert.Equal(t, "a=b&key=REDACTED", redactQuery("a=b&key=secret", []string{"KEY"}))
+	assert.Equal(t, "a=b", redactQuery("a=b", nil))
+}
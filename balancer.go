@@ -0,0 +1,455 @@
+package hcl
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoEndpoint is returned when the breaker of every endpoint is open.
+var ErrNoEndpoint = errors.New("request refused. no healthy endpoint is available")
+
+// ServiceScheme is the URL scheme of requests to a logical service name that
+// is resolved by BalancerOption.Resolver, e.g. "hcl://billing/invoices".
+const ServiceScheme = "hcl"
+
+type BalancerPolicy int
+
+const (
+	RoundRobin BalancerPolicy = iota
+	// Weighted is a smooth weighted round robin over Endpoint.Weight.
+	Weighted
+	LeastInFlight
+	// PowerOfTwoChoices picks the least loaded of two random endpoints.
+	PowerOfTwoChoices
+)
+
+type Endpoint struct {
+	// URL is the base URL, e.g. "https://10.0.0.1:8443/api".
+	URL string `json:"url" yaml:"url"`
+	// Weight is used by the Weighted policy, defaults to 1.
+	Weight int `json:"weight,omitempty" yaml:"weight,omitempty"`
+	// Priority groups the endpoints, the lowest is preferred. The next group
+	// is only used while every endpoint of the lower ones is ejected, open or
+	// already tried.
+	Priority int `json:"priority,omitempty" yaml:"priority,omitempty"`
+}
+
+type BalancerOption struct {
+	// Endpoints receive every request that does not use ServiceScheme.
+	Endpoints []Endpoint
+	// Resolver returns the endpoints of the services targeted with ServiceScheme.
+	Resolver Resolver
+	Policy   BalancerPolicy
+	// Breaker is the circuit breaker of every endpoint, an open breaker ejects
+	// the endpoint. Defaults to 5 failures, 1 half-open request and 30s.
+	Breaker CircuitBreakerOption
+	// MaxFailover is the number of other endpoints tried on a connection
+	// error, defaults to all of them.
+	MaxFailover int
+}
+
+// Balancer spreads requests over several base URLs of one upstream, the
+// scheme and host of the request URL are replaced by the selected endpoint
+// and its path is prefixed, see HCL.Balancer.
+type Balancer struct {
+	options BalancerOption
+	static  *pool
+
+	mu       sync.Mutex
+	services map[string]*pool
+}
+
+// pool is the set of endpoints of one service.
+type pool struct {
+	options *BalancerOption
+	next    uint64
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+	list      []Endpoint
+	rand      *rand.Rand
+}
+
+type endpoint struct {
+	base     *url.URL
+	weight   int
+	priority int
+	current  int
+	cb       *CircuitBreaker
+	inFlight int64
+	// ejected is set by a HealthChecker while the endpoint is unhealthy
+	ejected atomic.Bool
+}
+
+// EndpointStatus is a snapshot of an endpoint, see Balancer.Endpoints.
+type EndpointStatus struct {
+	URL      string
+	State    string
+	InFlight int
+	Ejected  bool
+}
+
+func NewBalancer(options BalancerOption) (*Balancer, error) {
+	if options.Breaker.MaxFailures <= 0 {
+		options.Breaker.MaxFailures = 5
+	}
+	if options.Breaker.HalfOpenLimit <= 0 {
+		options.Breaker.HalfOpenLimit = 1
+	}
+	if options.Breaker.ResetTimeout <= 0 {
+		options.Breaker.ResetTimeout = 30 * time.Second
+	}
+	if len(options.Endpoints) <= 0 && options.Resolver == nil {
+		return nil, errors.New("balancer needs at least one endpoint")
+	}
+
+	b := &Balancer{options: options, services: make(map[string]*pool)}
+	if len(options.Endpoints) > 0 {
+		b.static = newPool(&b.options)
+		if err := b.static.setEndpoints(options.Endpoints); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func newPool(options *BalancerOption) *pool {
+	return &pool{options: options, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// setEndpoints replaces the endpoints, the state of the ones that are kept is preserved.
+func (p *pool) setEndpoints(list []Endpoint) error {
+	if len(list) <= 0 {
+		return errors.New("balancer needs at least one endpoint")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if equalEndpoints(p.list, list) {
+		return nil
+	}
+
+	existing := make(map[string]*endpoint, len(p.endpoints))
+	for _, ep := range p.endpoints {
+		existing[ep.base.String()] = ep
+	}
+
+	endpoints := make([]*endpoint, 0, len(list))
+	for _, e := range list {
+		base, err := url.Parse(strings.TrimSuffix(e.URL, "/"))
+		if err != nil || base.Scheme == "" || base.Host == "" {
+			return errors.New("invalid endpoint URL: " + e.URL)
+		}
+
+		weight := e.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+
+		ep, ok := existing[base.String()]
+		if !ok {
+			ep = &endpoint{base: base, cb: NewCircuitBreaker(p.options.Breaker)}
+		}
+		ep.weight = weight
+		ep.priority = e.Priority
+		endpoints = append(endpoints, ep)
+	}
+
+	p.endpoints = endpoints
+	p.list = append([]Endpoint(nil), list...)
+	return nil
+}
+
+func equalEndpoints(a, b []Endpoint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Endpoints returns the state of every static endpoint, or of the endpoints
+// of the given service.
+func (b *Balancer) Endpoints(service ...string) []EndpointStatus {
+	p := b.static
+	if len(service) > 0 {
+		b.mu.Lock()
+		p = b.services[service[0]]
+		b.mu.Unlock()
+	}
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	statuses := make([]EndpointStatus, 0, len(p.endpoints))
+	for _, ep := range p.endpoints {
+		ep.cb.mu.Lock()
+		state := ep.cb.state
+		ep.cb.mu.Unlock()
+		if state == "" {
+			state = CLOSED
+		}
+
+		statuses = append(statuses, EndpointStatus{
+			URL:      ep.base.String(),
+			State:    state,
+			InFlight: int(atomic.LoadInt64(&ep.inFlight)),
+			Ejected:  ep.ejected.Load(),
+		})
+	}
+	return statuses
+}
+
+// pools returns the static pool and the pools of every resolved service.
+func (b *Balancer) pools() []*pool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var pools []*pool
+	if b.static != nil {
+		pools = append(pools, b.static)
+	}
+	for _, p := range b.services {
+		pools = append(pools, p)
+	}
+	return pools
+}
+
+// pool returns the endpoints for the request URL, nil when the request is not balanced.
+func (b *Balancer) pool(req *http.Request) (*pool, error) {
+	if req.URL.Scheme != ServiceScheme || b.options.Resolver == nil {
+		return b.static, nil
+	}
+
+	service := req.URL.Hostname()
+	list, err := b.options.Resolver.Resolve(req.Context(), service)
+	if err != nil {
+		return nil, errors.New("failed to resolve service " + service + ": " + err.Error())
+	}
+	if len(list) <= 0 {
+		return nil, ErrNoEndpoint
+	}
+
+	b.mu.Lock()
+	p, ok := b.services[service]
+	if !ok {
+		p = newPool(&b.options)
+		b.services[service] = p
+	}
+	b.mu.Unlock()
+
+	if err = p.setEndpoints(list); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// pick selects an endpoint of the lowest available priority that was not
+// tried yet and whose breaker allows it.
+func (p *pool) pick(tried map[*endpoint]bool) *endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var available []*endpoint
+	for _, ep := range p.endpoints {
+		if !tried[ep] && !ep.ejected.Load() {
+			available = append(available, ep)
+		}
+	}
+
+	for len(available) > 0 {
+		priority := available[0].priority
+		for _, ep := range available[1:] {
+			priority = min(priority, ep.priority)
+		}
+
+		var candidates []*endpoint
+		rest := available[:0:0]
+		for _, ep := range available {
+			switch {
+			case ep.priority != priority:
+				rest = append(rest, ep)
+			case ep.cb.ready():
+				candidates = append(candidates, ep)
+			}
+		}
+		available = rest
+
+		// allow moves an open breaker to half-open, only the chosen endpoint
+		// takes the probe
+		for len(candidates) > 0 {
+			ep := p.choose(candidates)
+			if ep.cb.allow() {
+				return ep
+			}
+			candidates = slices.DeleteFunc(candidates, func(c *endpoint) bool { return c == ep })
+		}
+	}
+	return nil
+}
+
+// choose applies the policy to the candidates.
+func (p *pool) choose(candidates []*endpoint) *endpoint {
+	start := int(atomic.AddUint64(&p.next, 1) % uint64(len(candidates)))
+	candidates = append(candidates[start:len(candidates):len(candidates)], candidates[:start]...)
+
+	switch p.options.Policy {
+	case Weighted:
+		total := 0
+		var best *endpoint
+		for _, ep := range candidates {
+			ep.current += ep.weight
+			total += ep.weight
+			if best == nil || ep.current > best.current {
+				best = ep
+			}
+		}
+		best.current -= total
+		return best
+	case LeastInFlight:
+		best := candidates[0]
+		for _, ep := range candidates[1:] {
+			if atomic.LoadInt64(&ep.inFlight) < atomic.LoadInt64(&best.inFlight) {
+				best = ep
+			}
+		}
+		return best
+	case PowerOfTwoChoices:
+		if len(candidates) == 1 {
+			return candidates[0]
+		}
+		i := p.rand.Intn(len(candidates))
+		j := p.rand.Intn(len(candidates) - 1)
+		if j >= i {
+			j++
+		}
+		if atomic.LoadInt64(&candidates[j].inFlight) < atomic.LoadInt64(&candidates[i].inFlight) {
+			return candidates[j]
+		}
+		return candidates[i]
+	default:
+		return candidates[0]
+	}
+}
+
+func (p *pool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.endpoints)
+}
+
+// target returns the request URL rewritten onto the endpoint.
+func (ep *endpoint) target(u *url.URL) *url.URL {
+	out := *u
+	out.Scheme = ep.base.Scheme
+	out.Host = ep.base.Host
+	out.Path = ep.base.Path + u.Path
+	if u.RawPath != "" {
+		out.RawPath = ep.base.EscapedPath() + u.RawPath
+	}
+	return &out
+}
+
+func (b *Balancer) middleware(r *Request, next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		p, err := b.pool(req)
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			return next.RoundTrip(req)
+		}
+
+		maxFailover := b.options.MaxFailover
+		if maxFailover <= 0 {
+			maxFailover = p.size() - 1
+		}
+
+		tried := make(map[*endpoint]bool)
+		var lastErr error
+		for attempt := 0; attempt <= maxFailover; attempt++ {
+			ep := p.pick(tried)
+			if ep == nil {
+				break
+			}
+			tried[ep] = true
+
+			// the first attempt sends the body as is, a failover reads it again
+			out := req.Clone(req.Context())
+			if attempt > 0 {
+				if out, err = replay(req); err != nil {
+					return nil, err
+				}
+			}
+			out.URL = ep.target(req.URL)
+			out.Host = ""
+
+			// the endpoint is loaded until the response body is done
+			atomic.AddInt64(&ep.inFlight, 1)
+			done := sync.OnceFunc(func() { atomic.AddInt64(&ep.inFlight, -1) })
+			resp, err := next.RoundTrip(out)
+
+			if err == nil {
+				ep.cb.reportResult(!r.isErrorStatus(resp.StatusCode))
+				if bodyless(out, resp) {
+					done()
+				} else {
+					resp.Body = &releaseBody{ReadCloser: resp.Body, release: done}
+				}
+				return resp, nil
+			}
+			done()
+
+			// the caller gave up, the endpoint is not at fault
+			if req.Context().Err() != nil {
+				return nil, err
+			}
+			ep.cb.reportResult(false)
+			lastErr = err
+
+			// only connection errors are safe to send elsewhere
+			if !isConnectionError(err) || !replayable(req) {
+				return nil, err
+			}
+		}
+
+		if lastErr != nil {
+			return nil, lastErr
+		}
+		return nil, ErrNoEndpoint
+	})
+}
+
+// isConnectionError reports whether the request failed before it reached the server.
+func isConnectionError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func (r *Request) balancerMiddleware(next http.RoundTripper) http.RoundTripper {
+	if r.balancer == nil {
+		return next
+	}
+	return r.balancer.middleware(r, next)
+}
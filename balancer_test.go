@@ -0,0 +1,201 @@
+package hcl
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newNamedServer answers with its name, the path and the body it received.
+func newNamedServer(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %s", name, r.URL.RequestURI(), b)
+	}))
+}
+
+// closedURL is an address nothing listens on.
+func closedURL() string {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	return server.URL
+}
+
+func balancedGet(t *testing.T, hcl *HCL) string {
+	resp, err := New(hcl).SetUrl("http://billing/orders?id=1").Get()
+	assert.NoError(t, err)
+	b, _ := resp.ByteResult()
+	return string(b)
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	a, b := newNamedServer("a"), newNamedServer("b")
+	defer a.Close()
+	defer b.Close()
+
+	balancer, err := NewBalancer(BalancerOption{Endpoints: []Endpoint{{URL: a.URL + "/api"}, {URL: b.URL + "/api/"}}})
+	assert.NoError(t, err)
+	hcl := &HCL{Balancer: balancer}
+
+	counts := map[string]int{}
+	for i := 0; i < 4; i++ {
+		counts[balancedGet(t, hcl)]++
+	}
+	assert.Equal(t, map[string]int{"a /api/orders?id=1 ": 2, "b /api/orders?id=1 ": 2}, counts)
+}
+
+func TestBalancerWeighted(t *testing.T) {
+	a, b := newNamedServer("a"), newNamedServer("b")
+	defer a.Close()
+	defer b.Close()
+
+	balancer, _ := NewBalancer(BalancerOption{
+		Policy:    Weighted,
+		Endpoints: []Endpoint{{URL: a.URL, Weight: 3}, {URL: b.URL, Weight: 1}},
+	})
+	hcl := &HCL{Balancer: balancer}
+
+	counts := map[string]int{}
+	for i := 0; i < 8; i++ {
+		counts[balancedGet(t, hcl)[:1]]++
+	}
+	assert.Equal(t, map[string]int{"a": 6, "b": 2}, counts)
+}
+
+func TestBalancerLeastLoaded(t *testing.T) {
+	for _, policy := range []BalancerPolicy{LeastInFlight, PowerOfTwoChoices} {
+		balancer, _ := NewBalancer(BalancerOption{
+			Policy:    policy,
+			Endpoints: []Endpoint{{URL: "http://a"}, {URL: "http://b"}},
+		})
+		balancer.static.endpoints[0].inFlight = 5
+
+		for i := 0; i < 4; i++ {
+			assert.Equal(t, "http://b", balancer.static.pick(nil).base.String())
+		}
+	}
+}
+
+func TestBalancerFailover(t *testing.T) {
+	a := newNamedServer("a")
+	defer a.Close()
+	down := closedURL()
+
+	balancer, _ := NewBalancer(BalancerOption{
+		Endpoints: []Endpoint{{URL: down}, {URL: a.URL}},
+		Breaker:   CircuitBreakerOption{MaxFailures: 1, HalfOpenLimit: 1, ResetTimeout: time.Minute},
+	})
+	hcl := &HCL{Balancer: balancer}
+
+	for i := 0; i < 3; i++ {
+		resp, err := New(hcl).SetUrl("/orders").SetJsonPayload(map[string]string{"id": "1"}).Post()
+		assert.NoError(t, err)
+		b, _ := resp.ByteResult()
+		assert.Equal(t, `a /orders {"id":"1"}`, string(b))
+	}
+
+	// the failing endpoint is ejected
+	assert.Equal(t, []EndpointStatus{
+		{URL: down, State: OPEN},
+		{URL: a.URL, State: CLOSED},
+	}, balancer.Endpoints())
+}
+
+func TestBalancerPriority(t *testing.T) {
+	a, b, backup := newNamedServer("a"), newNamedServer("b"), newNamedServer("backup")
+	defer a.Close()
+	defer b.Close()
+	defer backup.Close()
+
+	balancer, _ := NewBalancer(BalancerOption{Endpoints: []Endpoint{
+		{URL: backup.URL, Priority: 1},
+		{URL: a.URL},
+		{URL: b.URL},
+	}})
+	hcl := &HCL{Balancer: balancer}
+
+	got := map[string]int{}
+	for i := 0; i < 4; i++ {
+		got[balancedGet(t, hcl)]++
+	}
+	assert.Equal(t, map[string]int{"a /orders?id=1 ": 2, "b /orders?id=1 ": 2}, got)
+
+	// the backup only serves while the whole primary group is out
+	balancer.static.endpoints[1].ejected.Store(true)
+	assert.Equal(t, "b /orders?id=1 ", balancedGet(t, hcl))
+	balancer.static.endpoints[2].ejected.Store(true)
+	assert.Equal(t, "backup /orders?id=1 ", balancedGet(t, hcl))
+
+	balancer.static.endpoints[1].ejected.Store(false)
+	assert.Equal(t, "a /orders?id=1 ", balancedGet(t, hcl))
+}
+
+func TestBalancerProbesOnlyTheChosenEndpoint(t *testing.T) {
+	balancer, _ := NewBalancer(BalancerOption{
+		Endpoints: []Endpoint{{URL: "http://a"}, {URL: "http://b"}},
+		Breaker:   CircuitBreakerOption{MaxFailures: 1, HalfOpenLimit: 1, ResetTimeout: time.Millisecond},
+	})
+	for _, ep := range balancer.static.endpoints {
+		ep.cb.reportResult(false)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// ranking does not move the other breaker to half-open
+	picked := balancer.static.pick(nil)
+	for _, status := range balancer.Endpoints() {
+		want := OPEN
+		if status.URL == picked.base.String() {
+			want = HALF_OPEN
+		}
+		assert.Equal(t, want, status.State, status.URL)
+	}
+}
+
+func TestBalancerLoadUntilBodyIsRead(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first"))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer server.Close()
+
+	balancer, _ := NewBalancer(BalancerOption{Policy: LeastInFlight, Endpoints: []Endpoint{{URL: server.URL}}})
+	hcl := &HCL{Balancer: balancer}
+
+	resp, err := New(hcl).SetUrl("/stream").Get()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, balancer.Endpoints()[0].InFlight)
+
+	close(release)
+	_, err = resp.ByteResult()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, balancer.Endpoints()[0].InFlight)
+}
+
+func TestBalancerNoEndpoint(t *testing.T) {
+	balancer, _ := NewBalancer(BalancerOption{
+		Endpoints: []Endpoint{{URL: closedURL()}},
+		Breaker:   CircuitBreakerOption{MaxFailures: 1, HalfOpenLimit: 1, ResetTimeout: time.Minute},
+	})
+	hcl := &HCL{Balancer: balancer}
+
+	_, err := New(hcl).SetUrl("/orders").Get()
+	assert.Error(t, err)
+
+	_, err = New(hcl).SetUrl("/orders").Get()
+	assert.ErrorIs(t, err, ErrNoEndpoint)
+}
+
+func TestNewBalancerInvalidEndpoint(t *testing.T) {
+	_, err := NewBalancer(BalancerOption{})
+	assert.EqualError(t, err, "balancer needs at least one endpoint")
+
+	_, err = NewBalancer(BalancerOption{Endpoints: []Endpoint{{URL: "billing:8080"}}})
+	assert.EqualError(t, err, "invalid endpoint URL: billing:8080")
+}
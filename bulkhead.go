@@ -0,0 +1,201 @@
+package hcl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrBulkheadFull is matched by the BulkheadError returned on overflow.
+var ErrBulkheadFull = errors.New("request refused. the bulkhead is full")
+
+// BulkheadError is returned when a request finds no free slot.
+type BulkheadError struct {
+	Key string
+	// Reason is "queue full" or "queue timeout".
+	Reason string
+}
+
+func (e *BulkheadError) Error() string {
+	return ErrBulkheadFull.Error() + " for " + e.Key + ": " + e.Reason
+}
+
+func (e *BulkheadError) Is(target error) bool {
+	return target == ErrBulkheadFull
+}
+
+type BulkheadOption struct {
+	// MaxConcurrent is the number of in-flight requests per key, defaults to 10.
+	MaxConcurrent int
+	// MaxQueue is the number of requests waiting for a slot per key, overflow
+	// is rejected. Zero rejects as soon as every slot is taken.
+	MaxQueue int
+	// QueueTimeout bounds the wait for a slot, zero waits until the request
+	// context is done.
+	QueueTimeout time.Duration
+}
+
+// Bulkhead caps the in-flight requests per host or key so a slow dependency
+// cannot exhaust goroutines and connections, see HCL.Bulkhead.
+type Bulkhead struct {
+	options BulkheadOption
+
+	mu           sync.Mutex
+	compartments map[string]*compartment
+}
+
+type compartment struct {
+	slots   chan struct{}
+	waiting int
+}
+
+func NewBulkhead(options BulkheadOption) *Bulkhead {
+	if options.MaxConcurrent <= 0 {
+		options.MaxConcurrent = 10
+	}
+	if options.MaxQueue < 0 {
+		options.MaxQueue = 0
+	}
+
+	return &Bulkhead{
+		options:      options,
+		compartments: make(map[string]*compartment),
+	}
+}
+
+func (b *Bulkhead) compartment(key string) *compartment {
+	c, ok := b.compartments[key]
+	if !ok {
+		c = &compartment{slots: make(chan struct{}, b.options.MaxConcurrent)}
+		b.compartments[key] = c
+	}
+	return c
+}
+
+// acquire takes a slot for key, the returned func gives it back.
+func (b *Bulkhead) acquire(ctx context.Context, key string) (func(), error) {
+	b.mu.Lock()
+	c := b.compartment(key)
+	release := func() { <-c.slots }
+
+	select {
+	case c.slots <- struct{}{}:
+		b.mu.Unlock()
+		return release, nil
+	default:
+	}
+
+	if c.waiting >= b.options.MaxQueue {
+		b.mu.Unlock()
+		return nil, &BulkheadError{Key: key, Reason: "queue full"}
+	}
+	c.waiting++
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		c.waiting--
+		b.mu.Unlock()
+	}()
+
+	var timeout <-chan time.Time
+	if b.options.QueueTimeout > 0 {
+		timer := time.NewTimer(b.options.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case c.slots <- struct{}{}:
+		return release, nil
+	case <-timeout:
+		return nil, &BulkheadError{Key: key, Reason: "queue timeout"}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// InFlight returns the number of requests holding a slot for key.
+func (b *Bulkhead) InFlight(key string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if c, ok := b.compartments[key]; ok {
+		return len(c.slots)
+	}
+	return 0
+}
+
+func (r *Request) SetBulkheadKey(key string) *Request {
+	// Check if the request object is nil
+	if r == nil {
+		return nil
+	}
+
+	if key == "" {
+		r.errs = append(r.errs, errors.New("bulkhead key cannot be empty"))
+		return r
+	}
+
+	r.bulkheadKey = key
+	return r
+}
+
+// bulkheadMiddleware holds a slot until the response body is done, the key
+// defaults to the URL host. A body that is never read nor closed keeps its
+// slot.
+func (r *Request) bulkheadMiddleware(next http.RoundTripper) http.RoundTripper {
+	if r.bulkhead == nil {
+		return next
+	}
+
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		key := r.bulkheadKey
+		if key == "" {
+			key = req.URL.Host
+		}
+
+		release, err := r.bulkhead.acquire(req.Context(), key)
+		if err != nil {
+			if errors.Is(err, ErrBulkheadFull) {
+				if r.metrics != nil {
+					r.metrics.IncRejected(req.URL.Host, "bulkhead")
+				}
+				r.tracing.refused(req.Context(), req.Method, req.URL, r.auth.sensitiveQuery(), err)
+			}
+			return nil, err
+		}
+		resp, err := next.RoundTrip(req)
+		if err != nil || bodyless(req, resp) {
+			release()
+			return resp, err
+		}
+		resp.Body = &releaseBody{ReadCloser: resp.Body, release: sync.OnceFunc(release)}
+		return resp, nil
+	})
+}
+
+// releaseBody calls release once the body is closed or read to the end,
+// ByteResult reads it without closing it. It frees the bulkhead and adaptive
+// slots and the load of a balancer endpoint.
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.release()
+	}
+	return n, err
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
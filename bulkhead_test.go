@@ -0,0 +1,195 @@
+package hcl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingServer holds every request until release is closed.
+func newBlockingServer(calls *int32, release chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func TestBulkheadRejectsOverflow(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	server := newBlockingServer(&calls, release)
+	defer server.Close()
+
+	metrics := newFakeMetrics()
+	hcl := &HCL{Bulkhead: NewBulkhead(BulkheadOption{MaxConcurrent: 2}), Metrics: metrics}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := New(hcl).SetUrl(server.URL).Get()
+			assert.NoError(t, err)
+		}()
+	}
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&calls) == 2 }, time.Second, 5*time.Millisecond)
+
+	_, err := New(hcl).SetUrl(server.URL).Get()
+	assert.ErrorIs(t, err, ErrBulkheadFull)
+
+	var bulkheadErr *BulkheadError
+	assert.True(t, errors.As(err, &bulkheadErr))
+	assert.Equal(t, "queue full", bulkheadErr.Reason)
+	assert.Equal(t, []string{"bulkhead"}, metrics.rejected)
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, 0, hcl.Bulkhead.InFlight(server.URL[len("http://"):]))
+}
+
+func TestBulkheadQueue(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	server := newBlockingServer(&calls, release)
+	defer server.Close()
+
+	hcl := &HCL{Bulkhead: NewBulkhead(BulkheadOption{MaxConcurrent: 1, MaxQueue: 1})}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := New(hcl).SetUrl(server.URL).Get()
+			assert.NoError(t, err)
+		}()
+	}
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&calls) == 1 }, time.Second, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	// one in flight, one queued, the third overflows
+	_, err := New(hcl).SetUrl(server.URL).Get()
+	assert.ErrorIs(t, err, ErrBulkheadFull)
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestBulkheadHoldsSlotUntilBodyIsRead(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first"))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte(" chunk"))
+	}))
+	defer server.Close()
+
+	hcl := &HCL{Bulkhead: NewBulkhead(BulkheadOption{MaxConcurrent: 1})}
+	key := server.URL[len("http://"):]
+
+	resp, err := New(hcl).SetUrl(server.URL).Get()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, hcl.Bulkhead.InFlight(key))
+
+	// the body is still streaming
+	_, err = New(hcl).SetUrl(server.URL).Get()
+	assert.ErrorIs(t, err, ErrBulkheadFull)
+
+	close(release)
+	body, err := resp.ByteResult()
+	assert.NoError(t, err)
+	assert.Equal(t, "first chunk", string(body))
+	assert.Equal(t, 0, hcl.Bulkhead.InFlight(key))
+
+	resp, err = New(hcl).SetUrl(server.URL).Get()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, hcl.Bulkhead.InFlight(key))
+	resp.Body.Close()
+	assert.Equal(t, 0, hcl.Bulkhead.InFlight(key))
+}
+
+func TestBulkheadReleasesBodylessResponses(t *testing.T) {
+	status := http.StatusNotModified
+	client := &http.Client{Transport: RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		// the headers announce a body that never comes
+		return &http.Response{
+			StatusCode:    status,
+			Header:        http.Header{"Content-Length": {"10"}},
+			ContentLength: 10,
+			Body:          io.NopCloser(strings.NewReader("")),
+			Request:       req,
+		}, nil
+	})}
+	hcl := &HCL{Client: client, Bulkhead: NewBulkhead(BulkheadOption{MaxConcurrent: 1})}
+
+	for _, code := range []int{http.StatusNotModified, http.StatusNoContent} {
+		status = code
+		_, err := New(hcl).SetUrl("http://svc/orders").Get()
+		assert.NoError(t, err)
+		assert.Equal(t, 0, hcl.Bulkhead.InFlight("svc"))
+	}
+
+	head := httptest.NewRequest(http.MethodHead, "http://svc/orders", nil)
+	assert.True(t, bodyless(head, &http.Response{StatusCode: http.StatusOK, ContentLength: 10, Body: io.NopCloser(strings.NewReader(""))}))
+}
+
+func TestBulkheadQueueTimeout(t *testing.T) {
+	bulkhead := NewBulkhead(BulkheadOption{MaxConcurrent: 1, MaxQueue: 1, QueueTimeout: 20 * time.Millisecond})
+	release, err := bulkhead.acquire(context.Background(), "svc")
+	assert.NoError(t, err)
+	defer release()
+
+	_, err = bulkhead.acquire(context.Background(), "svc")
+	assert.EqualError(t, err, "request refused. the bulkhead is full for svc: queue timeout")
+
+	// the context ends the wait first
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = bulkhead.acquire(ctx, "svc")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// other keys have their own slots
+	other, err := bulkhead.acquire(context.Background(), "other")
+	assert.NoError(t, err)
+	other()
+}
+
+func TestBulkheadReportsCircuitBreaker(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	server := newBlockingServer(&calls, release)
+	defer server.Close()
+
+	hcl := &HCL{
+		Bulkhead: NewBulkhead(BulkheadOption{MaxConcurrent: 1}),
+		Cb:       NewCircuitBreaker(CircuitBreakerOption{MaxFailures: 1, HalfOpenLimit: 1, ResetTimeout: time.Minute}),
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, _ = New(hcl).SetUrl(server.URL).SetBulkheadKey("svc").Get()
+	}()
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&calls) == 1 }, time.Second, 5*time.Millisecond)
+
+	req := New(hcl).SetUrl(server.URL).SetBulkheadKey("svc")
+	_, err := req.Get()
+	assert.ErrorIs(t, err, ErrBulkheadFull)
+	assert.Equal(t, OPEN, req.Cb.state)
+
+	close(release)
+	wg.Wait()
+}
@@ -0,0 +1,456 @@
+package hcl
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cache statuses reported by Response.CacheStatus and in the log.
+const (
+	CacheHit         = "HIT"
+	CacheMiss        = "MISS"
+	CacheRevalidated = "REVALIDATED"
+	CacheStale       = "STALE"
+	CacheBypass      = "BYPASS"
+)
+
+const (
+	headerCacheControl = "Cache-Control"
+	headerETag         = "ETag"
+	headerLastModified = "Last-Modified"
+	headerVary         = "Vary"
+	headerAge          = "Age"
+	headerDate         = "Date"
+	headerExpires      = "Expires"
+)
+
+// CachedResponse is a stored response with the values needed to compute its age.
+type CachedResponse struct {
+	StatusCode   int               `json:"statusCode"`
+	Header       http.Header       `json:"header"`
+	Body         []byte            `json:"body"`
+	RequestTime  time.Time         `json:"requestTime"`
+	ResponseTime time.Time         `json:"responseTime"`
+	Vary         map[string]string `json:"vary,omitempty"`
+}
+
+// CacheStore persists cached responses, Get returns nil without error on a miss.
+type CacheStore interface {
+	Get(ctx context.Context, key string) (*CachedResponse, error)
+	Set(ctx context.Context, key string, entry *CachedResponse, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+type CacheOption struct {
+	// Store defaults to an in-memory LRU of 1000 entries.
+	Store CacheStore
+	// MaxBodySize is the largest body that is cached, defaults to 1 MiB.
+	MaxBodySize int64
+	// KeepStale keeps entries with a validator this long after they become
+	// stale so they can be revalidated, defaults to 1 hour.
+	KeepStale time.Duration
+	// Clock defaults to time.Now.
+	Clock func() time.Time
+	// Shared follows the rules of a shared cache and never stores responses
+	// marked private, it is implied by RedisCacheStore.
+	Shared bool
+}
+
+// Cache is a private RFC 9111 cache for GET requests, see HCL.Cache.
+type Cache struct {
+	options CacheOption
+	group   flightGroup
+}
+
+func NewCache(options CacheOption) *Cache {
+	if options.Store == nil {
+		options.Store = NewMemoryCacheStore(1000)
+	}
+	if options.MaxBodySize <= 0 {
+		options.MaxBodySize = 1 << 20
+	}
+	if options.KeepStale <= 0 {
+		options.KeepStale = time.Hour
+	}
+	if options.Clock == nil {
+		options.Clock = time.Now
+	}
+	if _, ok := options.Store.(*RedisCacheStore); ok {
+		options.Shared = true
+	}
+
+	return &Cache{options: options}
+}
+
+// cacheControl holds the parsed Cache-Control directives, a directive without
+// a value maps to "".
+type cacheControl map[string]string
+
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, value := range header.Values(headerCacheControl) {
+		for _, directive := range strings.Split(value, ",") {
+			name, val, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name == "" {
+				continue
+			}
+			cc[strings.ToLower(name)] = strings.Trim(val, `"`)
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	val, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+var cacheableStatus = []int{
+	http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+	http.StatusMultipleChoices, http.StatusMovedPermanently, http.StatusPermanentRedirect,
+	http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusGone,
+	http.StatusRequestURITooLong, http.StatusNotImplemented,
+}
+
+// key holds one variant per URL, a response with Vary replaces the variant
+// stored before it.
+func (c *Cache) key(req *http.Request) string {
+	return "hcl:cache:" + req.URL.String()
+}
+
+// middleware serves fresh responses from the store, revalidates stale ones
+// and invalidates the URL after unsafe requests. A response to a request
+// carrying one of the credential headers is private to its caller.
+func (c *Cache) middleware(credentials []string, next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		ex := exchangeFrom(req.Context())
+
+		// only GET is cached, the key of the URL is dropped after unsafe methods
+		if req.Method != http.MethodGet {
+			resp, err := next.RoundTrip(req)
+			if err == nil && req.Method != http.MethodHead && req.Method != http.MethodOptions && resp.StatusCode < 400 {
+				_ = c.options.Store.Delete(req.Context(), c.key(req))
+			}
+			ex.setCacheStatus(CacheBypass)
+			return resp, err
+		}
+
+		reqCC := parseCacheControl(req.Header)
+		if reqCC.has("no-store") {
+			ex.setCacheStatus(CacheBypass)
+			return next.RoundTrip(req)
+		}
+
+		key := c.key(req)
+		entry, err := c.options.Store.Get(req.Context(), key)
+		if err != nil || entry == nil || !entry.matches(req) {
+			ex.setCacheStatus(CacheMiss)
+			resp, _, err := c.fetch(next, req, credentials, key, nil)
+			return resp, err
+		}
+
+		now := c.options.Clock()
+		respCC := parseCacheControl(entry.Header)
+		age := entry.age(now)
+		lifetime := entry.freshness(respCC)
+		mustRevalidate := reqCC.has("no-cache") || respCC.has("no-cache")
+		if maxAge, ok := reqCC.seconds("max-age"); ok && age > maxAge {
+			mustRevalidate = true
+		}
+
+		if age < lifetime && !mustRevalidate {
+			ex.setCacheStatus(CacheHit)
+			return entry.response(req, age), nil
+		}
+
+		staleFor := age - lifetime
+		if swr, ok := respCC.seconds("stale-while-revalidate"); ok && !mustRevalidate && staleFor < swr {
+			c.revalidateInBackground(next, req, credentials, key, entry)
+			ex.setCacheStatus(CacheStale)
+			return entry.response(req, age), nil
+		}
+
+		resp, revalidated, err := c.revalidate(next, req, credentials, key, entry)
+		if (err != nil || resp.StatusCode >= 500) && entry.staleIfError(reqCC, respCC, staleFor) {
+			if resp != nil {
+				drainBody(resp)
+			}
+			ex.setCacheStatus(CacheStale)
+			return entry.response(req, age), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if revalidated {
+			ex.setCacheStatus(CacheRevalidated)
+			return resp, nil
+		}
+		ex.setCacheStatus(CacheMiss)
+		return resp, nil
+	})
+}
+
+// revalidate sends a conditional request, a 304 refreshes the stored entry
+// and is answered with it.
+func (c *Cache) revalidate(next http.RoundTripper, req *http.Request, credentials []string, key string, entry *CachedResponse) (*http.Response, bool, error) {
+	conditional := req.Clone(req.Context())
+	if etag := entry.Header.Get(headerETag); etag != "" {
+		conditional.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := entry.Header.Get(headerLastModified); lastModified != "" {
+		conditional.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	return c.fetch(next, conditional, credentials, key, entry)
+}
+
+func (c *Cache) revalidateInBackground(next http.RoundTripper, req *http.Request, credentials []string, key string, entry *CachedResponse) {
+	// the revalidation outlives the request and is not one of its attempts
+	background := req.Clone(withExchange(context.WithoutCancel(req.Context())))
+	go func() {
+		_, _, _ = c.group.doRecover(key, func() (interface{}, error) {
+			resp, _, err := c.revalidate(next, background, credentials, key, entry)
+			if err == nil {
+				drainBody(resp)
+			}
+			return nil, err
+		})
+	}()
+}
+
+// fetch sends the request and stores the response when it is cacheable. A
+// 304 for the stale entry refreshes it and reports revalidated.
+func (c *Cache) fetch(next http.RoundTripper, req *http.Request, credentials []string, key string, stale *CachedResponse) (*http.Response, bool, error) {
+	requestTime := c.options.Clock()
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, false, err
+	}
+	responseTime := c.options.Clock()
+
+	if resp.StatusCode == http.StatusNotModified && stale != nil {
+		drainBody(resp)
+		updated := *stale
+		updated.Header = stale.Header.Clone()
+		for name, values := range resp.Header {
+			updated.Header[name] = values
+		}
+		updated.RequestTime, updated.ResponseTime = requestTime, responseTime
+		c.store(req, key, &updated)
+
+		// callers see the stored representation, the 304 is only reported as the cache status
+		return updated.response(req, updated.age(responseTime)), true, nil
+	}
+
+	if !c.storable(req, resp, credentials) {
+		return resp, false, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, c.options.MaxBodySize+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, false, err
+	}
+	if int64(len(body)) > c.options.MaxBodySize {
+		resp.Body = readCloser{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, false, nil
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	c.store(req, key, &CachedResponse{
+		StatusCode:   resp.StatusCode,
+		Header:       resp.Header.Clone(),
+		Body:         body,
+		RequestTime:  requestTime,
+		ResponseTime: responseTime,
+		Vary:         varyValues(req, resp.Header),
+	})
+	return resp, false, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func (c *Cache) storable(req *http.Request, resp *http.Response, credentials []string) bool {
+	if !inArray(resp.StatusCode, cacheableStatus) {
+		return false
+	}
+
+	cc := parseCacheControl(resp.Header)
+	if cc.has("no-store") || parseCacheControl(req.Header).has("no-store") {
+		return false
+	}
+	if cc.has("private") && c.options.Shared {
+		return false
+	}
+	// the key is only the URL, a response to a request with credentials would
+	// be served to other callers, see RFC 9111 section 3.5. The token sources
+	// authorize the request further down the pipeline.
+	authorized := hasCredentials(req.Header, credentials) ||
+		resp.Request != nil && hasCredentials(resp.Request.Header, credentials)
+	if authorized && !cc.has("public") && !cc.has("s-maxage") && !cc.has("must-revalidate") {
+		return false
+	}
+	for _, vary := range resp.Header.Values(headerVary) {
+		if strings.TrimSpace(vary) == "*" {
+			return false
+		}
+	}
+
+	entry := &CachedResponse{Header: resp.Header}
+	return entry.freshness(cc) > 0 || entry.hasValidator() || cc.has("no-cache")
+}
+
+func (c *Cache) store(req *http.Request, key string, entry *CachedResponse) {
+	cc := parseCacheControl(entry.Header)
+	ttl := entry.freshness(cc) - entry.age(c.options.Clock())
+
+	var stale time.Duration
+	if swr, ok := cc.seconds("stale-while-revalidate"); ok {
+		stale = swr
+	}
+	if sie, ok := cc.seconds("stale-if-error"); ok && sie > stale {
+		stale = sie
+	}
+	if entry.hasValidator() && c.options.KeepStale > stale {
+		stale = c.options.KeepStale
+	}
+
+	ttl += stale
+	if ttl <= 0 {
+		return
+	}
+	_ = c.options.Store.Set(req.Context(), key, entry, ttl)
+}
+
+func varyValues(req *http.Request, header http.Header) map[string]string {
+	var values map[string]string
+	for _, vary := range header.Values(headerVary) {
+		for _, name := range strings.Split(vary, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if values == nil {
+				values = make(map[string]string)
+			}
+			values[name] = strings.Join(req.Header.Values(name), ",")
+		}
+	}
+	return values
+}
+
+// matches reports whether the request selects the stored variant.
+func (e *CachedResponse) matches(req *http.Request) bool {
+	for name, value := range e.Vary {
+		if strings.Join(req.Header.Values(name), ",") != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *CachedResponse) hasValidator() bool {
+	return e.Header.Get(headerETag) != "" || e.Header.Get(headerLastModified) != ""
+}
+
+// freshness is the freshness lifetime of RFC 9111 section 4.2.1, with the
+// heuristic of 10% of the time since Last-Modified.
+func (e *CachedResponse) freshness(cc cacheControl) time.Duration {
+	if maxAge, ok := cc.seconds("max-age"); ok {
+		return maxAge
+	}
+
+	date := e.date()
+	if expires := e.Header.Get(headerExpires); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		return t.Sub(date)
+	}
+
+	if lastModified, err := http.ParseTime(e.Header.Get(headerLastModified)); err == nil && date.After(lastModified) {
+		return min(date.Sub(lastModified)/10, 24*time.Hour)
+	}
+	return 0
+}
+
+func (e *CachedResponse) date() time.Time {
+	if date, err := http.ParseTime(e.Header.Get(headerDate)); err == nil {
+		return date
+	}
+	return e.ResponseTime
+}
+
+// age is the current age of RFC 9111 section 4.2.3.
+func (e *CachedResponse) age(now time.Time) time.Duration {
+	apparent := max(e.ResponseTime.Sub(e.date()), 0)
+
+	var ageValue time.Duration
+	if n, err := strconv.ParseInt(e.Header.Get(headerAge), 10, 64); err == nil {
+		ageValue = time.Duration(n) * time.Second
+	}
+	corrected := ageValue + e.ResponseTime.Sub(e.RequestTime)
+
+	return max(apparent, corrected) + now.Sub(e.ResponseTime)
+}
+
+func (e *CachedResponse) staleIfError(reqCC, respCC cacheControl, staleFor time.Duration) bool {
+	if respCC.has("must-revalidate") || respCC.has("no-cache") {
+		return false
+	}
+	for _, cc := range []cacheControl{reqCC, respCC} {
+		if sie, ok := cc.seconds("stale-if-error"); ok && staleFor < sie {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *CachedResponse) response(req *http.Request, age time.Duration) *http.Response {
+	header := e.Header.Clone()
+	header.Set(headerAge, strconv.FormatInt(int64(age/time.Second), 10))
+
+	return &http.Response{
+		Status:        strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// CacheStatus returns how the cache answered the request, it is empty when
+// the client has no cache.
+func (r *Response) CacheStatus() string {
+	if r == nil || r.Request == nil {
+		return ""
+	}
+	return exchangeFrom(r.Request.Context()).cacheStatus()
+}
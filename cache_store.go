@@ -0,0 +1,140 @@
+package hcl
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// MemoryCacheStore is an in-memory LRU store.
+type MemoryCacheStore struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type memoryCacheItem struct {
+	key     string
+	entry   *CachedResponse
+	expires time.Time
+}
+
+func NewMemoryCacheStore(maxEntries int) *MemoryCacheStore {
+	if maxEntries <= 0 {
+		maxEntries = 1000
+	}
+
+	return &MemoryCacheStore{
+		maxEntries: maxEntries,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+func (s *MemoryCacheStore) Get(_ context.Context, key string) (*CachedResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+
+	item := el.Value.(*memoryCacheItem)
+	if time.Now().After(item.expires) {
+		s.remove(el)
+		return nil, nil
+	}
+
+	s.lru.MoveToFront(el)
+	return item.entry, nil
+}
+
+func (s *MemoryCacheStore) Set(_ context.Context, key string, entry *CachedResponse, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item := &memoryCacheItem{key: key, entry: entry, expires: time.Now().Add(ttl)}
+	if el, ok := s.entries[key]; ok {
+		el.Value = item
+		s.lru.MoveToFront(el)
+		return nil
+	}
+
+	s.entries[key] = s.lru.PushFront(item)
+	for s.lru.Len() > s.maxEntries {
+		s.remove(s.lru.Back())
+	}
+	return nil
+}
+
+func (s *MemoryCacheStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[key]; ok {
+		s.remove(el)
+	}
+	return nil
+}
+
+// Len returns the number of stored entries, including expired ones not yet evicted.
+func (s *MemoryCacheStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lru.Len()
+}
+
+func (s *MemoryCacheStore) remove(el *list.Element) {
+	s.lru.Remove(el)
+	delete(s.entries, el.Value.(*memoryCacheItem).key)
+}
+
+// RedisCacheStore shares cached responses between instances through Redis.
+type RedisCacheStore struct {
+	Client *redis.Client
+	// Prefix is prepended to every key.
+	Prefix string
+}
+
+func NewRedisCacheStore(conf *RedisCacheStore) *RedisCacheStore {
+	return &RedisCacheStore{
+		Client: conf.Client,
+		Prefix: conf.Prefix,
+	}
+}
+
+func (s *RedisCacheStore) Get(ctx context.Context, key string) (*CachedResponse, error) {
+	b, err := s.Client.Get(ctx, s.Prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &CachedResponse{}
+	if err = json.Unmarshal(b, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (s *RedisCacheStore) Set(ctx context.Context, key string, entry *CachedResponse, ttl time.Duration) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.Client.Set(ctx, s.Prefix+key, b, ttl).Err()
+}
+
+func (s *RedisCacheStore) Delete(ctx context.Context, key string) error {
+	return s.Client.Del(ctx, s.Prefix+key).Err()
+}
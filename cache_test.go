@@ -0,0 +1,381 @@
+package hcl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Now()}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// cacheServer answers with the given Cache-Control and an ETag, conditional
+// requests with a matching ETag get a 304.
+type cacheServer struct {
+	*httptest.Server
+	calls        int32
+	conditionals int32
+	status       int32
+}
+
+func newCacheServer(cacheControl string, clock *fakeClock) *cacheServer {
+	cs := &cacheServer{status: http.StatusOK}
+	cs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&cs.calls, 1)
+		if status := int(atomic.LoadInt32(&cs.status)); status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+
+		w.Header().Set("Date", clock.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Cache-Control", cacheControl)
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&cs.conditionals, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"call":%d}`, n)
+	}))
+	return cs
+}
+
+func cachedGet(t *testing.T, hcl *HCL, url string) (*Response, string) {
+	resp, err := New(hcl).SetUrl(url).Get()
+	assert.NoError(t, err)
+	body, _ := resp.ByteResult()
+	return resp, string(body)
+}
+
+func TestCacheFreshHit(t *testing.T) {
+	clock := newFakeClock()
+	cs := newCacheServer("max-age=60", clock)
+	defer cs.Close()
+
+	hcl := &HCL{Cache: NewCache(CacheOption{Clock: clock.Now})}
+
+	resp, body := cachedGet(t, hcl, cs.URL)
+	assert.Equal(t, CacheMiss, resp.CacheStatus())
+	assert.Equal(t, `{"call":1}`, body)
+
+	clock.Advance(30 * time.Second)
+	resp, body = cachedGet(t, hcl, cs.URL)
+	assert.Equal(t, CacheHit, resp.CacheStatus())
+	assert.Equal(t, `{"call":1}`, body)
+	assert.Equal(t, "30", resp.Header.Get("Age"))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&cs.calls))
+}
+
+func TestCacheRevalidation(t *testing.T) {
+	clock := newFakeClock()
+	cs := newCacheServer("max-age=60", clock)
+	defer cs.Close()
+
+	hcl := &HCL{Cache: NewCache(CacheOption{Clock: clock.Now})}
+	cachedGet(t, hcl, cs.URL)
+
+	clock.Advance(2 * time.Minute)
+	resp, body := cachedGet(t, hcl, cs.URL)
+	assert.Equal(t, CacheRevalidated, resp.CacheStatus())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `{"call":1}`, body)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&cs.conditionals))
+
+	// the 304 refreshed the entry
+	resp, _ = cachedGet(t, hcl, cs.URL)
+	assert.Equal(t, CacheHit, resp.CacheStatus())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&cs.calls))
+}
+
+func TestCacheNoCacheAlwaysRevalidates(t *testing.T) {
+	cs := newCacheServer("no-cache", newFakeClock())
+	defer cs.Close()
+
+	hcl := &HCL{Cache: NewCache(CacheOption{})}
+	cachedGet(t, hcl, cs.URL)
+
+	resp, body := cachedGet(t, hcl, cs.URL)
+	assert.Equal(t, CacheRevalidated, resp.CacheStatus())
+	assert.Equal(t, `{"call":1}`, body)
+}
+
+func TestCacheNoStore(t *testing.T) {
+	cs := newCacheServer("no-store", newFakeClock())
+	defer cs.Close()
+
+	hcl := &HCL{Cache: NewCache(CacheOption{})}
+	cachedGet(t, hcl, cs.URL)
+	resp, body := cachedGet(t, hcl, cs.URL)
+
+	assert.Equal(t, CacheMiss, resp.CacheStatus())
+	assert.Equal(t, `{"call":2}`, body)
+}
+
+func TestCacheAuthorization(t *testing.T) {
+	for cacheControl, stored := range map[string]bool{
+		"max-age=60":                  false,
+		"max-age=60, public":          true,
+		"s-maxage=60":                 true,
+		"max-age=60, must-revalidate": true,
+	} {
+		t.Run(cacheControl, func(t *testing.T) {
+			cs := newCacheServer(cacheControl, newFakeClock())
+			defer cs.Close()
+
+			hcl := &HCL{Cache: NewCache(CacheOption{})}
+			New(hcl).SetUrl(cs.URL).SetBearerToken("token-a").Get()
+			resp, err := New(hcl).SetUrl(cs.URL).SetBearerToken("token-b").Get()
+			assert.NoError(t, err)
+			assert.Equal(t, stored, resp.CacheStatus() != CacheMiss)
+		})
+	}
+
+	t.Run("token source", func(t *testing.T) {
+		cs := newCacheServer("max-age=60", newFakeClock())
+		defer cs.Close()
+
+		hcl := &HCL{Cache: NewCache(CacheOption{}), TokenSource: staticTokenSource("token")}
+		cachedGet(t, hcl, cs.URL)
+		resp, _ := cachedGet(t, hcl, cs.URL)
+		assert.Equal(t, CacheMiss, resp.CacheStatus())
+	})
+
+	t.Run("api key and cookie", func(t *testing.T) {
+		cs := newCacheServer("max-age=60", newFakeClock())
+		defer cs.Close()
+
+		hcl := &HCL{Cache: NewCache(CacheOption{}), APIKey: &APIKey{In: APIKeyInHeader, Name: "X-Partner-Key", Value: "key-a"}}
+		cachedGet(t, hcl, cs.URL)
+		resp, _ := cachedGet(t, hcl, cs.URL)
+		assert.Equal(t, CacheMiss, resp.CacheStatus())
+
+		hcl = &HCL{Cache: NewCache(CacheOption{})}
+		New(hcl).SetUrl(cs.URL).SetHeader("Cookie", "session=a").Get()
+		resp, err := New(hcl).SetUrl(cs.URL).SetHeader("Cookie", "session=b").Get()
+		assert.NoError(t, err)
+		assert.Equal(t, CacheMiss, resp.CacheStatus())
+	})
+}
+
+type staticTokenSource string
+
+func (s staticTokenSource) Token(context.Context) (*Token, error) {
+	return &Token{AccessToken: string(s), TokenType: "Bearer"}, nil
+}
+
+func TestCachePrivateInSharedStore(t *testing.T) {
+	cs := newCacheServer("max-age=60, private", newFakeClock())
+	defer cs.Close()
+
+	hcl := &HCL{Cache: NewCache(CacheOption{Shared: true})}
+	cachedGet(t, hcl, cs.URL)
+	resp, body := cachedGet(t, hcl, cs.URL)
+	assert.Equal(t, CacheMiss, resp.CacheStatus())
+	assert.Equal(t, `{"call":2}`, body)
+
+	// a private cache keeps them
+	hcl = &HCL{Cache: NewCache(CacheOption{})}
+	cachedGet(t, hcl, cs.URL)
+	resp, _ = cachedGet(t, hcl, cs.URL)
+	assert.Equal(t, CacheHit, resp.CacheStatus())
+
+	db, _ := redismock.NewClientMock()
+	assert.True(t, NewCache(CacheOption{Store: NewRedisCacheStore(&RedisCacheStore{Client: db})}).options.Shared)
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	clock := newFakeClock()
+	cs := newCacheServer("max-age=60, stale-while-revalidate=60", clock)
+	defer cs.Close()
+
+	hcl := &HCL{Cache: NewCache(CacheOption{Clock: clock.Now})}
+	cachedGet(t, hcl, cs.URL)
+
+	clock.Advance(90 * time.Second)
+	resp, body := cachedGet(t, hcl, cs.URL)
+	assert.Equal(t, CacheStale, resp.CacheStatus())
+	assert.Equal(t, `{"call":1}`, body)
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&cs.conditionals) == 1
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestCacheStaleIfError(t *testing.T) {
+	clock := newFakeClock()
+	cs := newCacheServer("max-age=60, stale-if-error=300", clock)
+	defer cs.Close()
+
+	hcl := &HCL{Cache: NewCache(CacheOption{Clock: clock.Now})}
+	cachedGet(t, hcl, cs.URL)
+
+	atomic.StoreInt32(&cs.status, http.StatusServiceUnavailable)
+	clock.Advance(2 * time.Minute)
+	resp, body := cachedGet(t, hcl, cs.URL)
+	assert.Equal(t, CacheStale, resp.CacheStatus())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `{"call":1}`, body)
+
+	// past the stale-if-error window the error is returned
+	clock.Advance(10 * time.Minute)
+	resp, _ = cachedGet(t, hcl, cs.URL)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestCacheVary(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		fmt.Fprint(w, r.Header.Get("Accept-Language"))
+	}))
+	defer server.Close()
+
+	hcl := &HCL{Cache: NewCache(CacheOption{})}
+	get := func(lang string) *Response {
+		resp, err := New(hcl).SetUrl(server.URL).SetHeader("Accept-Language", lang).Get()
+		assert.NoError(t, err)
+		return resp
+	}
+
+	assert.Equal(t, CacheMiss, get("id").CacheStatus())
+	assert.Equal(t, CacheHit, get("id").CacheStatus())
+	assert.Equal(t, CacheMiss, get("en").CacheStatus())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestCacheExpires(t *testing.T) {
+	clock := newFakeClock()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := clock.Now()
+		w.Header().Set("Date", now.UTC().Format(http.TimeFormat))
+		w.Header().Set("Expires", now.Add(time.Minute).UTC().Format(http.TimeFormat))
+		fmt.Fprint(w, "catalog")
+	}))
+	defer server.Close()
+
+	hcl := &HCL{Cache: NewCache(CacheOption{Clock: clock.Now})}
+	cachedGet(t, hcl, server.URL)
+
+	clock.Advance(30 * time.Second)
+	resp, _ := cachedGet(t, hcl, server.URL)
+	assert.Equal(t, CacheHit, resp.CacheStatus())
+
+	clock.Advance(time.Minute)
+	resp, _ = cachedGet(t, hcl, server.URL)
+	assert.Equal(t, CacheMiss, resp.CacheStatus())
+}
+
+func TestCacheUnsafeMethodInvalidates(t *testing.T) {
+	cs := newCacheServer("max-age=60", newFakeClock())
+	defer cs.Close()
+
+	hcl := &HCL{Cache: NewCache(CacheOption{})}
+	cachedGet(t, hcl, cs.URL)
+
+	_, err := New(hcl).SetUrl(cs.URL).SetJsonPayload(map[string]string{"a": "b"}).Put()
+	assert.NoError(t, err)
+
+	resp, _ := cachedGet(t, hcl, cs.URL)
+	assert.Equal(t, CacheMiss, resp.CacheStatus())
+}
+
+func TestCacheStatusInLog(t *testing.T) {
+	cs := newCacheServer("max-age=60", newFakeClock())
+	defer cs.Close()
+
+	hcl := &HCL{Cache: NewCache(CacheOption{})}
+	cachedGet(t, hcl, cs.URL)
+
+	output := captureOutput(func() {
+		_, _ = New(hcl).EnableLog(false).SetUrl(cs.URL).Get()
+	})
+	assert.Contains(t, output, `"cache":"HIT"`)
+	assert.Contains(t, output, `"body":"{\"call\":1}"`)
+}
+
+func TestMemoryCacheStoreLRU(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryCacheStore(2)
+
+	_ = store.Set(ctx, "a", &CachedResponse{StatusCode: 200}, time.Minute)
+	_ = store.Set(ctx, "b", &CachedResponse{StatusCode: 200}, time.Minute)
+	_, _ = store.Get(ctx, "a")
+	_ = store.Set(ctx, "c", &CachedResponse{StatusCode: 200}, time.Minute)
+
+	a, _ := store.Get(ctx, "a")
+	b, _ := store.Get(ctx, "b")
+	assert.NotNil(t, a)
+	assert.Nil(t, b)
+	assert.Equal(t, 2, store.Len())
+
+	_ = store.Set(ctx, "d", &CachedResponse{StatusCode: 200}, -time.Second)
+	d, _ := store.Get(ctx, "d")
+	assert.Nil(t, d)
+}
+
+func TestRedisCacheStore(t *testing.T) {
+	ctx := context.Background()
+	db, mock := redismock.NewClientMock()
+	store := NewRedisCacheStore(&RedisCacheStore{Client: db, Prefix: "svc:"})
+
+	entry := &CachedResponse{StatusCode: 200, Header: http.Header{"Etag": {`"v1"`}}, Body: []byte("catalog")}
+	b, _ := json.Marshal(entry)
+
+	mock.ExpectSet("svc:key", b, time.Minute).SetVal("OK")
+	mock.ExpectGet("svc:key").SetVal(string(b))
+	mock.ExpectGet("svc:missing").RedisNil()
+	mock.ExpectDel("svc:key").SetVal(1)
+
+	assert.NoError(t, store.Set(ctx, "key", entry, time.Minute))
+	got, err := store.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, entry, got)
+
+	missing, err := store.Get(ctx, "missing")
+	assert.NoError(t, err)
+	assert.Nil(t, missing)
+
+	assert.NoError(t, store.Delete(ctx, "key"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestParseCacheControl(t *testing.T) {
+	cc := parseCacheControl(http.Header{"Cache-Control": {`max-age="60", No-Cache`, "private"}})
+	maxAge, ok := cc.seconds("max-age")
+
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, maxAge)
+	assert.True(t, cc.has("no-cache"))
+	assert.True(t, cc.has("private"))
+	assert.False(t, cc.has("No-Cache"))
+}
@@ -20,6 +20,7 @@ type CircuitBreaker struct {
 	maxFailures   int
 	resetTimeout  time.Duration
 	halfOpenLimit int
+	onStateChange func(from, to string)
 }
 
 type CircuitBreakerOption struct {
@@ -44,7 +45,7 @@ func (cb *CircuitBreaker) allow() bool {
 	case OPEN:
 		if time.Since(cb.lastFailTime) > cb.resetTimeout {
 
-			cb.state = HALF_OPEN
+			cb.setState(HALF_OPEN)
 			cb.successCount = 0
 			cb.failureCount = 0
 
@@ -58,6 +59,22 @@ func (cb *CircuitBreaker) allow() bool {
 	}
 }
 
+// ready reports whether allow would let a request through without moving an
+// open breaker to half-open.
+func (cb *CircuitBreaker) ready() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case OPEN:
+		return time.Since(cb.lastFailTime) > cb.resetTimeout
+	case HALF_OPEN:
+		return cb.successCount < cb.halfOpenLimit
+	default: // CLOSED state
+		return true
+	}
+}
+
 // ReportResult updates the circuit breaker state based on success or failure
 func (cb *CircuitBreaker) reportResult(success bool) {
 	cb.mu.Lock()
@@ -69,18 +86,50 @@ func (cb *CircuitBreaker) reportResult(success bool) {
 		cb.lastFailTime = time.Now()
 
 		if cb.state == HALF_OPEN {
-			cb.state = OPEN
+			cb.setState(OPEN)
 			return
 		}
 
 		if cb.failureCount >= cb.maxFailures {
-			cb.state = OPEN
+			cb.setState(OPEN)
 			return
 		}
 	default:
 		cb.successCount++
 		if cb.state == HALF_OPEN && cb.successCount >= cb.halfOpenLimit {
-			cb.state = CLOSED
+			cb.setState(CLOSED)
 		}
 	}
 }
+
+// reset closes the circuit breaker and clears its counters
+func (cb *CircuitBreaker) reset() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failureCount = 0
+	cb.successCount = 0
+	cb.setState(CLOSED)
+}
+
+// trip opens the circuit breaker as if it had just reached the failure limit
+func (cb *CircuitBreaker) trip() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.lastFailTime = time.Now()
+	cb.setState(OPEN)
+}
+
+// setState changes the state and notifies the observer, the caller must hold the lock
+func (cb *CircuitBreaker) setState(state string) {
+	from := cb.state
+	if from == "" {
+		from = CLOSED
+	}
+
+	cb.state = state
+	if cb.onStateChange != nil && from != state {
+		cb.onStateChange(from, state)
+	}
+}
@@ -3,6 +3,7 @@ package hcl
 import (
 	"context"
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -15,6 +16,9 @@ type CircuitBreakerRedis struct {
 	FailureLimit int
 	ResetTimeout time.Duration
 	ctx          context.Context
+	// states holds the last observed state per key, shared between clones
+	states        *sync.Map
+	onStateChange func(key, from, to string)
 }
 
 func NewCircuitBreakerRedis(conf *CircuitBreakerRedis) *CircuitBreakerRedis {
@@ -23,6 +27,7 @@ func NewCircuitBreakerRedis(conf *CircuitBreakerRedis) *CircuitBreakerRedis {
 		FailureLimit: conf.FailureLimit,
 		ResetTimeout: conf.ResetTimeout,
 		ctx:          context.Background(),
+		states:       &sync.Map{},
 	}
 }
 
@@ -36,10 +41,21 @@ func (c *CircuitBreakerRedis) recordFailure(key string) {
 	if failures == 1 {
 		c.Client.Expire(c.ctx, key, c.ResetTimeout) // set timeout at first failure
 	}
+
+	if failures >= int64(c.FailureLimit) {
+		c.observe(key, OPEN)
+	}
 }
 
 func (c *CircuitBreakerRedis) reset(key string) {
 	c.Client.Del(c.ctx, key)
+	c.observe(key, CLOSED)
+}
+
+// trip opens the circuit breaker of key until ResetTimeout
+func (c *CircuitBreakerRedis) trip(key string) {
+	c.Client.Set(c.ctx, key, c.FailureLimit, c.ResetTimeout)
+	c.observe(key, OPEN)
 }
 
 func (c *CircuitBreakerRedis) allowRequest(key string) error {
@@ -49,7 +65,28 @@ func (c *CircuitBreakerRedis) allowRequest(key string) error {
 	}
 
 	if failures >= c.FailureLimit {
+		c.observe(key, OPEN)
 		return errRefuse
 	}
+
+	c.observe(key, CLOSED)
 	return nil
 }
+
+// observe tracks the state seen for a key, so transitions made by other
+// instances or by key expiry are reported once they are noticed here.
+func (c *CircuitBreakerRedis) observe(key, state string) {
+	if c.onStateChange == nil || c.states == nil {
+		return
+	}
+
+	prev, loaded := c.states.Swap(key, state)
+	from := CLOSED
+	if loaded {
+		from = prev.(string)
+	}
+
+	if from != state || !loaded {
+		c.onStateChange(key, from, state)
+	}
+}
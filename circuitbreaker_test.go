@@ -170,3 +170,23 @@ func TestCircuitBreakerReportResult(t *testing.T) {
 		assert.Equal(t, CLOSED, cb.state) // State should change to CLOSED
 	})
 }
+
+func TestCircuitBreakerStateChange(t *testing.T) {
+	cb := NewCircuitBreaker(CircuitBreakerOption{
+		MaxFailures:   1,
+		HalfOpenLimit: 1,
+		ResetTimeout:  10 * time.Millisecond,
+	})
+
+	var transitions []string
+	cb.onStateChange = func(from, to string) {
+		transitions = append(transitions, from+"->"+to)
+	}
+
+	cb.reportResult(false)
+	cb.lastFailTime = time.Now().Add(-20 * time.Millisecond)
+	assert.True(t, cb.allow())
+	cb.reportResult(true)
+
+	assert.Equal(t, []string{"CLOSED->OPEN", "OPEN->HALF-OPEN", "HALF-OPEN->CLOSED"}, transitions)
+}
@@ -0,0 +1,121 @@
+package hcl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+type CoalescerOption struct {
+	// Methods are the idempotent methods that are collapsed, defaults to GET and HEAD.
+	Methods []string
+	// Headers are part of the key so requests that differ in them are not
+	// collapsed, the credential headers and the API key header are always
+	// part of it.
+	Headers []string
+	// Timeout bounds the shared call, which outlives the callers that give
+	// up, defaults to 30s.
+	Timeout time.Duration
+}
+
+// Coalescer collapses identical concurrent requests into one upstream call,
+// every caller receives its own copy of the response, see HCL.Coalescer.
+type Coalescer struct {
+	options CoalescerOption
+	group   flightGroup
+}
+
+func NewCoalescer(options CoalescerOption) *Coalescer {
+	if len(options.Methods) <= 0 {
+		options.Methods = []string{http.MethodGet, http.MethodHead}
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 30 * time.Second
+	}
+
+	return &Coalescer{options: options}
+}
+
+// sharedResponse is the buffered result handed to every caller.
+type sharedResponse struct {
+	resp *http.Response
+	body []byte
+}
+
+func (c *Coalescer) key(req *http.Request, auth *credentials) string {
+	var b strings.Builder
+	b.WriteString(req.Method + " " + req.URL.String())
+	for _, name := range append(auth.headers(), c.options.Headers...) {
+		b.WriteString("\n" + strings.ToLower(name) + ":" + strings.Join(req.Header.Values(name), ","))
+	}
+
+	// the token source and digest credentials are applied further down the
+	// pipeline, requests with different ones are not collapsed
+	if auth.tokenSource != nil {
+		b.WriteString("\ntoken-source:" + identity(auth.tokenSource))
+	}
+	if auth.digest != nil {
+		b.WriteString("\ndigest:" + auth.digest.Username + ":" + auth.digest.Password)
+	}
+	return b.String()
+}
+
+// identity tells apart the values of pointer types by address and the others
+// by value.
+func identity(v interface{}) string {
+	if reflect.ValueOf(v).Kind() == reflect.Ptr {
+		return fmt.Sprintf("%T:%p", v, v)
+	}
+	return fmt.Sprintf("%T:%v", v, v)
+}
+
+func (c *Coalescer) middleware(auth *credentials, next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if !inArray(req.Method, c.options.Methods) {
+			return next.RoundTrip(req)
+		}
+
+		result := c.group.doChan(c.key(req, auth), func() (interface{}, error) {
+			// the call is shared, one caller giving up must not fail the others
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(req.Context()), c.options.Timeout)
+			defer cancel()
+
+			resp, err := next.RoundTrip(req.Clone(ctx))
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return nil, err
+			}
+			return &sharedResponse{resp: resp, body: body}, nil
+		})
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case res := <-result:
+			if res.err != nil {
+				return nil, res.err
+			}
+			return res.val.(*sharedResponse).copyFor(req), nil
+		}
+	})
+}
+
+func (s *sharedResponse) copyFor(req *http.Request) *http.Response {
+	out := *s.resp
+	out.Header = s.resp.Header.Clone()
+	out.Trailer = s.resp.Trailer.Clone()
+	out.Body = io.NopCloser(bytes.NewReader(s.body))
+	out.ContentLength = int64(len(s.body))
+	out.Request = req
+	return &out
+}
@@ -0,0 +1,149 @@
+package hcl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newSlowServer(delay time.Duration, calls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(calls, 1)
+		time.Sleep(delay)
+		w.Header().Set("X-Call", fmt.Sprint(n))
+		fmt.Fprintf(w, `{"call":%d,"auth":"%s"}`, n, r.Header.Get("Authorization"))
+	}))
+}
+
+func TestCoalescer(t *testing.T) {
+	var calls int32
+	server := newSlowServer(50*time.Millisecond, &calls)
+	defer server.Close()
+
+	hcl := &HCL{Coalescer: NewCoalescer(CoalescerOption{})}
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 50)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := New(hcl).SetUrl(server.URL).Get()
+			assert.NoError(t, err)
+
+			// every caller gets its own body and header
+			resp.Header.Set("X-Call", "changed")
+			b, _ := resp.ByteResult()
+			bodies[i] = string(b)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for _, body := range bodies {
+		assert.Equal(t, `{"call":1,"auth":""}`, body)
+	}
+}
+
+func TestCoalescerKey(t *testing.T) {
+	var calls int32
+	server := newSlowServer(50*time.Millisecond, &calls)
+	defer server.Close()
+
+	hcl := &HCL{Coalescer: NewCoalescer(CoalescerOption{Headers: []string{"X-Tenant"}})}
+
+	var wg sync.WaitGroup
+	run := func(r *Request) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.SetUrl(server.URL).Get()
+			assert.NoError(t, err)
+		}()
+	}
+
+	run(New(hcl).SetBearerToken("a"))
+	run(New(hcl).SetBearerToken("b"))
+	run(New(hcl).SetBearerToken("a").SetHeader("X-Tenant", "other"))
+	run(New(hcl).SetAPIKey(APIKeyInHeader, "X-Partner-Key", "a"))
+	run(New(hcl).SetAPIKey(APIKeyInHeader, "X-Partner-Key", "b"))
+	run(New(hcl).SetHeader("Cookie", "session=a"))
+	run(New(hcl).SetHeader("Cookie", "session=b"))
+	run(New(&HCL{Coalescer: hcl.Coalescer, TokenSource: staticTokenSource("a")}))
+	run(New(&HCL{Coalescer: hcl.Coalescer, TokenSource: staticTokenSource("b")}))
+	wg.Wait()
+
+	assert.Equal(t, int32(9), atomic.LoadInt32(&calls))
+}
+
+func TestCoalescerTimeout(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	server := newBlockingServer(&calls, release)
+	defer server.Close()
+	defer close(release)
+
+	hcl := &HCL{Coalescer: NewCoalescer(CoalescerOption{Timeout: 20 * time.Millisecond})}
+
+	// a hung upstream fails the shared call instead of holding it open
+	start := time.Now()
+	_, err := New(hcl).SetUrl(server.URL).Get()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestCoalescerSkipsUnsafeMethods(t *testing.T) {
+	var calls int32
+	server := newSlowServer(20*time.Millisecond, &calls)
+	defer server.Close()
+
+	hcl := &HCL{Coalescer: NewCoalescer(CoalescerOption{})}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := New(hcl).SetUrl(server.URL).SetJsonPayload(map[string]string{"a": "b"}).Post()
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestCoalescerCallerCancel(t *testing.T) {
+	var calls int32
+	server := newSlowServer(100*time.Millisecond, &calls)
+	defer server.Close()
+
+	hcl := &HCL{Coalescer: NewCoalescer(CoalescerOption{})}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := New(&HCL{Context: ctx, Coalescer: hcl.Coalescer}).SetUrl(server.URL).Get()
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	}()
+
+	time.Sleep(5 * time.Millisecond)
+	resp, err := New(hcl).SetUrl(server.URL).Get()
+	wg.Wait()
+
+	// the cancelled caller started the call, the other caller still gets its result
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
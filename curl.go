@@ -0,0 +1,87 @@
+package hcl
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ToCurl renders the request as a curl command to reproduce it by hand. The
+// credentials and the fields set with SetMaskedField are masked. The method is
+// the given one, or the one the request was sent with, or GET.
+func (r *Request) ToCurl(method ...RequestMethod) string {
+	// Check if the request object is nil
+	if r == nil || r.url == nil {
+		return ""
+	}
+
+	m := r.method
+	if len(method) > 0 && method[0] != "" {
+		m = string(method[0])
+	}
+	if m == "" {
+		m = string(GET)
+	}
+
+	var body []byte
+	if r.body != nil {
+		body, _ = io.ReadAll(r.body)
+		r.body.Close()
+		r.body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	u := *r.url
+	req := &http.Request{Method: m, URL: &u, Header: r.header.Clone()}
+	r.auth.apply(req)
+
+	masks := r.masks()
+	if len(masks) > 0 && u.RawQuery != "" {
+		u.RawQuery = maskQuery(u.Query(), masks).Encode()
+	}
+
+	// curl sends a POST when it has data
+	parts := []string{"curl"}
+	if m != string(GET) || len(body) > 0 {
+		parts = append(parts, "-X", m)
+	}
+	parts = append(parts, shellQuote(u.String()))
+
+	header := maskHeader(req.Header, masks)
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			parts = append(parts, "-H", shellQuote(name+": "+value))
+		}
+	}
+
+	if len(body) > 0 {
+		payload := maskPayload(req.Header.Get(contentType), string(body), masks)
+		parts = append(parts, "--data-raw", shellQuote(payload))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// masks returns the fields masked in the log and the credentials of the request.
+func (r *Request) masks() []*MaskConfig {
+	var masks []*MaskConfig
+	if r.log != nil {
+		masks = append(masks, r.log.maskedConfig...)
+	}
+	for _, mask := range r.auth.masks() {
+		if found, _ := shouldMask(mask.Field, masks); !found {
+			masks = append(masks, mask)
+		}
+	}
+	return masks
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,44 @@
+package hcl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToCurl(t *testing.T) {
+	hcl := &HCL{BearerToken: "secret-token"}
+	req := New(hcl).EnableLog(false).
+		SetUrl("https://api.example.com/networkprofile?id=1&pin=1234").
+		SetHeader("X-Tenant", "it's").
+		SetJsonPayload(map[string]string{"name": "alice", "pin": "1234"}).
+		SetMaskedField(&MaskConfig{Field: "pin", MaskType: FullMask})
+
+	assert.Equal(t,
+		`curl -X PUT 'https://api.example.com/networkprofile?id=1&pin=%2A%2A%2A%2A' `+
+			`-H 'Authorization: *****' -H 'Content-Type: application/json' -H 'X-Tenant: it'\''s' `+
+			`--data-raw '{"name":"alice","pin":"****"}'`,
+		req.ToCurl(PUT))
+
+	// a GET with a body keeps its method
+	assert.Contains(t, req.ToCurl(), `curl -X GET 'https://api.example.com/networkprofile`)
+	assert.Equal(t, `curl 'https://api.example.com/networkprofile'`,
+		New(nil).SetUrl("https://api.example.com/networkprofile").ToCurl())
+
+	assert.Equal(t, "", (*Request)(nil).ToCurl())
+}
+
+func TestToCurlAfterSend(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	req := New(nil).SetUrl(server.URL).SetFormURLEncoded(map[string]string{"a": "1"})
+	_, err := req.Post()
+	assert.NoError(t, err)
+
+	assert.Equal(t,
+		`curl -X POST '`+server.URL+`' -H 'Content-Type: application/x-www-form-urlencoded' --data-raw 'a=1'`,
+		req.ToCurl())
+}
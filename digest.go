@@ -0,0 +1,297 @@
+package hcl
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const headerWWWAuthenticate = "WWW-Authenticate"
+
+// DigestAuth answers RFC 7616 Digest challenges. The last challenge of every
+// host is cached so following requests are authenticated without a 401 round
+// trip, share one DigestAuth between requests to reuse it.
+type DigestAuth struct {
+	Username string
+	Password string
+
+	mu         sync.Mutex
+	challenges map[string]*digestChallenge
+}
+
+func NewDigestAuth(username, password string) *DigestAuth {
+	return &DigestAuth{Username: username, Password: password}
+}
+
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+	stale     bool
+	nc        int
+}
+
+func (r *Request) SetDigestAuth(username, password string) *Request {
+	// Check if the request object is nil
+	if r == nil {
+		return nil
+	}
+
+	if username == "" {
+		r.errs = append(r.errs, errors.New("username cannot be empty"))
+		return r
+	}
+
+	r.auth.digest = NewDigestAuth(username, password)
+	r.auth.basic = nil
+	r.auth.bearer = ""
+	return r
+}
+
+// digestMiddleware authenticates with the cached challenge of the host and
+// answers a new challenge once when the upstream returns 401.
+func digestMiddleware(auth *DigestAuth) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			preemptive := false
+			first := req
+			if header, ok := auth.authorization(req); ok {
+				first = withHeader(req, headerAuthorization, header)
+				preemptive = true
+			}
+
+			resp, err := next.RoundTrip(first)
+			if err != nil || resp.StatusCode != http.StatusUnauthorized || !replayable(req) {
+				return resp, err
+			}
+
+			challenge := parseDigestChallenge(resp.Header.Values(headerWWWAuthenticate))
+			if challenge == nil {
+				return resp, nil
+			}
+			// the credentials were rejected with a valid nonce, retrying cannot help
+			if preemptive && !challenge.stale && auth.sameNonce(req.URL.Host, challenge) {
+				return resp, nil
+			}
+			auth.setChallenge(req.URL.Host, challenge)
+
+			header, ok := auth.authorization(req)
+			if !ok {
+				return resp, nil
+			}
+			drainBody(resp)
+
+			retry, err := replay(req)
+			if err != nil {
+				return nil, err
+			}
+			retry.Header.Set(headerAuthorization, header)
+			return next.RoundTrip(retry)
+		})
+	}
+}
+
+func withHeader(req *http.Request, key, value string) *http.Request {
+	out := req.Clone(req.Context())
+	out.Header.Set(key, value)
+	return out
+}
+
+func (d *DigestAuth) setChallenge(host string, challenge *digestChallenge) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.challenges == nil {
+		d.challenges = make(map[string]*digestChallenge)
+	}
+	d.challenges[host] = challenge
+}
+
+func (d *DigestAuth) sameNonce(host string, challenge *digestChallenge) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	cached, ok := d.challenges[host]
+	return ok && cached.nonce == challenge.nonce
+}
+
+// authorization builds the Authorization header from the cached challenge of
+// the request host, incrementing its nonce count.
+func (d *DigestAuth) authorization(req *http.Request) (string, bool) {
+	d.mu.Lock()
+	challenge, ok := d.challenges[req.URL.Host]
+	if !ok {
+		d.mu.Unlock()
+		return "", false
+	}
+	challenge.nc++
+	c := *challenge
+	d.mu.Unlock()
+
+	h := digestHash(c.algorithm)
+	if h == nil {
+		return "", false
+	}
+
+	cnonce := newCnonce()
+	nc := fmt.Sprintf("%08x", c.nc)
+	uri := req.URL.RequestURI()
+
+	ha1 := hashHex(h, []byte(d.Username+":"+c.realm+":"+d.Password))
+	if strings.HasSuffix(strings.ToLower(c.algorithm), "-sess") {
+		ha1 = hashHex(h, []byte(ha1+":"+c.nonce+":"+cnonce))
+	}
+	ha2 := hashHex(h, []byte(req.Method+":"+uri))
+
+	var response string
+	if c.qop == "" {
+		response = hashHex(h, []byte(ha1+":"+c.nonce+":"+ha2))
+	} else {
+		response = hashHex(h, []byte(ha1+":"+c.nonce+":"+nc+":"+cnonce+":"+c.qop+":"+ha2))
+	}
+
+	parts := []string{
+		digestUsername(d.Username),
+		"realm=" + quoteString(c.realm),
+		"nonce=" + quoteString(c.nonce),
+		"uri=" + quoteString(uri),
+		"algorithm=" + c.algorithm,
+		fmt.Sprintf(`response="%s"`, response),
+	}
+	if c.qop != "" {
+		parts = append(parts, "qop="+c.qop, "nc="+nc, fmt.Sprintf(`cnonce="%s"`, cnonce))
+	}
+	if c.opaque != "" {
+		parts = append(parts, "opaque="+quoteString(c.opaque))
+	}
+	return "Digest " + strings.Join(parts, ", "), true
+}
+
+// digestUsername sends names that are not printable ASCII as username* with
+// the RFC 5987 encoding, see RFC 7616 section 3.4.4.
+func digestUsername(username string) string {
+	for i := 0; i < len(username); i++ {
+		if username[i] < 0x20 || username[i] > 0x7e {
+			return "username*=UTF-8''" + uriEncode(username)
+		}
+	}
+	return "username=" + quoteString(username)
+}
+
+// quoteString returns s as an RFC 7230 quoted-string, escaping quotes and
+// backslashes.
+func quoteString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func digestHash(algorithm string) func() hash.Hash {
+	switch strings.ToUpper(strings.TrimSuffix(strings.ToLower(algorithm), "-sess")) {
+	case "MD5":
+		return md5.New
+	case "SHA-256":
+		return sha256.New
+	}
+	return nil
+}
+
+func newCnonce() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// parseDigestChallenge returns the strongest supported Digest challenge,
+// SHA-256 is preferred over MD5.
+func parseDigestChallenge(headers []string) *digestChallenge {
+	var best *digestChallenge
+	for _, header := range headers {
+		scheme, params, ok := strings.Cut(strings.TrimSpace(header), " ")
+		if !ok || !strings.EqualFold(scheme, "Digest") {
+			continue
+		}
+
+		values := parseAuthParams(params)
+		challenge := &digestChallenge{
+			realm:     values["realm"],
+			nonce:     values["nonce"],
+			opaque:    values["opaque"],
+			algorithm: values["algorithm"],
+			stale:     strings.EqualFold(values["stale"], "true"),
+		}
+		if challenge.algorithm == "" {
+			challenge.algorithm = "MD5"
+		}
+		if challenge.nonce == "" || digestHash(challenge.algorithm) == nil {
+			continue
+		}
+
+		if qop, ok := values["qop"]; ok {
+			// only qop=auth is supported, auth-int needs the entity body hash
+			for _, option := range strings.Split(qop, ",") {
+				if strings.TrimSpace(option) == "auth" {
+					challenge.qop = "auth"
+				}
+			}
+			if challenge.qop == "" {
+				continue
+			}
+		}
+
+		if best == nil || strings.HasPrefix(strings.ToUpper(challenge.algorithm), "SHA-256") {
+			best = challenge
+		}
+	}
+	return best
+}
+
+// parseAuthParams parses comma separated auth-params, values may be quoted
+// and contain commas.
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ,")
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		rest = strings.TrimLeft(rest, " ")
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				b.WriteByte(rest[i])
+			}
+			value = b.String()
+			s = rest[min(i+1, len(rest)):]
+		} else {
+			value, s, _ = strings.Cut(rest, ",")
+			value = strings.TrimSpace(value)
+		}
+		params[key] = value
+	}
+	return params
+}
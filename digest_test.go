@@ -0,0 +1,178 @@
+package hcl
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// digestServer is a minimal RFC 7616 server with qop=auth.
+type digestServer struct {
+	*httptest.Server
+	algorithm string
+	mu        sync.Mutex
+	nonce     int
+	calls     int
+	lastNC    string
+	bodies    []string
+}
+
+func newDigestServer(t *testing.T, algorithm string) *digestServer {
+	ds := &digestServer{algorithm: algorithm}
+	ds.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ds.mu.Lock()
+		defer ds.mu.Unlock()
+		ds.calls++
+
+		b, _ := io.ReadAll(r.Body)
+		ds.bodies = append(ds.bodies, string(b))
+
+		header := r.Header.Get("Authorization")
+		if header == "" || !ds.verify(r.Method, header) {
+			ds.nonce++
+			w.Header().Add("WWW-Authenticate", fmt.Sprintf(
+				`Digest realm="hcl@example.com", qop="auth,auth-int", algorithm=%s, nonce="nonce-%d", opaque="abc,def"`,
+				ds.algorithm, ds.nonce))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	return ds
+}
+
+func (ds *digestServer) verify(method, header string) bool {
+	params := parseAuthParams(header[len("Digest "):])
+	if params["nonce"] != fmt.Sprintf("nonce-%d", ds.nonce) || params["opaque"] != "abc,def" {
+		return false
+	}
+
+	var h func() hash.Hash = md5.New
+	if ds.algorithm == "SHA-256" {
+		h = sha256.New
+	}
+	sum := func(s string) string {
+		d := h()
+		d.Write([]byte(s))
+		return hex.EncodeToString(d.Sum(nil))
+	}
+
+	ha1 := sum("john:hcl@example.com:s3cr3t")
+	ha2 := sum(method + ":" + params["uri"])
+	expected := sum(ha1 + ":" + params["nonce"] + ":" + params["nc"] + ":" + params["cnonce"] + ":auth:" + ha2)
+	ds.lastNC = params["nc"]
+	return params["response"] == expected && params["qop"] == "auth"
+}
+
+func TestDigestAuth(t *testing.T) {
+	for _, algorithm := range []string{"MD5", "SHA-256"} {
+		t.Run(algorithm, func(t *testing.T) {
+			ds := newDigestServer(t, algorithm)
+			defer ds.Close()
+
+			resp, err := New(nil).
+				SetDigestAuth("john", "s3cr3t").
+				SetUrl(ds.URL+"/api/resource").
+				SetQueryParam("id", "1").
+				SetJsonPayload(map[string]string{"msisdn": "0812"}).
+				Post()
+
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, 2, ds.calls)
+			assert.Equal(t, []string{`{"msisdn":"0812"}`, `{"msisdn":"0812"}`}, ds.bodies)
+		})
+	}
+}
+
+func TestDigestAuthReusesNonce(t *testing.T) {
+	ds := newDigestServer(t, "MD5")
+	defer ds.Close()
+
+	hcl := &HCL{DigestAuth: NewDigestAuth("john", "s3cr3t")}
+
+	for i := 0; i < 3; i++ {
+		resp, err := New(hcl).SetUrl(ds.URL).Get()
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	// one challenge, then every request is authenticated up front
+	assert.Equal(t, 4, ds.calls)
+	assert.Equal(t, "00000003", ds.lastNC)
+}
+
+func TestDigestAuthRenewsNonce(t *testing.T) {
+	ds := newDigestServer(t, "MD5")
+	defer ds.Close()
+
+	hcl := &HCL{DigestAuth: NewDigestAuth("john", "s3cr3t")}
+	_, _ = New(hcl).SetUrl(ds.URL).Get()
+
+	// the server rotates its nonce
+	ds.mu.Lock()
+	ds.nonce++
+	ds.mu.Unlock()
+
+	resp, err := New(hcl).SetUrl(ds.URL).Get()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "00000001", ds.lastNC)
+}
+
+func TestDigestAuthWrongPassword(t *testing.T) {
+	ds := newDigestServer(t, "MD5")
+	defer ds.Close()
+
+	hcl := &HCL{DigestAuth: NewDigestAuth("john", "wrong")}
+	resp, err := New(hcl).SetUrl(ds.URL).Get()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, 2, ds.calls)
+}
+
+func TestParseDigestChallenge(t *testing.T) {
+	challenge := parseDigestChallenge([]string{
+		`Basic realm="x"`,
+		`Digest realm="api", nonce="n1", qop="auth", algorithm=MD5`,
+		`Digest realm="api", nonce="n2", qop="auth", algorithm=SHA-256, stale=true`,
+	})
+	assert.Equal(t, "n2", challenge.nonce)
+	assert.Equal(t, "SHA-256", challenge.algorithm)
+	assert.True(t, challenge.stale)
+
+	assert.Nil(t, parseDigestChallenge([]string{`Digest realm="api", nonce="n1", qop="auth-int"`}))
+	assert.Nil(t, parseDigestChallenge([]string{`Digest realm="api", nonce="n1", algorithm=SHA-512-256`}))
+}
+
+func TestDigestAuthQuoting(t *testing.T) {
+	auth := NewDigestAuth(`jo"hn\`, "s3cr3t")
+	auth.setChallenge("api", &digestChallenge{realm: `a "b" \c`, nonce: `n"1`, opaque: `x\y`, algorithm: "MD5", qop: "auth"})
+
+	req, _ := http.NewRequest(http.MethodGet, `http://api/search?q="x"`, nil)
+	header, ok := auth.authorization(req)
+	assert.True(t, ok)
+
+	params := parseAuthParams(header[len("Digest "):])
+	assert.Equal(t, `jo"hn\`, params["username"])
+	assert.Equal(t, `a "b" \c`, params["realm"])
+	assert.Equal(t, `n"1`, params["nonce"])
+	assert.Equal(t, req.URL.RequestURI(), params["uri"])
+	assert.Equal(t, `x\y`, params["opaque"])
+	assert.Contains(t, header, `username="jo\"hn\\"`)
+
+	auth = NewDigestAuth("jäsøn", "s3cr3t")
+	auth.setChallenge("api", &digestChallenge{realm: "api", nonce: "n1", algorithm: "MD5"})
+	header, _ = auth.authorization(req)
+	assert.Contains(t, header, "username*=UTF-8''j%C3%A4s%C3%B8n,")
+	assert.NotContains(t, header, "username=")
+}
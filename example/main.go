@@ -1,7 +1,6 @@
 package main
 
 import (
-	"crypto/tls"
 	"fmt"
 	"net/http"
 	"time"
@@ -20,12 +19,11 @@ type ResponseProfile struct {
 }
 
 func main() {
-	tr := &http.Transport{
-		TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+	tr := hcl.NewTransport(hcl.TransportOption{
 		MaxIdleConns:        100,
 		IdleConnTimeout:     60 * time.Second,
 		MaxIdleConnsPerHost: 100,
-	}
+	})
 	client := &http.Client{
 		Transport: tr,
 		Timeout:   10 * time.Second,
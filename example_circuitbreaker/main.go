@@ -1,7 +1,6 @@
 package main
 
 import (
-	"crypto/tls"
 	"fmt"
 	"net/http"
 	"os"
@@ -33,12 +32,11 @@ type NetworkProfile struct {
 }
 
 func main() {
-	tr := &http.Transport{
-		TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+	tr := hcl.NewTransport(hcl.TransportOption{
 		MaxIdleConns:        100,
 		IdleConnTimeout:     60 * time.Second,
 		MaxIdleConnsPerHost: 100,
-	}
+	})
 	client := &http.Client{
 		Transport: tr,
 		Timeout:   10 * time.Second,
@@ -0,0 +1,269 @@
+package hcl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// FaultInjectionEnv overrides FaultOption.Enabled when it is set, e.g.
+// HCL_FAULT_INJECTION=true on staging.
+const FaultInjectionEnv = "HCL_FAULT_INJECTION"
+
+// FaultRule describes the faults of the requests it matches. Latency is
+// added first, then the request fails with a reset, gets StatusCode or has
+// its body truncated.
+type FaultRule struct {
+	// Host matches the host of the request URL, with or without port. Empty
+	// matches every host.
+	Host string `json:"host,omitempty" yaml:"host,omitempty"`
+	// Route matches the route template set with SetRouteTemplate, or the URL
+	// path, a trailing "*" matches any suffix. Empty matches every route.
+	Route string `json:"route,omitempty" yaml:"route,omitempty"`
+	// Percentage of the matching requests that get the faults, defaults to 100.
+	Percentage float64 `json:"percentage,omitempty" yaml:"percentage,omitempty"`
+	// Latency is written as a duration string such as "250ms" in JSON and YAML.
+	Latency time.Duration `json:"latency,omitempty" yaml:"latency,omitempty"`
+	// StatusCode is returned without sending the request.
+	StatusCode int `json:"statusCode,omitempty" yaml:"statusCode,omitempty"`
+	// Reset fails the request as if the connection was reset.
+	Reset bool `json:"reset,omitempty" yaml:"reset,omitempty"`
+	// TruncateBody cuts the response body after that many bytes, reading
+	// further fails with io.ErrUnexpectedEOF.
+	TruncateBody int `json:"truncateBody,omitempty" yaml:"truncateBody,omitempty"`
+}
+
+// UnmarshalJSON accepts Latency as a duration string such as "250ms" or as
+// nanoseconds.
+func (f *FaultRule) UnmarshalJSON(b []byte) error {
+	type rule FaultRule
+	aux := struct {
+		*rule
+		Latency json.RawMessage `json:"latency,omitempty"`
+	}{rule: (*rule)(f)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	if len(aux.Latency) <= 0 || string(aux.Latency) == "null" {
+		return nil
+	}
+
+	var latency string
+	if err := json.Unmarshal(aux.Latency, &latency); err != nil {
+		return json.Unmarshal(aux.Latency, (*int64)(&f.Latency))
+	}
+	d, err := time.ParseDuration(latency)
+	if err != nil {
+		return errors.New("failed to parse fault latency: " + err.Error())
+	}
+	f.Latency = d
+	return nil
+}
+
+// MarshalJSON writes Latency as a duration string.
+func (f FaultRule) MarshalJSON() ([]byte, error) {
+	type rule FaultRule
+	aux := struct {
+		rule
+		Latency string `json:"latency,omitempty"`
+	}{rule: rule(f)}
+	if f.Latency > 0 {
+		aux.Latency = f.Latency.String()
+	}
+	return json.Marshal(aux)
+}
+
+type FaultOption struct {
+	Rules []FaultRule
+	// Enabled turns the injection on, see FaultInjectionEnv.
+	Enabled bool
+}
+
+// FaultInjector adds latency and errors to requests to test fallbacks and
+// circuit breakers, see HCL.Faults. The first rule that matches a request
+// applies.
+type FaultInjector struct {
+	options FaultOption
+	enabled atomic.Bool
+
+	mu   sync.Mutex
+	rand func() float64
+}
+
+func NewFaultInjector(options FaultOption) *FaultInjector {
+	for i := range options.Rules {
+		if options.Rules[i].Percentage <= 0 {
+			options.Rules[i].Percentage = 100
+		}
+	}
+
+	f := &FaultInjector{
+		options: options,
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())).Float64,
+	}
+	f.enabled.Store(options.Enabled)
+	if env, ok := os.LookupEnv(FaultInjectionEnv); ok {
+		enabled, _ := strconv.ParseBool(env)
+		f.enabled.Store(enabled)
+	}
+	return f
+}
+
+// SetEnabled turns the injection on or off at runtime.
+func (f *FaultInjector) SetEnabled(enabled bool) {
+	f.enabled.Store(enabled)
+}
+
+func (f *FaultInjector) Enabled() bool {
+	return f.enabled.Load()
+}
+
+// rule returns the rule that applies to the request, nil when it is spared.
+func (f *FaultInjector) rule(req *http.Request, route string) *FaultRule {
+	if route == "" {
+		route = req.URL.Path
+	}
+
+	for i := range f.options.Rules {
+		rule := &f.options.Rules[i]
+		if rule.Host != "" && rule.Host != req.URL.Host && rule.Host != req.URL.Hostname() {
+			continue
+		}
+		if rule.Route != "" && !matchRoute(rule.Route, route) {
+			continue
+		}
+
+		f.mu.Lock()
+		roll := f.rand() * 100
+		f.mu.Unlock()
+		if roll >= rule.Percentage {
+			return nil
+		}
+		return rule
+	}
+	return nil
+}
+
+func matchRoute(pattern, route string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(route, prefix)
+	}
+	return pattern == route
+}
+
+func (f *FaultInjector) middleware(r *Request, next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if !f.Enabled() {
+			return next.RoundTrip(req)
+		}
+
+		rule := f.rule(req, r.route)
+		if rule == nil {
+			return next.RoundTrip(req)
+		}
+
+		if rule.Latency > 0 {
+			if err := sleepContext(req.Context(), rule.Latency); err != nil {
+				return nil, err
+			}
+		}
+		if rule.Reset {
+			err := resetError(req)
+			exchangeFrom(req.Context()).failUpstream(err)
+			return nil, err
+		}
+		if rule.StatusCode > 0 {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return &http.Response{
+				Status:     strconv.Itoa(rule.StatusCode) + " " + http.StatusText(rule.StatusCode),
+				StatusCode: rule.StatusCode,
+				Proto:      "HTTP/1.1",
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Header:     make(http.Header),
+				Body:       http.NoBody,
+				Request:    req,
+			}, nil
+		}
+
+		resp, err := next.RoundTrip(req)
+		if err != nil || rule.TruncateBody <= 0 {
+			return resp, err
+		}
+		resp.Body = &truncatedBody{body: resp.Body, left: rule.TruncateBody}
+		resp.ContentLength = -1
+		return resp, nil
+	})
+}
+
+// resetError is the error of the http.Client when the upstream resets the
+// connection, so an injected reset is handled like a real one.
+func resetError(req *http.Request) error {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	return &url.Error{
+		Op:  method[:1] + strings.ToLower(method[1:]),
+		URL: req.URL.String(),
+		Err: &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)},
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// truncatedBody ends a body early the way a dropped connection does.
+type truncatedBody struct {
+	body io.ReadCloser
+	left int
+}
+
+func (t *truncatedBody) Read(p []byte) (int, error) {
+	if t.left <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if len(p) > t.left {
+		p = p[:t.left]
+	}
+	n, err := t.body.Read(p)
+	t.left -= n
+	return n, err
+}
+
+func (t *truncatedBody) Close() error {
+	return t.body.Close()
+}
+
+func (r *Request) faultMiddleware(next http.RoundTripper) http.RoundTripper {
+	if r.faults == nil {
+		return next
+	}
+	return r.faults.middleware(r, next)
+}
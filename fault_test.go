@@ -0,0 +1,199 @@
+package hcl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestFaultInjectorStatusTripsCircuitBreaker(t *testing.T) {
+	var calls int32
+	server := newCountingServer(&calls)
+	defer server.Close()
+
+	faults := NewFaultInjector(FaultOption{
+		Enabled: true,
+		Rules:   []FaultRule{{Route: "/networkprofile/*", StatusCode: http.StatusServiceUnavailable}},
+	})
+	hcl := &HCL{
+		Faults: faults,
+		Cb:     NewCircuitBreaker(CircuitBreakerOption{MaxFailures: 1, HalfOpenLimit: 1, ResetTimeout: time.Minute}),
+	}
+
+	req := New(hcl).SetUrl(server.URL + "/networkprofile/1")
+	resp, err := req.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, OPEN, req.Cb.state)
+	assert.Zero(t, atomic.LoadInt32(&calls))
+
+	// other routes are spared
+	resp, err = New(hcl).SetUrl(server.URL + "/orders").Get()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	faults.SetEnabled(false)
+	resp, err = New(hcl).SetUrl(server.URL + "/networkprofile/1").Get()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestFaultInjectorResetTripsCircuitBreakerRedis(t *testing.T) {
+	var calls int32
+	server := newCountingServer(&calls)
+	defer server.Close()
+
+	db, mock := redismock.NewClientMock()
+	faults := NewFaultInjector(FaultOption{Enabled: true, Rules: []FaultRule{{Reset: true}}})
+	hcl := &HCL{
+		Faults:  faults,
+		CbRedis: NewCircuitBreakerRedis(&CircuitBreakerRedis{Client: db, FailureLimit: 1, ResetTimeout: time.Minute}),
+	}
+
+	mock.ExpectGet("svc").RedisNil()
+	mock.ExpectIncr("svc").SetVal(1)
+	mock.ExpectExpire("svc", time.Minute).SetVal(true)
+	_, err := New(hcl).SetUrl(server.URL).SetCircuitBreakerKey("svc").Get()
+	var opErr *net.OpError
+	assert.True(t, errors.As(err, &opErr))
+	assert.ErrorIs(t, err, syscall.ECONNRESET)
+	assert.ErrorContains(t, err, "connection reset by peer")
+
+	mock.ExpectGet("svc").SetVal("1")
+	_, err = New(hcl).SetUrl(server.URL).SetCircuitBreakerKey("svc").Get()
+	assert.Equal(t, errRefuse, err)
+
+	// the breaker recovers once the faults stop
+	faults.SetEnabled(false)
+	mock.ExpectGet("svc").RedisNil()
+	mock.ExpectDel("svc").SetVal(1)
+	_, err = New(hcl).SetUrl(server.URL).SetCircuitBreakerKey("svc").Get()
+	assert.NoError(t, err)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestRealResetTripsCircuitBreakerRedis(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+	}))
+	defer server.Close()
+
+	db, mock := redismock.NewClientMock()
+	hcl := &HCL{CbRedis: NewCircuitBreakerRedis(&CircuitBreakerRedis{Client: db, FailureLimit: 1, ResetTimeout: time.Minute})}
+
+	mock.ExpectGet("svc").RedisNil()
+	mock.ExpectIncr("svc").SetVal(1)
+	mock.ExpectExpire("svc", time.Minute).SetVal(true)
+	_, err := New(hcl).SetUrl(server.URL).SetCircuitBreakerKey("svc").Get()
+	assert.Error(t, err)
+
+	mock.ExpectGet("svc").SetVal("1")
+	_, err = New(hcl).SetUrl(server.URL).SetCircuitBreakerKey("svc").Get()
+	assert.Equal(t, errRefuse, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCircuitBreakerCountsOnlyUpstreamErrors(t *testing.T) {
+	var calls int32
+	server := newSlowServer(100*time.Millisecond, &calls)
+	defer server.Close()
+
+	cb := NewCircuitBreaker(CircuitBreakerOption{MaxFailures: 1, HalfOpenLimit: 1, ResetTimeout: time.Minute})
+
+	// the deadline of the caller passing is not an upstream failure
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req := New(&HCL{Context: ctx, Cb: cb}).SetUrl(server.URL)
+	_, err := req.Get()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Zero(t, req.Cb.failureCount)
+
+	// the client timing out on the upstream is
+	client := &http.Client{Timeout: 20 * time.Millisecond}
+	req = New(&HCL{Client: client, Cb: cb}).SetUrl(server.URL)
+	_, err = req.Get()
+	assert.Error(t, err)
+	assert.Equal(t, OPEN, req.Cb.state)
+}
+
+func TestFaultInjectorRules(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello world"))
+	}))
+	defer server.Close()
+
+	faults := NewFaultInjector(FaultOption{
+		Enabled: true,
+		Rules: []FaultRule{
+			{Host: "127.0.0.1", Route: "/slow", Latency: 20 * time.Millisecond, Percentage: 50},
+			{Route: "/truncated", TruncateBody: 5},
+		},
+	})
+	roll := 0.6
+	faults.rand = func() float64 { return roll }
+	hcl := &HCL{Faults: faults}
+
+	// the roll is above the percentage
+	start := time.Now()
+	_, err := New(hcl).SetUrl(server.URL + "/slow").Get()
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(start), 20*time.Millisecond)
+
+	roll = 0.4
+	start = time.Now()
+	_, err = New(hcl).SetUrl(server.URL + "/slow").Get()
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	resp, err := New(hcl).SetUrl(server.URL + "/truncated").Get()
+	assert.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, "hello", string(body))
+}
+
+func TestFaultRuleLatency(t *testing.T) {
+	var rules []FaultRule
+	err := json.Unmarshal([]byte(`[{"route":"/slow","latency":"250ms"},{"latency":1000000},{"reset":true}]`), &rules)
+	assert.NoError(t, err)
+	assert.Equal(t, "/slow", rules[0].Route)
+	assert.Equal(t, 250*time.Millisecond, rules[0].Latency)
+	assert.Equal(t, time.Millisecond, rules[1].Latency)
+	assert.Zero(t, rules[2].Latency)
+	assert.True(t, rules[2].Reset)
+
+	b, err := json.Marshal(rules[0])
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"route":"/slow","latency":"250ms"}`, string(b))
+
+	var rule FaultRule
+	assert.Error(t, json.Unmarshal([]byte(`{"latency":"soon"}`), &rule))
+
+	assert.NoError(t, yaml.Unmarshal([]byte("route: /slow\nlatency: 2s\n"), &rule))
+	assert.Equal(t, 2*time.Second, rule.Latency)
+}
+
+func TestFaultInjectorEnv(t *testing.T) {
+	t.Setenv(FaultInjectionEnv, "false")
+	assert.False(t, NewFaultInjector(FaultOption{Enabled: true}).Enabled())
+
+	t.Setenv(FaultInjectionEnv, "true")
+	assert.True(t, NewFaultInjector(FaultOption{}).Enabled())
+}
@@ -0,0 +1,360 @@
+package hcl
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type HAROption struct {
+	// Path of the archive written by Save.
+	Path string
+	// MaxEntries is the number of most recent exchanges kept, defaults to 1000.
+	MaxEntries int
+	// MaxBodySize caps the bytes of a response body kept in an entry, defaults
+	// to 1 MiB. The body is read into memory up to this size, a larger one is
+	// recorded truncated and the rest is streamed to the caller.
+	MaxBodySize int64
+	// Masks scrub headers, query parameters and bodies, the credentials and
+	// the fields set with SetMaskedField are always masked.
+	Masks []*MaskConfig
+}
+
+// HARRecorder keeps the exchanges of a client with their timings and writes
+// them as a HAR 1.2 archive that can be opened in browser dev tools, see
+// HCL.HAR.
+type HARRecorder struct {
+	options HAROption
+
+	mu      sync.Mutex
+	entries []harEntry
+}
+
+type harArchive struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	// fields starting with an underscore are extensions allowed by HAR
+	CacheStatus string `json:"_cache,omitempty"`
+	Error       string `json:"_error,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	// Encoding is "base64" when Text holds a binary body.
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// harTimings are in milliseconds, -1 when the phase did not happen.
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+func NewHARRecorder(options HAROption) *HARRecorder {
+	if options.MaxEntries <= 0 {
+		options.MaxEntries = 1000
+	}
+	if options.MaxBodySize <= 0 {
+		options.MaxBodySize = 1 << 20
+	}
+	return &HARRecorder{options: options}
+}
+
+func (h *HARRecorder) middleware(r *Request, next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var reqBody []byte
+		if req.GetBody != nil {
+			if body, err := req.GetBody(); err == nil {
+				reqBody, _ = io.ReadAll(body)
+				body.Close()
+			}
+		}
+
+		start := time.Now()
+		resp, err := next.RoundTrip(req)
+
+		masks := h.options.Masks
+		if requestMasks := r.masks(); len(requestMasks) > 0 {
+			masks = append(masks[:len(masks):len(masks)], requestMasks...)
+		}
+		entry := harEntry{
+			StartedDateTime: start.Format(time.RFC3339Nano),
+			Request:         harRequestOf(req, reqBody, masks),
+		}
+
+		if err != nil {
+			entry.Error = err.Error()
+			entry.Response = harResponse{
+				HTTPVersion: harProto(""),
+				Cookies:     []harNameValue{},
+				Headers:     []harNameValue{},
+				HeadersSize: -1,
+				BodySize:    -1,
+			}
+		} else if resp.Body != nil {
+			respBody, readErr := io.ReadAll(io.LimitReader(resp.Body, h.options.MaxBodySize+1))
+			truncated := int64(len(respBody)) > h.options.MaxBodySize
+			switch {
+			case readErr != nil:
+				// the caller gets the bytes read so far and then the error
+				entry.Error = readErr.Error()
+				resp.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(respBody), errReader{readErr}), Closer: resp.Body}
+			case truncated:
+				resp.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(respBody), resp.Body), Closer: resp.Body}
+				respBody = respBody[:h.options.MaxBodySize]
+			default:
+				resp.Body.Close()
+				resp.Body = io.NopCloser(bytes.NewBuffer(respBody))
+			}
+			entry.Response = harResponseOf(resp, respBody, masks)
+			if truncated {
+				entry.Response.Content.Comment = "truncated to " + strconv.FormatInt(h.options.MaxBodySize, 10) + " bytes"
+				entry.Response.BodySize = -1
+			}
+		}
+
+		ex := exchangeFrom(req.Context())
+		total := time.Since(start)
+		entry.Time = durationMs(total)
+		entry.Timings = harTimingsOf(ex.lastTimings(), total)
+		entry.CacheStatus = ex.cacheStatus()
+		h.add(entry)
+
+		return resp, err
+	})
+}
+
+func (h *HARRecorder) add(entry harEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.entries = append(h.entries, entry)
+	if over := len(h.entries) - h.options.MaxEntries; over > 0 {
+		h.entries = append(h.entries[:0:0], h.entries[over:]...)
+	}
+}
+
+func harRequestOf(req *http.Request, body []byte, masks []*MaskConfig) harRequest {
+	u := *req.URL
+	query := maskQuery(u.Query(), masks)
+	if len(masks) > 0 && u.RawQuery != "" {
+		u.RawQuery = query.Encode()
+	}
+
+	out := harRequest{
+		Method:      req.Method,
+		URL:         u.String(),
+		HTTPVersion: harProto(req.Proto),
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(maskHeader(req.Header, masks)),
+		QueryString: harHeaders(query),
+		HeadersSize: -1,
+		BodySize:    len(body),
+	}
+	if len(body) > 0 {
+		ct := req.Header.Get(contentType)
+		out.PostData = &harPostData{MimeType: ct, Text: maskPayload(ct, string(body), masks)}
+	}
+	return out
+}
+
+func harResponseOf(resp *http.Response, body []byte, masks []*MaskConfig) harResponse {
+	ct := resp.Header.Get(contentType)
+	content := harContent{
+		Size:     len(body),
+		MimeType: ct,
+		Text:     maskPayload(ct, string(body), masks),
+	}
+	// a JSON string cannot hold binary bodies
+	if !utf8.ValidString(content.Text) {
+		content.Text = base64.StdEncoding.EncodeToString([]byte(content.Text))
+		content.Encoding = "base64"
+	}
+
+	return harResponse{
+		Status:      resp.StatusCode,
+		StatusText:  http.StatusText(resp.StatusCode),
+		HTTPVersion: harProto(resp.Proto),
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(maskHeader(resp.Header, masks)),
+		Content:     content,
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    len(body),
+	}
+}
+
+func harHeaders(values map[string][]string) []harNameValue {
+	out := []harNameValue{}
+	for name, list := range values {
+		for _, value := range list {
+			out = append(out, harNameValue{Name: name, Value: value})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func harProto(proto string) string {
+	if proto == "" {
+		return "HTTP/1.1"
+	}
+	return proto
+}
+
+// harTimingsOf maps the phases of the last attempt, the time spent before it,
+// e.g. in queues and earlier attempts, is reported as blocked.
+func harTimingsOf(t Timings, total time.Duration) harTimings {
+	out := harTimings{
+		Blocked: -1,
+		DNS:     -1,
+		Connect: -1,
+		SSL:     -1,
+		Wait:    durationMs(t.TimeToFirstByte),
+		Receive: durationMs(t.BodyRead),
+	}
+
+	spent := t.TimeToFirstByte + t.BodyRead
+	if t.DNS > 0 {
+		out.DNS = durationMs(t.DNS)
+		spent += t.DNS
+	}
+	// HAR counts the TLS handshake in connect
+	if t.Connect > 0 || t.TLSHandshake > 0 {
+		out.Connect = durationMs(t.Connect + t.TLSHandshake)
+		spent += t.Connect + t.TLSHandshake
+	}
+	if t.TLSHandshake > 0 {
+		out.SSL = durationMs(t.TLSHandshake)
+	}
+	if total > spent {
+		out.Blocked = durationMs(total - spent)
+	}
+	return out
+}
+
+// Save writes the recorded exchanges to Path.
+func (h *HARRecorder) Save() error {
+	h.mu.Lock()
+	archive := harArchive{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "hcl", Version: moduleVersion()},
+		Entries: append([]harEntry{}, h.entries...),
+	}}
+	h.mu.Unlock()
+
+	b, err := json.MarshalIndent(archive, "", "  ")
+	if err != nil {
+		return errors.New("failed to encode HAR: " + err.Error())
+	}
+
+	if err = os.MkdirAll(filepath.Dir(h.options.Path), 0o755); err != nil {
+		return errors.New("failed to write HAR: " + err.Error())
+	}
+	if err = os.WriteFile(h.options.Path, b, 0o644); err != nil {
+		return errors.New("failed to write HAR: " + err.Error())
+	}
+	return nil
+}
+
+// moduleVersion returns the version of this module in the running binary.
+func moduleVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == "github.com/Rahmatulah12/hcl" {
+			return dep.Version
+		}
+	}
+	return "(devel)"
+}
+
+func (r *Request) harMiddleware(next http.RoundTripper) http.RoundTripper {
+	if r.har == nil {
+		return next
+	}
+	return r.har.middleware(r, next)
+}
+
+// errReader fails every read with err.
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
@@ -0,0 +1,145 @@
+package hcl
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHARRecorder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"1","token":"secret-token"}`))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "exchanges.har")
+	har := NewHARRecorder(HAROption{Path: path, MaxEntries: 2, Masks: []*MaskConfig{{Field: "token", MaskType: FullMask}}})
+	hcl := &HCL{HAR: har, BearerToken: "secret-bearer"}
+
+	_, err := New(hcl).SetUrl(server.URL + "/first").Get()
+	assert.NoError(t, err)
+
+	resp, err := New(hcl).SetUrl(server.URL + "/orders?status=open").SetJsonPayload(map[string]string{"token": "secret-token"}).Post()
+	assert.NoError(t, err)
+	body, _ := resp.ByteResult()
+	assert.Contains(t, string(body), "secret-token")
+
+	_, err = New(hcl).SetUrl("http://127.0.0.1:1/down").Get()
+	assert.Error(t, err)
+	assert.NoError(t, har.Save())
+
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), "secret-token")
+	assert.NotContains(t, string(b), "secret-bearer")
+
+	var archive harArchive
+	assert.NoError(t, json.Unmarshal(b, &archive))
+	assert.Equal(t, "1.2", archive.Log.Version)
+
+	// only the most recent entries are kept
+	entries := archive.Log.Entries
+	assert.Len(t, entries, 2)
+
+	post := entries[0]
+	assert.Equal(t, http.MethodPost, post.Request.Method)
+	assert.Equal(t, server.URL+"/orders?status=open", post.Request.URL)
+	assert.Equal(t, []harNameValue{{Name: "status", Value: "open"}}, post.Request.QueryString)
+	assert.Equal(t, `{"token":"************"}`, post.Request.PostData.Text)
+	assert.Equal(t, http.StatusOK, post.Response.Status)
+	assert.Equal(t, "application/json", post.Response.Content.MimeType)
+	assert.Equal(t, `{"id":"1","token":"************"}`, post.Response.Content.Text)
+	assert.Greater(t, post.Time, 0.0)
+	_, err = time.Parse(time.RFC3339Nano, post.StartedDateTime)
+	assert.NoError(t, err)
+
+	down := entries[1]
+	assert.Equal(t, 0, down.Response.Status)
+	assert.NotEmpty(t, down.Error)
+}
+
+func TestHARBodyLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("0123456789"))
+	}))
+	defer server.Close()
+
+	har := NewHARRecorder(HAROption{MaxBodySize: 4})
+	resp, err := New(&HCL{HAR: har}).SetUrl(server.URL).Get()
+	assert.NoError(t, err)
+
+	// the caller still gets the whole body
+	body, _ := resp.ByteResult()
+	assert.Equal(t, "0123456789", string(body))
+
+	content := har.entries[0].Response.Content
+	assert.Equal(t, "0123", content.Text)
+	assert.Equal(t, "truncated to 4 bytes", content.Comment)
+}
+
+func TestHARBinaryBody(t *testing.T) {
+	image := []byte{0x89, 'P', 'N', 'G', 0x00, 0xff, 0xfe}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(image)
+	}))
+	defer server.Close()
+
+	har := NewHARRecorder(HAROption{})
+	_, err := New(&HCL{HAR: har}).SetUrl(server.URL).Get()
+	assert.NoError(t, err)
+
+	content := har.entries[0].Response.Content
+	assert.Equal(t, "base64", content.Encoding)
+	decoded, err := base64.StdEncoding.DecodeString(content.Text)
+	assert.NoError(t, err)
+	assert.Equal(t, image, decoded)
+}
+
+func TestHARBodyReadError(t *testing.T) {
+	readErr := errors.New("read error")
+	next := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       io.NopCloser(io.MultiReader(bytes.NewReader([]byte("partial")), &errorReader{err: readErr})),
+		}, nil
+	})
+
+	har := NewHARRecorder(HAROption{})
+	req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+	resp, err := har.middleware(New(nil), next).RoundTrip(req)
+	assert.NoError(t, err)
+
+	// the caller reads what was received and then the error
+	body, err := io.ReadAll(resp.Body)
+	assert.Equal(t, "partial", string(body))
+	assert.ErrorIs(t, err, readErr)
+	assert.Equal(t, "read error", har.entries[0].Error)
+}
+
+func TestHARTimings(t *testing.T) {
+	timings := harTimingsOf(Timings{
+		DNS:             time.Millisecond,
+		Connect:         2 * time.Millisecond,
+		TLSHandshake:    3 * time.Millisecond,
+		TimeToFirstByte: 4 * time.Millisecond,
+		BodyRead:        5 * time.Millisecond,
+	}, 20*time.Millisecond)
+	assert.Equal(t, harTimings{Blocked: 5, DNS: 1, Connect: 5, Wait: 4, Receive: 5, SSL: 3}, timings)
+
+	// a reused connection has no connection phases
+	timings = harTimingsOf(Timings{TimeToFirstByte: 4 * time.Millisecond, ConnReused: true}, 4*time.Millisecond)
+	assert.Equal(t, harTimings{Blocked: -1, DNS: -1, Connect: -1, Wait: 4, SSL: -1}, timings)
+}
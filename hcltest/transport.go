@@ -0,0 +1,354 @@
+// Package hcltest provides a mock transport to unit test code built on hcl
+// without starting a server:
+//
+//	mock := hcltest.NewTransport()
+//	mock.On(http.MethodGet, "/networkprofile/{id}").ReplyJSON(http.StatusOK, profile)
+//
+//	r := hcl.New(&hcl.HCL{Client: mock.Client()})
+//	...
+//	mock.AssertExpectations(t)
+package hcltest
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TestingT is the part of testing.TB used by the assertions.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// Transport is a http.RoundTripper that answers requests with the first
+// registered expectation that matches them. Requests without expectation
+// fail and are reported by AssertExpectations.
+type Transport struct {
+	mu           sync.Mutex
+	expectations []*Expectation
+	unmatched    []string
+}
+
+func NewTransport() *Transport {
+	return &Transport{}
+}
+
+// Client returns a client that sends every request to the transport, see HCL.Client.
+func (t *Transport) Client() *http.Client {
+	return &http.Client{Transport: t}
+}
+
+// On registers an expectation for method and pattern. The pattern is a path,
+// or an absolute URL to also match the scheme and host, where a "{name}"
+// segment matches any segment, e.g. "/networkprofile/{id}".
+func (t *Transport) On(method, pattern string) *Expectation {
+	e := &Expectation{transport: t, method: strings.ToUpper(method), times: -1}
+	if i := strings.Index(pattern, "://"); i >= 0 {
+		if j := strings.Index(pattern[i+3:], "/"); j >= 0 {
+			e.origin, pattern = pattern[:i+3+j], pattern[i+3+j:]
+		} else {
+			e.origin, pattern = pattern, "/"
+		}
+	}
+	e.segments = splitPath(pattern)
+
+	t.mu.Lock()
+	t.expectations = append(t.expectations, e)
+	t.mu.Unlock()
+	return e
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+
+	t.mu.Lock()
+	var (
+		e *Expectation
+		r *reply
+	)
+	for _, candidate := range t.expectations {
+		if candidate.matches(req) {
+			e = candidate
+			r = e.next()
+			break
+		}
+	}
+	if e == nil {
+		t.unmatched = append(t.unmatched, req.Method+" "+req.URL.String())
+	}
+	t.mu.Unlock()
+
+	if e == nil {
+		return nil, errors.New("hcltest: no expectation matches " + req.Method + " " + req.URL.String())
+	}
+	if r == nil {
+		r = &reply{status: http.StatusOK}
+	}
+
+	if r.delay > 0 {
+		timer := time.NewTimer(r.delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.response(req), nil
+}
+
+// AssertExpectations reports every expectation that was not called as often
+// as expected and every request that matched none.
+func (t *Transport) AssertExpectations(tb TestingT) bool {
+	tb.Helper()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ok := true
+	for _, e := range t.expectations {
+		if e.times < 0 && e.calls == 0 {
+			tb.Errorf("hcltest: expected %s to be called", e)
+			ok = false
+		} else if e.times >= 0 && e.calls != e.times {
+			tb.Errorf("hcltest: expected %s to be called %d times, got %d", e, e.times, e.calls)
+			ok = false
+		}
+	}
+	for _, req := range t.unmatched {
+		tb.Errorf("hcltest: unexpected request %s", req)
+		ok = false
+	}
+	return ok
+}
+
+// Requests returns the number of requests received, including the unmatched ones.
+func (t *Transport) Requests() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := len(t.unmatched)
+	for _, e := range t.expectations {
+		n += e.calls
+	}
+	return n
+}
+
+// Expectation is a request the transport expects and its replies. Replies
+// are used in sequence, the last one is repeated.
+type Expectation struct {
+	transport *Transport
+	method    string
+	origin    string
+	segments  []string
+	headers   http.Header
+	query     map[string]string
+	match     func(*http.Request) bool
+
+	replies []*reply
+	times   int
+	calls   int
+}
+
+type reply struct {
+	status int
+	header http.Header
+	body   []byte
+	delay  time.Duration
+	err    error
+}
+
+func (r *reply) response(req *http.Request) *http.Response {
+	header := r.header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+
+	return &http.Response{
+		Status:        strconv.Itoa(r.status) + " " + http.StatusText(r.status),
+		StatusCode:    r.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(string(r.body))),
+		ContentLength: int64(len(r.body)),
+		Request:       req,
+	}
+}
+
+// WithHeader only matches requests that have the header value.
+func (e *Expectation) WithHeader(key, val string) *Expectation {
+	if e.headers == nil {
+		e.headers = make(http.Header)
+	}
+	e.headers.Add(key, val)
+	return e
+}
+
+// WithQuery only matches requests that have the query parameter value.
+func (e *Expectation) WithQuery(key, val string) *Expectation {
+	if e.query == nil {
+		e.query = make(map[string]string)
+	}
+	e.query[key] = val
+	return e
+}
+
+// Match only matches requests accepted by fn, e.g. to check the body.
+func (e *Expectation) Match(fn func(*http.Request) bool) *Expectation {
+	e.match = fn
+	return e
+}
+
+// Reply adds a reply with status and body.
+func (e *Expectation) Reply(status int, body string) *Expectation {
+	e.replies = append(e.replies, &reply{status: status, body: []byte(body)})
+	return e
+}
+
+// ReplyJSON adds a reply with status and v encoded as JSON.
+func (e *Expectation) ReplyJSON(status int, v interface{}) *Expectation {
+	body, err := json.Marshal(v)
+	if err != nil {
+		panic("hcltest: failed to encode reply: " + err.Error())
+	}
+
+	e.replies = append(e.replies, &reply{status: status, body: body})
+	return e.Header("Content-Type", "application/json")
+}
+
+// ReplyError adds a reply that fails with err, e.g. a connection error.
+func (e *Expectation) ReplyError(err error) *Expectation {
+	e.replies = append(e.replies, &reply{err: err})
+	return e
+}
+
+// Header sets a header of the last reply.
+func (e *Expectation) Header(key, val string) *Expectation {
+	r := e.last()
+	if r.header == nil {
+		r.header = make(http.Header)
+	}
+	r.header.Set(key, val)
+	return e
+}
+
+// Delay holds the last reply back, or until the request is cancelled.
+func (e *Expectation) Delay(d time.Duration) *Expectation {
+	e.last().delay = d
+	return e
+}
+
+// Times is the number of calls expected, later requests are not matched.
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+func (e *Expectation) Once() *Expectation {
+	return e.Times(1)
+}
+
+// Calls returns the number of requests that matched.
+func (e *Expectation) Calls() int {
+	e.transport.mu.Lock()
+	defer e.transport.mu.Unlock()
+	return e.calls
+}
+
+func (e *Expectation) String() string {
+	return e.method + " " + e.origin + "/" + strings.Join(e.segments, "/")
+}
+
+func (e *Expectation) last() *reply {
+	if len(e.replies) <= 0 {
+		e.replies = append(e.replies, &reply{status: http.StatusOK})
+	}
+	return e.replies[len(e.replies)-1]
+}
+
+// next counts the call and returns its reply.
+func (e *Expectation) next() *reply {
+	e.calls++
+	if len(e.replies) <= 0 {
+		return nil
+	}
+	if e.calls > len(e.replies) {
+		return e.replies[len(e.replies)-1]
+	}
+	return e.replies[e.calls-1]
+}
+
+func (e *Expectation) matches(req *http.Request) bool {
+	if e.times >= 0 && e.calls >= e.times {
+		return false
+	}
+	if e.method != req.Method {
+		return false
+	}
+	if e.origin != "" && e.origin != req.URL.Scheme+"://"+req.URL.Host {
+		return false
+	}
+
+	segments := splitPath(req.URL.Path)
+	if len(segments) != len(e.segments) {
+		return false
+	}
+	for i, s := range e.segments {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			if segments[i] == "" {
+				return false
+			}
+			continue
+		}
+		if s != segments[i] {
+			return false
+		}
+	}
+
+	for key, values := range e.headers {
+		for _, val := range values {
+			if !contains(req.Header.Values(key), val) {
+				return false
+			}
+		}
+	}
+
+	query := req.URL.Query()
+	for key, val := range e.query {
+		if !contains(query[key], val) {
+			return false
+		}
+	}
+
+	return e.match == nil || e.match(req)
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+func contains(values []string, val string) bool {
+	for _, v := range values {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package hcltest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Rahmatulah12/hcl"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeT struct {
+	errors []string
+}
+
+func (f *fakeT) Helper() {}
+
+func (f *fakeT) Errorf(format string, args ...interface{}) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func TestTransportReplies(t *testing.T) {
+	mock := NewTransport()
+	profile := mock.On(http.MethodGet, "/networkprofile/{id}").
+		ReplyJSON(http.StatusOK, map[string]string{"id": "1"}).
+		Reply(http.StatusNotFound, "")
+	mock.On(http.MethodPost, "https://api.example.com/orders").
+		WithHeader("X-Tenant", "a").
+		Reply(http.StatusCreated, "created").Header("Location", "/orders/1").
+		Once()
+
+	client := &hcl.HCL{Client: mock.Client()}
+
+	resp, err := hcl.New(client).SetUrl("https://api.example.com/networkprofile/1").Get()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "200 OK", resp.Status)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	body, _ := resp.ByteResult()
+	assert.JSONEq(t, `{"id":"1"}`, string(body))
+
+	// the last reply of a sequence is repeated
+	for i := 0; i < 2; i++ {
+		resp, err = hcl.New(client).SetUrl("https://api.example.com/networkprofile/2").Get()
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+	assert.Equal(t, 3, profile.Calls())
+
+	resp, err = hcl.New(client).SetUrl("https://api.example.com/orders").SetHeader("X-Tenant", "a").Post()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "/orders/1", resp.Header.Get("Location"))
+
+	assert.True(t, mock.AssertExpectations(t))
+	assert.Equal(t, 4, mock.Requests())
+}
+
+func TestTransportErrorsAndDelays(t *testing.T) {
+	mock := NewTransport()
+	mock.On(http.MethodGet, "/down").ReplyError(errors.New("connection reset"))
+	mock.On(http.MethodGet, "/slow").Reply(http.StatusOK, "").Delay(time.Second)
+
+	client := &hcl.HCL{Client: mock.Client()}
+
+	_, err := hcl.New(client).SetUrl("http://svc/down").Get()
+	assert.ErrorContains(t, err, "connection reset")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = hcl.New(&hcl.HCL{Client: mock.Client(), Context: ctx}).SetUrl("http://svc/slow").Get()
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+
+	assert.True(t, mock.AssertExpectations(t))
+}
+
+func TestTransportAssertExpectations(t *testing.T) {
+	mock := NewTransport()
+	mock.On(http.MethodGet, "/users/{id}").Reply(http.StatusOK, "")
+	mock.On(http.MethodDelete, "/users/{id}").Reply(http.StatusNoContent, "").Times(2)
+	mock.On(http.MethodGet, "/orders").WithQuery("status", "open").Reply(http.StatusOK, "")
+
+	client := &hcl.HCL{Client: mock.Client()}
+
+	_, err := hcl.New(client).SetUrl("http://svc/users/1").Delete()
+	assert.NoError(t, err)
+	_, err = hcl.New(client).SetUrl("http://svc/orders").SetQueryParam("status", "closed").Get()
+	assert.ErrorContains(t, err, "hcltest: no expectation matches GET http://svc/orders?status=closed")
+
+	ft := &fakeT{}
+	assert.False(t, mock.AssertExpectations(ft))
+	assert.Equal(t, []string{
+		"hcltest: expected GET /users/{id} to be called",
+		"hcltest: expected DELETE /users/{id} to be called 2 times, got 1",
+		"hcltest: expected GET /orders to be called",
+		"hcltest: unexpected request GET http://svc/orders?status=closed",
+	}, ft.errors)
+}
@@ -0,0 +1,285 @@
+package hcl
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type HealthCheckOption struct {
+	// Path is appended to the endpoint URL, defaults to "/health".
+	Path string
+	// Interval between checks defaults to 10s, Timeout of a check to 2s.
+	Interval time.Duration
+	Timeout  time.Duration
+	// ExpectedStatus lists the healthy status codes, defaults to any 2xx.
+	ExpectedStatus []int
+	// HealthyThreshold consecutive passes restore an upstream, defaults to 2.
+	HealthyThreshold int
+	// UnhealthyThreshold consecutive failures eject it, defaults to 3.
+	UnhealthyThreshold int
+	// Client defaults to the shared client, see NewTransport.
+	Client *http.Client
+	// OnChange is called when an upstream becomes healthy or unhealthy.
+	OnChange func(url string, healthy bool)
+}
+
+// HealthChecker probes upstreams in the background and ejects the unhealthy
+// ones from a Balancer, a CircuitBreaker or a CircuitBreakerRedis without
+// waiting for user requests to fail. Stop it on shutdown.
+type HealthChecker struct {
+	options HealthCheckOption
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+	once    sync.Once
+
+	mu        sync.Mutex
+	balancers []*Balancer
+	breakers  []breakerHealthTarget
+	redis     []redisHealthTarget
+	states    map[string]*healthState
+}
+
+type breakerHealthTarget struct {
+	url string
+	cb  *CircuitBreaker
+}
+
+type redisHealthTarget struct {
+	url string
+	cb  *CircuitBreakerRedis
+	key string
+}
+
+type healthState struct {
+	healthy  bool
+	passes   int
+	failures int
+}
+
+func NewHealthChecker(options HealthCheckOption) *HealthChecker {
+	if options.Path == "" {
+		options.Path = "/health"
+	}
+	if !strings.HasPrefix(options.Path, "/") {
+		options.Path = "/" + options.Path
+	}
+	if options.Interval <= 0 {
+		options.Interval = 10 * time.Second
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 2 * time.Second
+	}
+	if options.HealthyThreshold <= 0 {
+		options.HealthyThreshold = 2
+	}
+	if options.UnhealthyThreshold <= 0 {
+		options.UnhealthyThreshold = 3
+	}
+	if options.Client == nil {
+		options.Client = defaultHTTPClient()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &HealthChecker{
+		options: options,
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		states:  make(map[string]*healthState),
+	}
+	go h.run()
+	return h
+}
+
+// Watch checks every endpoint of the balancer, including the ones of resolved services.
+func (h *HealthChecker) Watch(b *Balancer) {
+	h.mu.Lock()
+	h.balancers = append(h.balancers, b)
+	h.mu.Unlock()
+}
+
+// WatchCircuitBreaker checks url and keeps the breaker open while it is
+// unhealthy, e.g. HCL.Cb.
+func (h *HealthChecker) WatchCircuitBreaker(url string, cb *CircuitBreaker) {
+	h.mu.Lock()
+	h.breakers = append(h.breakers, breakerHealthTarget{url: url, cb: cb})
+	h.mu.Unlock()
+}
+
+// WatchCircuitBreakerRedis checks url and keeps the breaker of key open
+// while it is unhealthy.
+func (h *HealthChecker) WatchCircuitBreakerRedis(url string, cb *CircuitBreakerRedis, key string) {
+	h.mu.Lock()
+	h.redis = append(h.redis, redisHealthTarget{url: url, cb: cb, key: key})
+	h.mu.Unlock()
+}
+
+// Healthy reports the last known health of an endpoint or watched URL, unknown ones are healthy.
+func (h *HealthChecker) Healthy(url string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	state, ok := h.states[strings.TrimSuffix(url, "/")]
+	return !ok || state.healthy
+}
+
+// Stop ends the checks and waits for the running ones to finish.
+func (h *HealthChecker) Stop() {
+	h.once.Do(func() {
+		h.cancel()
+		<-h.done
+	})
+}
+
+func (h *HealthChecker) run() {
+	defer close(h.done)
+
+	ticker := time.NewTicker(h.options.Interval)
+	defer ticker.Stop()
+
+	for {
+		h.checkAll()
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// healthTarget is one probe and what to do with its health.
+type healthTarget struct {
+	url   string
+	apply func(healthy bool)
+}
+
+func (h *HealthChecker) targets() []healthTarget {
+	h.mu.Lock()
+	balancers := append([]*Balancer(nil), h.balancers...)
+	breakers := append([]breakerHealthTarget(nil), h.breakers...)
+	redis := append([]redisHealthTarget(nil), h.redis...)
+	h.mu.Unlock()
+
+	var targets []healthTarget
+	for _, b := range balancers {
+		for _, p := range b.pools() {
+			p.mu.Lock()
+			for _, ep := range p.endpoints {
+				ep := ep
+				targets = append(targets, healthTarget{
+					url: ep.base.String(),
+					apply: func(healthy bool) {
+						ep.ejected.Store(!healthy)
+						if healthy {
+							ep.cb.reset()
+						}
+					},
+				})
+			}
+			p.mu.Unlock()
+		}
+	}
+	for _, t := range breakers {
+		t := t
+		targets = append(targets, healthTarget{
+			url: strings.TrimSuffix(t.url, "/"),
+			apply: func(healthy bool) {
+				if healthy {
+					t.cb.reset()
+				} else {
+					t.cb.trip()
+				}
+			},
+		})
+	}
+	for _, t := range redis {
+		t := t
+		targets = append(targets, healthTarget{
+			url: strings.TrimSuffix(t.url, "/"),
+			apply: func(healthy bool) {
+				if healthy {
+					t.cb.reset(t.key)
+				} else {
+					t.cb.trip(t.key)
+				}
+			},
+		})
+	}
+	return targets
+}
+
+func (h *HealthChecker) checkAll() {
+	var wg sync.WaitGroup
+	for _, t := range h.targets() {
+		wg.Add(1)
+		go func(t healthTarget) {
+			defer wg.Done()
+			h.record(t, h.probe(t.url))
+		}(t)
+	}
+	wg.Wait()
+}
+
+func (h *HealthChecker) probe(url string) bool {
+	ctx, cancel := context.WithTimeout(h.ctx, h.options.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+h.options.Path, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := h.options.Client.Do(req)
+	if err != nil {
+		return false
+	}
+	drainBody(resp)
+
+	if len(h.options.ExpectedStatus) > 0 {
+		return inArray(resp.StatusCode, h.options.ExpectedStatus)
+	}
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+func (h *HealthChecker) record(t healthTarget, passed bool) {
+	// a check cut short by Stop says nothing about the upstream
+	if h.ctx.Err() != nil {
+		return
+	}
+
+	h.mu.Lock()
+	state, ok := h.states[t.url]
+	if !ok {
+		state = &healthState{healthy: true}
+		h.states[t.url] = state
+	}
+
+	changed := false
+	if passed {
+		state.passes++
+		state.failures = 0
+		if !state.healthy && state.passes >= h.options.HealthyThreshold {
+			state.healthy, changed = true, true
+		}
+	} else {
+		state.failures++
+		state.passes = 0
+		if state.healthy && state.failures >= h.options.UnhealthyThreshold {
+			state.healthy, changed = false, true
+		}
+	}
+	healthy := state.healthy
+	h.mu.Unlock()
+
+	// an unhealthy upstream is ejected again on every check, a Redis breaker
+	// expires and endpoints may be replaced by a resolver
+	if changed || !healthy {
+		t.apply(healthy)
+	}
+	if changed && h.options.OnChange != nil {
+		h.options.OnChange(t.url, healthy)
+	}
+}
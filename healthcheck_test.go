@@ -0,0 +1,170 @@
+package hcl
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// newHealthServer answers /health with the stored status and other paths with its name.
+func newHealthServer(name string, status *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			w.WriteHeader(int(atomic.LoadInt32(status)))
+			return
+		}
+		fmt.Fprint(w, name)
+	}))
+}
+
+func TestHealthCheckerEjectsAndRestores(t *testing.T) {
+	okStatus, status := int32(http.StatusOK), int32(http.StatusServiceUnavailable)
+	a, b := newHealthServer("a", &okStatus), newHealthServer("b", &status)
+	defer a.Close()
+	defer b.Close()
+
+	balancer, _ := NewBalancer(BalancerOption{Endpoints: []Endpoint{{URL: a.URL}, {URL: b.URL}}})
+
+	var mu sync.Mutex
+	var changes []string
+	checker := NewHealthChecker(HealthCheckOption{
+		Interval:           5 * time.Millisecond,
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 2,
+		OnChange: func(url string, healthy bool) {
+			mu.Lock()
+			changes = append(changes, fmt.Sprint(url == b.URL, healthy))
+			mu.Unlock()
+		},
+	})
+	defer checker.Stop()
+	checker.Watch(balancer)
+
+	assert.Eventually(t, func() bool { return !checker.Healthy(b.URL) }, time.Second, 5*time.Millisecond)
+	assert.True(t, checker.Healthy(a.URL))
+	assert.True(t, balancer.Endpoints()[1].Ejected)
+
+	// the ejected endpoint gets no user requests
+	hcl := &HCL{Balancer: balancer}
+	for i := 0; i < 4; i++ {
+		resp, err := New(hcl).SetUrl("/orders").Get()
+		assert.NoError(t, err)
+		body, _ := resp.ByteResult()
+		assert.Equal(t, "a", string(body))
+	}
+
+	atomic.StoreInt32(&status, http.StatusOK)
+	assert.Eventually(t, func() bool { return checker.Healthy(b.URL) }, time.Second, 5*time.Millisecond)
+	assert.False(t, balancer.Endpoints()[1].Ejected)
+
+	mu.Lock()
+	assert.Equal(t, []string{"true false", "true true"}, changes)
+	mu.Unlock()
+}
+
+func TestHealthCheckerRestoresOpenBreaker(t *testing.T) {
+	status := int32(http.StatusOK)
+	a := newHealthServer("a", &status)
+	defer a.Close()
+
+	balancer, _ := NewBalancer(BalancerOption{Endpoints: []Endpoint{{URL: a.URL}}})
+	ep := balancer.static.endpoints[0]
+	ep.cb.reportResult(false)
+	ep.cb.mu.Lock()
+	ep.cb.setState(OPEN)
+	ep.cb.mu.Unlock()
+
+	checker := NewHealthChecker(HealthCheckOption{Interval: time.Hour, UnhealthyThreshold: 1, HealthyThreshold: 1})
+	defer checker.Stop()
+	checker.Watch(balancer)
+
+	target := checker.targets()[0]
+	checker.record(target, false)
+	checker.record(target, true)
+	assert.Equal(t, []EndpointStatus{{URL: a.URL, State: CLOSED}}, balancer.Endpoints())
+}
+
+func TestHealthCheckerCircuitBreaker(t *testing.T) {
+	status := int32(http.StatusServiceUnavailable)
+	server := newHealthServer("api", &status)
+	defer server.Close()
+
+	cb := NewCircuitBreaker(CircuitBreakerOption{MaxFailures: 3, HalfOpenLimit: 1, ResetTimeout: time.Minute})
+	hcl := &HCL{Cb: cb}
+
+	checker := NewHealthChecker(HealthCheckOption{Interval: time.Hour, UnhealthyThreshold: 1, HealthyThreshold: 1})
+	defer checker.Stop()
+	checker.WatchCircuitBreaker(server.URL, cb)
+
+	// requests built from the breaker are refused while it is unhealthy
+	checker.checkAll()
+	assert.False(t, checker.Healthy(server.URL))
+	_, err := New(hcl).SetUrl(server.URL).Get()
+	assert.Equal(t, errRefuse, err)
+
+	atomic.StoreInt32(&status, http.StatusOK)
+	checker.checkAll()
+	assert.True(t, checker.Healthy(server.URL))
+	resp, err := New(hcl).SetUrl(server.URL).Get()
+	assert.NoError(t, err)
+	body, _ := resp.ByteResult()
+	assert.Equal(t, "api", string(body))
+
+	// the request breaker keeps MaxFailures, one error status does not open it
+	atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+	r := New(hcl)
+	_, err = r.SetUrl(server.URL).Get()
+	assert.NoError(t, err)
+	assert.Equal(t, CLOSED, r.Cb.state)
+}
+
+func TestHealthCheckerCircuitBreakerRedis(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	cb := NewCircuitBreakerRedis(&CircuitBreakerRedis{Client: db, FailureLimit: 3, ResetTimeout: time.Minute})
+
+	checker := NewHealthChecker(HealthCheckOption{Interval: time.Hour, UnhealthyThreshold: 1, HealthyThreshold: 2})
+	defer checker.Stop()
+	checker.WatchCircuitBreakerRedis("http://billing/", cb, "billing")
+
+	// the breaker is kept open on every failed check, and closed once healthy
+	mock.ExpectSet("billing", 3, time.Minute).SetVal("OK")
+	mock.ExpectSet("billing", 3, time.Minute).SetVal("OK")
+	mock.ExpectDel("billing").SetVal(1)
+
+	target := checker.targets()[0]
+	checker.record(target, false)
+	checker.record(target, false)
+	checker.record(target, true)
+	assert.False(t, checker.Healthy("http://billing"))
+	checker.record(target, true)
+	assert.True(t, checker.Healthy("http://billing"))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHealthCheckerStop(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	server := newBlockingServer(&calls, release)
+	defer server.Close()
+	defer close(release)
+
+	balancer, _ := NewBalancer(BalancerOption{Endpoints: []Endpoint{{URL: server.URL}}})
+	checker := NewHealthChecker(HealthCheckOption{Interval: 5 * time.Millisecond, UnhealthyThreshold: 1})
+	checker.Watch(balancer)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&calls) == 1 }, time.Second, 5*time.Millisecond)
+
+	// a running check is cancelled and does not eject the endpoint
+	start := time.Now()
+	checker.Stop()
+	checker.Stop()
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.True(t, checker.Healthy(server.URL))
+}
@@ -0,0 +1,283 @@
+package hcl
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+type HedgeOption struct {
+	// Delay before a hedge is sent. Zero uses the p95 latency of the host,
+	// hedging starts once MinSamples latencies are known.
+	Delay      time.Duration
+	MinSamples int
+	// MaxHedges is the number of hedges per request, defaults to 1.
+	MaxHedges int
+	// Budget is the share of requests that may be hedged, defaults to 0.1.
+	Budget float64
+	// Methods are the idempotent methods that are hedged, defaults to GET and HEAD.
+	Methods []string
+}
+
+// Hedger sends another attempt when a request is slower than the delay, the
+// first successful response wins and the others are cancelled, see HCL.Hedger.
+type Hedger struct {
+	options HedgeOption
+
+	mu        sync.Mutex
+	tokens    float64
+	latencies map[string]*latencyWindow
+}
+
+// hedgeBudgetMax caps the hedges saved by idle periods.
+const hedgeBudgetMax = 10
+
+func NewHedger(options HedgeOption) *Hedger {
+	if options.MaxHedges <= 0 {
+		options.MaxHedges = 1
+	}
+	if options.Budget <= 0 {
+		options.Budget = 0.1
+	}
+	if options.MinSamples <= 0 {
+		options.MinSamples = 20
+	}
+	if len(options.Methods) <= 0 {
+		options.Methods = []string{http.MethodGet, http.MethodHead}
+	}
+
+	return &Hedger{
+		options:   options,
+		latencies: make(map[string]*latencyWindow),
+	}
+}
+
+// latencyWindow keeps the latest latencies of a host.
+type latencyWindow struct {
+	samples []time.Duration
+	next    int
+}
+
+const latencyWindowSize = 256
+
+func (w *latencyWindow) add(d time.Duration) {
+	if len(w.samples) < latencyWindowSize {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % latencyWindowSize
+}
+
+func (w *latencyWindow) percentile(p float64) time.Duration {
+	sorted := append([]time.Duration(nil), w.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(float64(len(sorted)-1)*p)]
+}
+
+func (h *Hedger) observe(host string, d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	w, ok := h.latencies[host]
+	if !ok {
+		w = &latencyWindow{}
+		h.latencies[host] = w
+	}
+	w.add(d)
+}
+
+// delay returns how long to wait before hedging a request to host.
+func (h *Hedger) delay(host string) (time.Duration, bool) {
+	if h.options.Delay > 0 {
+		return h.options.Delay, true
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	w, ok := h.latencies[host]
+	if !ok || len(w.samples) < h.options.MinSamples {
+		return 0, false
+	}
+	return w.percentile(0.95), true
+}
+
+// earn adds the budget of one request.
+func (h *Hedger) earn() {
+	h.mu.Lock()
+	h.tokens = min(hedgeBudgetMax, h.tokens+h.options.Budget)
+	h.mu.Unlock()
+}
+
+// spend takes the budget of one hedge.
+func (h *Hedger) spend() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.tokens < 1 {
+		return false
+	}
+	h.tokens--
+	return true
+}
+
+type hedgeKey struct{}
+
+// isHedge reports whether the attempt was sent by the hedger.
+func isHedge(ctx context.Context) bool {
+	hedge, _ := ctx.Value(hedgeKey{}).(bool)
+	return hedge
+}
+
+type hedgeResult struct {
+	index int
+	resp  *http.Response
+	err   error
+	rtt   time.Duration
+}
+
+func (r *Request) hedgeMiddleware(next http.RoundTripper) http.RoundTripper {
+	if r.hedger == nil {
+		return next
+	}
+
+	h := r.hedger
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if !inArray(req.Method, h.options.Methods) || !replayable(req) {
+			return next.RoundTrip(req)
+		}
+		h.earn()
+
+		host := req.URL.Host
+		delay, ok := h.delay(host)
+		if !ok {
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+			if err == nil {
+				h.observe(host, time.Since(start))
+			}
+			return resp, err
+		}
+
+		results := make(chan hedgeResult, h.options.MaxHedges+1)
+		var cancels []context.CancelFunc
+		var starts []time.Time
+		send := func(hedge bool) error {
+			ctx, cancel := context.WithCancel(req.Context())
+			if hedge {
+				ctx = context.WithValue(ctx, hedgeKey{}, true)
+			}
+			attempt, err := replay(req.WithContext(ctx))
+			if err != nil {
+				cancel()
+				return err
+			}
+
+			index, start := len(cancels), time.Now()
+			cancels = append(cancels, cancel)
+			starts = append(starts, start)
+			go func() {
+				resp, err := next.RoundTrip(attempt)
+				results <- hedgeResult{index: index, resp: resp, err: err, rtt: time.Since(start)}
+			}()
+			return nil
+		}
+
+		if err := send(false); err != nil {
+			return nil, err
+		}
+		pending, hedges := 1, 0
+		done := map[int]bool{}
+		ex := exchangeFrom(req.Context())
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		// an error status is only returned when no attempt does better
+		var lastErr error
+		var fallback *hedgeResult
+		for pending > 0 {
+			select {
+			case <-timer.C:
+				if hedges >= h.options.MaxHedges || !h.spend() {
+					continue
+				}
+				if err := send(true); err != nil {
+					continue
+				}
+				pending++
+				hedges++
+				ex.addHedge()
+				if r.metrics != nil {
+					r.metrics.IncHedge(req.Method, host)
+				}
+				timer.Reset(delay)
+			case res := <-results:
+				pending--
+				done[res.index] = true
+				if res.err != nil {
+					cancels[res.index]()
+					lastErr = res.err
+					continue
+				}
+
+				h.observe(host, res.rtt)
+				if r.isErrorStatus(res.resp.StatusCode) {
+					if fallback != nil {
+						drainBody(fallback.resp)
+						cancels[fallback.index]()
+					}
+					fallback = &res
+					continue
+				}
+
+				// the losers were at least this slow, they are cancelled now and
+				// the winner when its body is closed
+				for i, cancel := range cancels {
+					if i == res.index {
+						continue
+					}
+					if !done[i] {
+						h.observe(host, time.Since(starts[i]))
+					}
+					cancel()
+				}
+				if fallback != nil {
+					drainBody(fallback.resp)
+				}
+				go discardHedges(results, pending)
+				res.resp.Body = &cancelBody{ReadCloser: res.resp.Body, cancel: cancels[res.index]}
+				return res.resp, nil
+			}
+		}
+
+		if fallback != nil {
+			fallback.resp.Body = &cancelBody{ReadCloser: fallback.resp.Body, cancel: cancels[fallback.index]}
+			return fallback.resp, nil
+		}
+		return nil, lastErr
+	})
+}
+
+// discardHedges releases the responses of the cancelled attempts.
+func discardHedges(results chan hedgeResult, pending int) {
+	for ; pending > 0; pending-- {
+		drainBody((<-results).resp)
+	}
+}
+
+// cancelBody cancels the context of the attempt once the body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,146 @@
+package hcl
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newStallingServer stalls the first request until it is cancelled.
+func newStallingServer(calls, cancelled *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(calls, 1)
+		if n == 1 {
+			select {
+			case <-r.Context().Done():
+				atomic.AddInt32(cancelled, 1)
+			case <-time.After(2 * time.Second):
+			}
+			return
+		}
+		fmt.Fprintf(w, "call %d", n)
+	}))
+}
+
+func TestHedgeFirstResponseWins(t *testing.T) {
+	var calls, cancelled int32
+	server := newStallingServer(&calls, &cancelled)
+	defer server.Close()
+
+	metrics := newFakeMetrics()
+	hcl := &HCL{Hedger: NewHedger(HedgeOption{Delay: 20 * time.Millisecond, Budget: 1}), Metrics: metrics}
+
+	start := time.Now()
+	var resp *Response
+	output := captureOutput(func() {
+		var err error
+		resp, err = New(hcl).EnableLog(false).SetUrl(server.URL).Get()
+		assert.NoError(t, err)
+	})
+	body, _ := resp.ByteResult()
+
+	assert.Equal(t, "call 2", string(body))
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Contains(t, output, `"hedges":1`)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&cancelled) == 1 }, time.Second, 5*time.Millisecond)
+
+	assert.Equal(t, 1, metrics.hedges)
+	assert.Equal(t, 0, metrics.retries)
+
+	// the cancelled attempt is sampled with the time it ran
+	samples := hcl.Hedger.latencies[server.URL[len("http://"):]].samples
+	assert.Len(t, samples, 2)
+	assert.GreaterOrEqual(t, samples[1], samples[0])
+}
+
+func TestHedgeErrorStatusDoesNotWin(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			time.Sleep(30 * time.Millisecond)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		time.Sleep(60 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	hcl := &HCL{Hedger: NewHedger(HedgeOption{Delay: 10 * time.Millisecond, Budget: 1})}
+	resp, err := New(hcl).SetUrl(server.URL).Get()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ := resp.ByteResult()
+	assert.Equal(t, "ok", string(body))
+
+	// without a better attempt the error status is returned
+	var unavailableCalls int32
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&unavailableCalls, 1)
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+
+	hcl = &HCL{Hedger: NewHedger(HedgeOption{Delay: 10 * time.Millisecond, Budget: 1})}
+	resp, err = New(hcl).SetUrl(unavailable.URL).Get()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&unavailableCalls))
+}
+
+func TestHedgeSkipsUnsafeMethods(t *testing.T) {
+	var calls int32
+	server := newSlowServer(50*time.Millisecond, &calls)
+	defer server.Close()
+
+	hcl := &HCL{Hedger: NewHedger(HedgeOption{Delay: 10 * time.Millisecond, Budget: 1})}
+	_, err := New(hcl).SetUrl(server.URL).SetJsonPayload(map[string]string{"a": "b"}).Post()
+
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestHedgeBudget(t *testing.T) {
+	h := NewHedger(HedgeOption{Budget: 0.5})
+
+	// every request earns half a hedge
+	assert.False(t, h.spend())
+	h.earn()
+	assert.False(t, h.spend())
+	h.earn()
+	assert.True(t, h.spend())
+	assert.False(t, h.spend())
+
+	// idle periods save at most hedgeBudgetMax hedges
+	for i := 0; i < 100; i++ {
+		h.earn()
+	}
+	spent := 0
+	for h.spend() {
+		spent++
+	}
+	assert.Equal(t, hedgeBudgetMax, spent)
+}
+
+func TestHedgeDelayFromP95(t *testing.T) {
+	h := NewHedger(HedgeOption{MinSamples: 20})
+
+	for i := 1; i <= 19; i++ {
+		h.observe("svc", time.Duration(i)*time.Millisecond)
+	}
+	_, ok := h.delay("svc")
+	assert.False(t, ok)
+
+	for i := 20; i <= 100; i++ {
+		h.observe("svc", time.Duration(i)*time.Millisecond)
+	}
+	delay, ok := h.delay("svc")
+	assert.True(t, ok)
+	assert.Equal(t, 95*time.Millisecond, delay)
+}
@@ -17,12 +17,28 @@ const (
 )
 
 type log struct {
-	Time    string   `json:"time"`
-	Level   string   `json:"level"`
-	Latency string   `json:"latency"`
-	Error   string   `json:"error"`
-	Req     request  `json:"request,omitempty"`
-	Resp    response `json:"response,omitempty"`
+	Time      string      `json:"time"`
+	Level     string      `json:"level"`
+	Latency   string      `json:"latency"`
+	LatencyMs int64       `json:"latencyMs"`
+	Error     string      `json:"error"`
+	Req       request     `json:"request,omitempty"`
+	Resp      response    `json:"response,omitempty"`
+	Timings   *logTimings `json:"timings,omitempty"`
+	Cache     string      `json:"cache,omitempty"`
+	// RateLimitWaitMs is the time spent waiting on the rate limiter.
+	RateLimitWaitMs float64 `json:"rateLimitWaitMs,omitempty"`
+	// Hedges is the number of hedged attempts sent.
+	Hedges int `json:"hedges,omitempty"`
+}
+
+type logTimings struct {
+	DNSMs             float64 `json:"dnsMs"`
+	ConnectMs         float64 `json:"connectMs"`
+	TLSHandshakeMs    float64 `json:"tlsHandshakeMs"`
+	TimeToFirstByteMs float64 `json:"timeToFirstByteMs"`
+	BodyReadMs        float64 `json:"bodyReadMs"`
+	ConnReused        bool    `json:"connReused"`
 }
 
 type request struct {
@@ -76,7 +92,8 @@ func (lg *Log) setRequest(req *http.Request) {
 	if req.Body != nil {
 		reqBody, err := io.ReadAll(req.Body)
 		if err == nil {
-			lg.l.Req.Body = strings.Join(strings.Fields(string(reqBody)), "")
+			payload := maskPayload(req.Header.Get(contentType), string(reqBody), lg.maskedConfig)
+			lg.l.Req.Body = strings.Join(strings.Fields(payload), "")
 			req.Body = io.NopCloser(bytes.NewBuffer(reqBody))
 		}
 	}
@@ -92,12 +109,56 @@ func (lg *Log) setResponse(resp *http.Response) {
 	if resp.Body != nil {
 		respBody, err := io.ReadAll(resp.Body)
 		if err == nil {
-			lg.l.Resp.Body = strings.Join(strings.Fields(string(respBody)), "")
+			payload := maskPayload(resp.Header.Get(contentType), string(respBody), lg.maskedConfig)
+			lg.l.Resp.Body = strings.Join(strings.Fields(payload), "")
 			resp.Body = io.NopCloser(bytes.NewBuffer(respBody))
 		}
 	}
 }
 
+func (lg *Log) setTimings(t Timings) {
+	if lg == nil {
+		return
+	}
+
+	lg.l.Timings = &logTimings{
+		DNSMs:             durationMs(t.DNS),
+		ConnectMs:         durationMs(t.Connect),
+		TLSHandshakeMs:    durationMs(t.TLSHandshake),
+		TimeToFirstByteMs: durationMs(t.TimeToFirstByte),
+		BodyReadMs:        durationMs(t.BodyRead),
+		ConnReused:        t.ConnReused,
+	}
+}
+
+func (lg *Log) setCacheStatus(status string) {
+	if lg == nil {
+		return
+	}
+
+	lg.l.Cache = status
+}
+
+func (lg *Log) setRateLimitWait(d time.Duration) {
+	if lg == nil {
+		return
+	}
+
+	lg.l.RateLimitWaitMs = durationMs(d)
+}
+
+func (lg *Log) setHedges(hedges int) {
+	if lg == nil {
+		return
+	}
+
+	lg.l.Hedges = hedges
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
 func (lg *Log) setError(err error) {
 	if lg == nil || err == nil {
 		return
@@ -114,6 +175,7 @@ func (lg *Log) writeLog() {
 
 	latency := time.Since(lg.start).Milliseconds()
 	lg.l.Latency = fmt.Sprintf("%d ms", latency)
+	lg.l.LatencyMs = latency
 
 	dataLog := convertInterfaceToJson(lg.l)
 	dtLog := dataLog
@@ -68,6 +68,21 @@ func TestLogSetRequest(t *testing.T) {
 		bodyBytes, _ := io.ReadAll(req.Body)
 		assert.Equal(t, `{"key": "value"}`, string(bodyBytes))
 	})
+
+	t.Run("xml request with masked field", func(t *testing.T) {
+		log := NewLog()
+		log.maskedConfig = append(log.maskedConfig, &MaskConfig{Field: "Pin", MaskType: FullMask})
+		req, _ := http.NewRequest("POST", "https://example.com/test", strings.NewReader(`<Req><Pin>123456</Pin></Req>`))
+		req.Header.Set("Content-Type", "application/xml")
+
+		log.setRequest(req)
+
+		assert.Equal(t, `<Req><Pin>******</Pin></Req>`, log.l.Req.Body)
+
+		// Verify the original body is sent
+		bodyBytes, _ := io.ReadAll(req.Body)
+		assert.Equal(t, `<Req><Pin>123456</Pin></Req>`, string(bodyBytes))
+	})
 }
 
 func TestLogSetResponse(t *testing.T) {
@@ -6,6 +6,18 @@ const (
 	FullMask maskType = iota
 	PartialMask
 	Default
+	// HashMask replaces the value with a hex encoded HMAC-SHA256 of the value,
+	// keyed by MaskConfig.HashKey, so equal values can be correlated across logs.
+	// Without HashKey it falls back to FullMask.
+	HashMask
+	// EmailMask keeps the domain and the first ShowFirst characters (default 1)
+	// of the local part, e.g. j***@domain.com.
+	EmailMask
+	// PhoneMask masks the digits of an MSISDN while keeping the first ShowFirst
+	// (default 4) and last ShowLast (default 3) digits and any separators.
+	PhoneMask
+	// CustomMask delegates to MaskConfig.Masker.
+	CustomMask
 )
 
 type MaskConfig struct {
@@ -13,4 +25,10 @@ type MaskConfig struct {
 	MaskType  maskType
 	ShowFirst int
 	ShowLast  int
+	// HashKey is the secret used by HashMask, it is required.
+	HashKey []byte
+	// Masker is the function used by CustomMask.
+	Masker func(string) string
+	// KeepType masks numbers as zero of the same type instead of "*****".
+	KeepType bool
 }
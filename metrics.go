@@ -0,0 +1,225 @@
+package hcl
+
+import (
+	"expvar"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics receives request and circuit breaker measurements.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	// ObserveRequest records a finished attempt and its latency.
+	ObserveRequest(labels RequestLabels, duration time.Duration)
+	// InFlight adds delta to the number of requests currently in flight.
+	InFlight(method, host string, delta int)
+	// ObservePhase records the duration of a connection phase of an attempt:
+	// "dns", "connect", "tls", "ttfb" or "body_read".
+	ObservePhase(host, phase string, duration time.Duration)
+	// IncRetry counts an attempt that re-sends a request.
+	IncRetry(method, host string)
+	// SetBreakerState reports the current state of a circuit breaker.
+	SetBreakerState(name, state string)
+	// IncBreakerTransition counts a circuit breaker state transition.
+	IncBreakerTransition(name, from, to string)
+	// IncHedge counts a hedged attempt, hedges are not counted as retries.
+	IncHedge(method, host string)
+	// IncRejected counts a request refused before it was sent, reason is
+	// e.g. "bulkhead".
+	IncRejected(host, reason string)
+}
+
+type RequestLabels struct {
+	Method string
+	Host   string
+	// Route is the route template set with SetRouteTemplate, e.g. "/networkprofile/{id}".
+	Route string
+	// StatusClass is "2xx", "3xx", "4xx", "5xx" or "error" when no response was received.
+	StatusClass string
+}
+
+func statusClass(statusCode int) string {
+	if statusCode < 100 || statusCode > 599 {
+		return "error"
+	}
+	return strconv.Itoa(statusCode/100) + "xx"
+}
+
+func breakerStateValue(state string) float64 {
+	switch state {
+	case OPEN:
+		return 2
+	case HALF_OPEN:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (r *Request) beginAttemptMetrics(req *http.Request, rec *timingsRecorder, attempt int) {
+	if r.metrics == nil {
+		return
+	}
+
+	if attempt > 0 && !isHedge(req.Context()) {
+		r.metrics.IncRetry(req.Method, req.URL.Host)
+	}
+	r.metrics.InFlight(req.Method, req.URL.Host, 1)
+
+	if rec != nil {
+		metrics, host := r.metrics, req.URL.Host
+		rec.onBodyRead = func(d time.Duration) {
+			metrics.ObservePhase(host, "body_read", d)
+		}
+	}
+}
+
+func (r *Request) endAttemptMetrics(req *http.Request, resp *http.Response, rec *timingsRecorder, duration time.Duration) {
+	if r.metrics == nil {
+		return
+	}
+
+	t := rec.timings()
+	phases := []struct {
+		name     string
+		duration time.Duration
+	}{
+		{"dns", t.DNS},
+		{"connect", t.Connect},
+		{"tls", t.TLSHandshake},
+		{"ttfb", t.TimeToFirstByte},
+	}
+	for _, phase := range phases {
+		if phase.duration > 0 {
+			r.metrics.ObservePhase(req.URL.Host, phase.name, phase.duration)
+		}
+	}
+
+	statusCode := 0
+	if resp != nil {
+		statusCode = resp.StatusCode
+	}
+
+	r.metrics.InFlight(req.Method, req.URL.Host, -1)
+	r.metrics.ObserveRequest(RequestLabels{
+		Method:      req.Method,
+		Host:        req.URL.Host,
+		Route:       r.route,
+		StatusClass: statusClass(statusCode),
+	}, duration)
+}
+
+// observeCircuitBreakers reports breaker transitions of the request to its metrics.
+func (r *Request) observeCircuitBreakers() {
+	if r.metrics == nil {
+		return
+	}
+
+	if r.Cb != nil {
+		r.Cb.onStateChange = func(from, to string) {
+			name := r.cbKey
+			if name == "" && r.url != nil {
+				name = r.url.Host
+			}
+			r.metrics.IncBreakerTransition(name, from, to)
+			r.metrics.SetBreakerState(name, to)
+		}
+	}
+
+	if r.cbRedis != nil {
+		if r.cbRedis.states == nil {
+			r.cbRedis.states = &sync.Map{}
+		}
+		r.cbRedis.onStateChange = func(key, from, to string) {
+			if from != to {
+				r.metrics.IncBreakerTransition(key, from, to)
+			}
+			r.metrics.SetBreakerState(key, to)
+		}
+	}
+}
+
+// ExpvarMetrics publishes metrics through the standard expvar package, for
+// services that do not run a Prometheus registry.
+type ExpvarMetrics struct {
+	requests    *expvar.Map
+	latency     *expvar.Map
+	phases      *expvar.Map
+	inFlight    *expvar.Map
+	retries     *expvar.Map
+	state       *expvar.Map
+	transitions *expvar.Map
+	rejected    *expvar.Map
+	hedges      *expvar.Map
+}
+
+var expvarMu sync.Mutex
+
+// NewExpvarMetrics publishes the metrics under the given prefix, e.g. "hcl".
+// Calling it twice with the same prefix returns metrics backed by the same variables.
+func NewExpvarMetrics(prefix string) *ExpvarMetrics {
+	if prefix == "" {
+		prefix = "hcl"
+	}
+
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+
+	return &ExpvarMetrics{
+		requests:    expvarMap(prefix + ".requests"),
+		latency:     expvarMap(prefix + ".latency_ms"),
+		phases:      expvarMap(prefix + ".phase_latency_ms"),
+		inFlight:    expvarMap(prefix + ".in_flight"),
+		retries:     expvarMap(prefix + ".retries"),
+		state:       expvarMap(prefix + ".circuit_breaker_state"),
+		transitions: expvarMap(prefix + ".circuit_breaker_transitions"),
+		rejected:    expvarMap(prefix + ".rejected"),
+		hedges:      expvarMap(prefix + ".hedges"),
+	}
+}
+
+func expvarMap(name string) *expvar.Map {
+	if v, ok := expvar.Get(name).(*expvar.Map); ok {
+		return v
+	}
+	return expvar.NewMap(name)
+}
+
+func (m *ExpvarMetrics) ObserveRequest(labels RequestLabels, duration time.Duration) {
+	key := strings.Join([]string{labels.Method, labels.Host, labels.Route, labels.StatusClass}, " ")
+	m.requests.Add(key, 1)
+	m.latency.AddFloat(key, float64(duration)/float64(time.Millisecond))
+}
+
+func (m *ExpvarMetrics) ObservePhase(host, phase string, duration time.Duration) {
+	m.phases.AddFloat(host+" "+phase, float64(duration)/float64(time.Millisecond))
+}
+
+func (m *ExpvarMetrics) InFlight(method, host string, delta int) {
+	m.inFlight.Add(method+" "+host, int64(delta))
+}
+
+func (m *ExpvarMetrics) IncRetry(method, host string) {
+	m.retries.Add(method+" "+host, 1)
+}
+
+func (m *ExpvarMetrics) SetBreakerState(name, state string) {
+	v := new(expvar.String)
+	v.Set(state)
+	m.state.Set(name, v)
+}
+
+func (m *ExpvarMetrics) IncBreakerTransition(name, from, to string) {
+	m.transitions.Add(name+" "+from+"->"+to, 1)
+}
+
+func (m *ExpvarMetrics) IncHedge(method, host string) {
+	m.hedges.Add(method+" "+host, 1)
+}
+
+func (m *ExpvarMetrics) IncRejected(host, reason string) {
+	m.rejected.Add(host+" "+reason, 1)
+}
@@ -0,0 +1,145 @@
+package hcl
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type PrometheusMetricsOption struct {
+	// Registerer defaults to prometheus.DefaultRegisterer.
+	Registerer prometheus.Registerer
+	// Namespace prefixes every metric name, defaults to "hcl".
+	Namespace string
+	// Buckets for the latency histogram, defaults to prometheus.DefBuckets.
+	Buckets []float64
+}
+
+// PrometheusMetrics is the Prometheus implementation of Metrics.
+type PrometheusMetrics struct {
+	requests     *prometheus.CounterVec
+	duration     *prometheus.HistogramVec
+	phases       *prometheus.HistogramVec
+	inFlight     *prometheus.GaugeVec
+	retries      *prometheus.CounterVec
+	breakerState *prometheus.GaugeVec
+	transitions  *prometheus.CounterVec
+	rejected     *prometheus.CounterVec
+	hedges       *prometheus.CounterVec
+}
+
+func NewPrometheusMetrics(options PrometheusMetricsOption) (*PrometheusMetrics, error) {
+	if options.Registerer == nil {
+		options.Registerer = prometheus.DefaultRegisterer
+	}
+	if options.Namespace == "" {
+		options.Namespace = "hcl"
+	}
+	if len(options.Buckets) <= 0 {
+		options.Buckets = prometheus.DefBuckets
+	}
+
+	requestLabels := []string{"method", "host", "route", "status_class"}
+	m := &PrometheusMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: options.Namespace,
+			Subsystem: "http_client",
+			Name:      "requests_total",
+			Help:      "Total number of HTTP requests sent.",
+		}, requestLabels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: options.Namespace,
+			Subsystem: "http_client",
+			Name:      "request_duration_seconds",
+			Help:      "HTTP request latency in seconds.",
+			Buckets:   options.Buckets,
+		}, requestLabels),
+		phases: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: options.Namespace,
+			Subsystem: "http_client",
+			Name:      "phase_duration_seconds",
+			Help:      "HTTP connection phase latency in seconds.",
+			Buckets:   options.Buckets,
+		}, []string{"host", "phase"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: options.Namespace,
+			Subsystem: "http_client",
+			Name:      "requests_in_flight",
+			Help:      "Number of HTTP requests currently in flight.",
+		}, []string{"method", "host"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: options.Namespace,
+			Subsystem: "http_client",
+			Name:      "retries_total",
+			Help:      "Total number of re-sent HTTP requests.",
+		}, []string{"method", "host"}),
+		breakerState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: options.Namespace,
+			Subsystem: "circuit_breaker",
+			Name:      "state",
+			Help:      "Circuit breaker state: 0 closed, 1 half-open, 2 open.",
+		}, []string{"breaker"}),
+		transitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: options.Namespace,
+			Subsystem: "circuit_breaker",
+			Name:      "transitions_total",
+			Help:      "Total number of circuit breaker state transitions.",
+		}, []string{"breaker", "from", "to"}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: options.Namespace,
+			Subsystem: "http_client",
+			Name:      "rejected_total",
+			Help:      "Total number of HTTP requests refused before they were sent.",
+		}, []string{"host", "reason"}),
+		hedges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: options.Namespace,
+			Subsystem: "http_client",
+			Name:      "hedges_total",
+			Help:      "Total number of hedged HTTP requests sent.",
+		}, []string{"method", "host"}),
+	}
+
+	collectors := []prometheus.Collector{m.requests, m.duration, m.phases, m.inFlight, m.retries, m.breakerState, m.transitions, m.rejected, m.hedges}
+	for _, c := range collectors {
+		if err := options.Registerer.Register(c); err != nil {
+			return nil, errors.New("failed to register metrics: " + err.Error())
+		}
+	}
+
+	return m, nil
+}
+
+func (m *PrometheusMetrics) ObserveRequest(labels RequestLabels, duration time.Duration) {
+	values := []string{labels.Method, labels.Host, labels.Route, labels.StatusClass}
+	m.requests.WithLabelValues(values...).Inc()
+	m.duration.WithLabelValues(values...).Observe(duration.Seconds())
+}
+
+func (m *PrometheusMetrics) ObservePhase(host, phase string, duration time.Duration) {
+	m.phases.WithLabelValues(host, phase).Observe(duration.Seconds())
+}
+
+func (m *PrometheusMetrics) InFlight(method, host string, delta int) {
+	m.inFlight.WithLabelValues(method, host).Add(float64(delta))
+}
+
+func (m *PrometheusMetrics) IncRetry(method, host string) {
+	m.retries.WithLabelValues(method, host).Inc()
+}
+
+func (m *PrometheusMetrics) SetBreakerState(name, state string) {
+	m.breakerState.WithLabelValues(name).Set(breakerStateValue(state))
+}
+
+func (m *PrometheusMetrics) IncBreakerTransition(name, from, to string) {
+	m.transitions.WithLabelValues(name, from, to).Inc()
+}
+
+func (m *PrometheusMetrics) IncHedge(method, host string) {
+	m.hedges.WithLabelValues(method, host).Inc()
+}
+
+func (m *PrometheusMetrics) IncRejected(host, reason string) {
+	m.rejected.WithLabelValues(host, reason).Inc()
+}
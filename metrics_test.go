@@ -0,0 +1,193 @@
+package hcl
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v9"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeMetrics struct {
+	mu          sync.Mutex
+	requests    []RequestLabels
+	inFlight    int
+	maxInFlight int
+	retries     int
+	phases      map[string]int
+	states      map[string]string
+	transitions []string
+	rejected    []string
+	hedges      int
+}
+
+func newFakeMetrics() *fakeMetrics {
+	return &fakeMetrics{states: make(map[string]string), phases: make(map[string]int)}
+}
+
+func (f *fakeMetrics) ObserveRequest(labels RequestLabels, duration time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, labels)
+}
+
+func (f *fakeMetrics) InFlight(method, host string, delta int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inFlight += delta
+	f.maxInFlight = max(f.maxInFlight, f.inFlight)
+}
+
+func (f *fakeMetrics) ObservePhase(host, phase string, duration time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.phases[phase]++
+}
+
+func (f *fakeMetrics) IncRetry(method, host string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.retries++
+}
+
+func (f *fakeMetrics) SetBreakerState(name, state string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.states[name] = state
+}
+
+func (f *fakeMetrics) IncBreakerTransition(name, from, to string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.transitions = append(f.transitions, name+":"+from+"->"+to)
+}
+
+func (f *fakeMetrics) IncHedge(method, host string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.hedges++
+}
+
+func (f *fakeMetrics) IncRejected(host, reason string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rejected = append(f.rejected, reason)
+}
+
+func TestStatusClass(t *testing.T) {
+	assert.Equal(t, "2xx", statusClass(http.StatusOK))
+	assert.Equal(t, "4xx", statusClass(http.StatusNotFound))
+	assert.Equal(t, "5xx", statusClass(http.StatusBadGateway))
+	assert.Equal(t, "error", statusClass(0))
+}
+
+func TestRequestMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	metrics := newFakeMetrics()
+	cb := NewCircuitBreaker(CircuitBreakerOption{MaxFailures: 1, HalfOpenLimit: 1, ResetTimeout: time.Minute})
+	req := New(&HCL{Metrics: metrics, Cb: cb})
+
+	_, err := req.SetUrl(server.URL + "/networkprofile/1").
+		SetRouteTemplate("/networkprofile/{id}").
+		SetCircuitBreakerKey("profile").
+		Get()
+	assert.NoError(t, err)
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	assert.Equal(t, []RequestLabels{{Method: "GET", Host: host, Route: "/networkprofile/{id}", StatusClass: "5xx"}}, metrics.requests)
+	assert.Equal(t, 0, metrics.inFlight)
+	assert.Equal(t, 1, metrics.maxInFlight)
+	assert.Equal(t, []string{"profile:CLOSED->OPEN"}, metrics.transitions)
+	assert.Equal(t, OPEN, metrics.states["profile"])
+}
+
+func TestRequestMetricsTransportError(t *testing.T) {
+	metrics := newFakeMetrics()
+	_, err := New(&HCL{Metrics: metrics}).SetUrl("http://127.0.0.1:1/").Get()
+	assert.Error(t, err)
+	assert.Len(t, metrics.requests, 1)
+	assert.Equal(t, "error", metrics.requests[0].StatusClass)
+}
+
+func TestCircuitBreakerRedisStateMetrics(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	metrics := newFakeMetrics()
+
+	cbRedis := NewCircuitBreakerRedis(&CircuitBreakerRedis{Client: db, FailureLimit: 2, ResetTimeout: time.Second})
+	req := New(&HCL{Metrics: metrics, CbRedis: cbRedis})
+
+	mock.ExpectGet("svc").SetVal("1")
+	assert.NoError(t, req.cbRedis.allowRequest("svc"))
+	mock.ExpectIncr("svc").SetVal(2)
+	req.cbRedis.recordFailure("svc")
+	mock.ExpectGet("svc").SetVal("2")
+	assert.Equal(t, errRefuse, req.cbRedis.allowRequest("svc"))
+	mock.ExpectDel("svc").SetVal(1)
+	req.cbRedis.reset("svc")
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, []string{"svc:CLOSED->OPEN", "svc:OPEN->CLOSED"}, metrics.transitions)
+	assert.Equal(t, CLOSED, metrics.states["svc"])
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metrics, err := NewPrometheusMetrics(PrometheusMetricsOption{Registerer: reg})
+	assert.NoError(t, err)
+
+	labels := RequestLabels{Method: "GET", Host: "example.com", Route: "/a", StatusClass: "2xx"}
+	metrics.ObserveRequest(labels, 20*time.Millisecond)
+	metrics.ObserveRequest(labels, 40*time.Millisecond)
+	metrics.ObservePhase("example.com", "dns", time.Millisecond)
+	metrics.InFlight("GET", "example.com", 1)
+	metrics.IncRetry("GET", "example.com")
+	metrics.SetBreakerState("svc", OPEN)
+	metrics.IncBreakerTransition("svc", CLOSED, OPEN)
+	metrics.IncRejected("example.com", "bulkhead")
+	metrics.IncHedge("GET", "example.com")
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.requests.WithLabelValues("GET", "example.com", "/a", "2xx")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.inFlight.WithLabelValues("GET", "example.com")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.retries.WithLabelValues("GET", "example.com")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.breakerState.WithLabelValues("svc")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.transitions.WithLabelValues("svc", CLOSED, OPEN)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.rejected.WithLabelValues("example.com", "bulkhead")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.hedges.WithLabelValues("GET", "example.com")))
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.duration))
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.phases))
+
+	_, err = NewPrometheusMetrics(PrometheusMetricsOption{Registerer: reg})
+	assert.Error(t, err)
+}
+
+func TestExpvarMetrics(t *testing.T) {
+	metrics := NewExpvarMetrics("hcl_test")
+	metrics.requests.Init()
+	metrics.retries.Init()
+	metrics.ObserveRequest(RequestLabels{Method: "GET", Host: "example.com", Route: "/a", StatusClass: "2xx"}, 10*time.Millisecond)
+	metrics.InFlight("GET", "example.com", 1)
+	metrics.IncRetry("GET", "example.com")
+	metrics.SetBreakerState("svc", HALF_OPEN)
+	metrics.IncBreakerTransition("svc", OPEN, HALF_OPEN)
+
+	requests := expvar.Get("hcl_test.requests").(*expvar.Map)
+	assert.Equal(t, "1", requests.Get("GET example.com /a 2xx").String())
+	state := expvar.Get("hcl_test.circuit_breaker_state").(*expvar.Map)
+	assert.Equal(t, `"HALF-OPEN"`, state.Get("svc").String())
+
+	// Publishing the same prefix again must not panic
+	again := NewExpvarMetrics("hcl_test")
+	again.IncRetry("GET", "example.com")
+	assert.Equal(t, "2", expvar.Get("hcl_test.retries").(*expvar.Map).Get("GET example.com").String())
+}
@@ -0,0 +1,440 @@
+package hcl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RoundTripperFunc adapts a function to http.RoundTripper.
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Middleware wraps the next round tripper of the request pipeline.
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// Chain composes middlewares, the first one is the outermost.
+func Chain(middlewares ...Middleware) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			if middlewares[i] != nil {
+				next = middlewares[i](next)
+			}
+		}
+		return next
+	}
+}
+
+// BeforeRequestHook runs before the request is sent, returning an error aborts it.
+type BeforeRequestHook func(req *http.Request) error
+
+// AfterResponseHook runs after a response is received, returning an error
+// discards the response and returns the error to the caller.
+type AfterResponseHook func(resp *Response) error
+
+// ErrorHook is called with the final error of a request. req is nil when the
+// request could not be built.
+type ErrorHook func(req *http.Request, err error)
+
+// exchange holds the state shared by all attempts of one request.
+type exchange struct {
+	mu       sync.Mutex
+	attempts int
+	timings  *timingsRecorder
+	cache    string
+	// rateLimitWait is the time spent waiting on the rate limiter by all attempts.
+	rateLimitWait time.Duration
+	hedges        int
+	// upstreamErrs are the errors of the attempts that reached the upstream.
+	upstreamErrs []error
+}
+
+type exchangeKey struct{}
+
+func withExchange(ctx context.Context) context.Context {
+	return context.WithValue(ctx, exchangeKey{}, &exchange{})
+}
+
+func exchangeFrom(ctx context.Context) *exchange {
+	ex, _ := ctx.Value(exchangeKey{}).(*exchange)
+	return ex
+}
+
+// nextAttempt returns the number of attempts made before this one.
+func (ex *exchange) nextAttempt(rec *timingsRecorder) int {
+	if ex == nil {
+		return 0
+	}
+
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+
+	attempt := ex.attempts
+	ex.attempts++
+	ex.timings = rec
+	return attempt
+}
+
+func (ex *exchange) lastTimings() Timings {
+	if ex == nil {
+		return Timings{}
+	}
+
+	ex.mu.Lock()
+	rec := ex.timings
+	ex.mu.Unlock()
+	return rec.timings()
+}
+
+func (ex *exchange) setCacheStatus(status string) {
+	if ex == nil {
+		return
+	}
+
+	ex.mu.Lock()
+	ex.cache = status
+	ex.mu.Unlock()
+}
+
+func (ex *exchange) cacheStatus() string {
+	if ex == nil {
+		return ""
+	}
+
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	return ex.cache
+}
+
+func (ex *exchange) addRateLimitWait(d time.Duration) {
+	if ex == nil {
+		return
+	}
+
+	ex.mu.Lock()
+	ex.rateLimitWait += d
+	ex.mu.Unlock()
+}
+
+func (ex *exchange) rateLimitWaited() time.Duration {
+	if ex == nil {
+		return 0
+	}
+
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	return ex.rateLimitWait
+}
+
+func (ex *exchange) addHedge() {
+	if ex == nil {
+		return
+	}
+
+	ex.mu.Lock()
+	ex.hedges++
+	ex.mu.Unlock()
+}
+
+func (ex *exchange) hedgeCount() int {
+	if ex == nil {
+		return 0
+	}
+
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	return ex.hedges
+}
+
+// failUpstream records an attempt error caused by the upstream, only those
+// count as circuit breaker failures.
+func (ex *exchange) failUpstream(err error) {
+	if ex == nil {
+		return
+	}
+
+	ex.mu.Lock()
+	ex.upstreamErrs = append(ex.upstreamErrs, err)
+	ex.mu.Unlock()
+}
+
+func (ex *exchange) upstreamFailed(err error) bool {
+	if ex == nil {
+		return false
+	}
+
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	for _, upstreamErr := range ex.upstreamErrs {
+		if errors.Is(err, upstreamErr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Use appends middlewares to the request pipeline, the first one is the outermost.
+func (r *Request) Use(middlewares ...Middleware) *Request {
+	// Check if the request object is nil
+	if r == nil {
+		return nil
+	}
+
+	r.middlewares = append(r.middlewares, middlewares...)
+	return r
+}
+
+func (r *Request) OnBeforeRequest(hooks ...BeforeRequestHook) *Request {
+	// Check if the request object is nil
+	if r == nil {
+		return nil
+	}
+
+	r.beforeHooks = append(r.beforeHooks, hooks...)
+	return r
+}
+
+func (r *Request) OnAfterResponse(hooks ...AfterResponseHook) *Request {
+	// Check if the request object is nil
+	if r == nil {
+		return nil
+	}
+
+	r.afterHooks = append(r.afterHooks, hooks...)
+	return r
+}
+
+func (r *Request) OnError(hooks ...ErrorHook) *Request {
+	// Check if the request object is nil
+	if r == nil {
+		return nil
+	}
+
+	r.errorHooks = append(r.errorHooks, hooks...)
+	return r
+}
+
+// roundTripper builds the request pipeline, from the outermost layer:
+// hooks, logging, HAR recording, cache, coalescing, circuit breaker, hedging,
+// bulkhead, token authentication, load balancing, user middlewares, rate
+// limiting, adaptive limit, attempt instrumentation, signing, fault injection
+// and finally the http.Client.
+func (r *Request) roundTripper() http.RoundTripper {
+	builtins := []Middleware{
+		r.hooksMiddleware,
+		r.logMiddleware,
+		r.harMiddleware,
+		r.cacheMiddleware,
+		r.coalesceMiddleware,
+		r.circuitBreakerMiddleware,
+		r.hedgeMiddleware,
+		r.bulkheadMiddleware,
+		r.auth.middleware,
+		r.balancerMiddleware,
+	}
+	builtins = append(builtins, r.middlewares...)
+	builtins = append(builtins, r.rateLimitMiddleware, r.adaptiveLimitMiddleware, r.attemptMiddleware, r.signMiddleware, r.faultMiddleware)
+
+	return Chain(builtins...)(RoundTripperFunc(r.do))
+}
+
+func (r *Request) do(req *http.Request) (*http.Response, error) {
+	resp, err := r.client.Do(req)
+	// an attempt canceled by the caller, a hedge or a deadline did not fail
+	if err != nil && req.Context().Err() == nil {
+		exchangeFrom(req.Context()).failUpstream(err)
+	}
+	return resp, err
+}
+
+func (r *Request) runErrorHooks(req *http.Request, err error) {
+	for _, hook := range r.errorHooks {
+		hook(req, err)
+	}
+}
+
+func (r *Request) hooksMiddleware(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		for _, hook := range r.beforeHooks {
+			if err := hook(req); err != nil {
+				r.runErrorHooks(req, err)
+				return nil, err
+			}
+		}
+
+		resp, err := next.RoundTrip(req)
+		if err != nil {
+			r.runErrorHooks(req, err)
+			return nil, err
+		}
+
+		for _, hook := range r.afterHooks {
+			if err = hook((*Response)(resp)); err != nil {
+				resp.Body.Close()
+				r.runErrorHooks(req, err)
+				return nil, err
+			}
+		}
+
+		return resp, nil
+	})
+}
+
+func (r *Request) logMiddleware(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		lg := r.log
+		if lg == nil {
+			return next.RoundTrip(req)
+		}
+
+		lg.addMasks(r.auth.masks()...)
+		lg.initiate()
+		lg.setRequest(req)
+		defer r.finishLog()
+
+		resp, err := next.RoundTrip(req)
+		ex := exchangeFrom(req.Context())
+		lg.setCacheStatus(ex.cacheStatus())
+		lg.setRateLimitWait(ex.rateLimitWaited())
+		lg.setHedges(ex.hedgeCount())
+		if err != nil {
+			lg.setTimings(ex.lastTimings())
+			lg.setError(err)
+			return nil, err
+		}
+
+		lg.setResponse(resp)
+		lg.setTimings(ex.lastTimings())
+		return resp, nil
+	})
+}
+
+func (r *Request) cacheMiddleware(next http.RoundTripper) http.RoundTripper {
+	if r.cache == nil {
+		return next
+	}
+	return r.cache.middleware(r.auth.headers(), next)
+}
+
+func (r *Request) coalesceMiddleware(next http.RoundTripper) http.RoundTripper {
+	if r.coalescer == nil {
+		return next
+	}
+	return r.coalescer.middleware(&r.auth, next)
+}
+
+func (r *Request) circuitBreakerMiddleware(next http.RoundTripper) http.RoundTripper {
+	if r.Cb == nil && r.cbRedis == nil {
+		return next
+	}
+
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		// Pre-execution circuit breaker checks
+		var cbErr error
+		if r.Cb != nil && !r.Cb.allow() {
+			cbErr = errRefuse
+		} else if r.cbRedis != nil {
+			cbErr = r.cbRedis.allowRequest(r.cbKey)
+		}
+
+		if cbErr != nil {
+			r.tracing.refused(req.Context(), req.Method, req.URL, r.auth.sensitiveQuery(), cbErr)
+			return nil, cbErr
+		}
+
+		resp, err := next.RoundTrip(req)
+		if err != nil {
+			// a full bulkhead means the upstream is not keeping up
+			if errors.Is(err, ErrBulkheadFull) || isTransportError(req, err) {
+				r.reportCircuitBreaker(false)
+			}
+			return nil, err
+		}
+
+		// Post-execution circuit breaker updates
+		r.updateCircuitBreaker(resp.StatusCode)
+		return resp, nil
+	})
+}
+
+// isTransportError reports whether err was returned by the http.Client. The
+// caller giving up or its deadline passing, and the rate limiter, bulkhead or
+// adaptive limit queues timing out are not the fault of the upstream.
+func isTransportError(req *http.Request, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	return exchangeFrom(req.Context()).upstreamFailed(err)
+}
+
+// attemptMiddleware instruments every attempt that reaches the client with
+// connection timings, a tracing span and metrics.
+func (r *Request) attemptMiddleware(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		req, rec := withTimings(req)
+		attempt := exchangeFrom(req.Context()).nextAttempt(rec)
+
+		req, span := r.tracing.startSpan(req, attempt, r.auth.sensitiveQuery())
+		r.beginAttemptMetrics(req, rec, attempt)
+
+		start := time.Now()
+		resp, err := next.RoundTrip(req)
+		r.tracing.endSpan(span, resp, err)
+		r.endAttemptMetrics(req, resp, rec, time.Since(start))
+		if err != nil {
+			return nil, err
+		}
+
+		rec.wrapBody(resp)
+		return resp, nil
+	})
+}
+
+// replayable reports whether the request body can be sent again.
+func replayable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// replay returns a copy of the request with a fresh body.
+func replay(req *http.Request) (*http.Request, error) {
+	out := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return out, nil
+	}
+
+	if req.GetBody == nil {
+		return nil, errors.New("failed to replay request: the body cannot be read again")
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	out.Body = body
+	return out, nil
+}
+
+// drainBody discards the rest of a response that will not be returned so the
+// connection can be reused.
+func drainBody(resp *http.Response) {
+	if resp == nil || resp.Body == nil {
+		return
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	resp.Body.Close()
+}
+
+// bodyless reports whether the response can have no body, a HEAD response or
+// a 1xx, 204 or 304 may still announce a Content-Length.
+func bodyless(req *http.Request, resp *http.Response) bool {
+	if resp.Body == nil || resp.Body == http.NoBody || resp.ContentLength == 0 || req.Method == http.MethodHead {
+		return true
+	}
+	code := resp.StatusCode
+	return code < 200 || code == http.StatusNoContent || code == http.StatusNotModified
+}
@@ -0,0 +1,186 @@
+package hcl
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func recordingMiddleware(name string, calls *[]string) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			*calls = append(*calls, name+":before")
+			resp, err := next.RoundTrip(req)
+			*calls = append(*calls, name+":after")
+			return resp, err
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	var calls []string
+	terminal := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		calls = append(calls, "terminal")
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})
+
+	rt := Chain(recordingMiddleware("a", &calls), nil, recordingMiddleware("b", &calls))(terminal)
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	_, err := rt.RoundTrip(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a:before", "b:before", "terminal", "b:after", "a:after"}, calls)
+}
+
+func TestRequestMiddlewares(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("X-Signature")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	var calls []string
+	sign := func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req.Header.Set("X-Signature", "signed")
+			return next.RoundTrip(req)
+		})
+	}
+
+	r := New(&HCL{Middlewares: []Middleware{recordingMiddleware("client", &calls)}})
+	resp, err := r.Use(recordingMiddleware("request", &calls), sign).SetUrl(server.URL).Get()
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "signed", received)
+	assert.Equal(t, []string{"client:before", "request:before", "request:after", "client:after"}, calls)
+}
+
+func TestRequestHooks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Trace", r.Header.Get("X-Trace"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	t.Run("before and after hooks", func(t *testing.T) {
+		var seen string
+		r := New(&HCL{
+			OnBeforeRequest: []BeforeRequestHook{func(req *http.Request) error {
+				req.Header.Set("X-Trace", "abc")
+				return nil
+			}},
+		})
+		resp, err := r.OnAfterResponse(func(resp *Response) error {
+			seen = resp.Header.Get("X-Trace")
+			return nil
+		}).SetUrl(server.URL).Get()
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "abc", seen)
+	})
+
+	t.Run("before hook aborts request", func(t *testing.T) {
+		abort := errors.New("aborted")
+		var hookErr error
+		_, err := New(nil).
+			OnBeforeRequest(func(req *http.Request) error { return abort }).
+			OnError(func(req *http.Request, err error) { hookErr = err }).
+			SetUrl(server.URL).
+			Get()
+
+		assert.Equal(t, abort, err)
+		assert.Equal(t, abort, hookErr)
+	})
+
+	t.Run("after hook rejects response", func(t *testing.T) {
+		reject := errors.New("unexpected response")
+		_, err := New(nil).
+			OnAfterResponse(func(resp *Response) error { return reject }).
+			SetUrl(server.URL).
+			Get()
+
+		assert.Equal(t, reject, err)
+	})
+
+	t.Run("error hook on transport error", func(t *testing.T) {
+		var hookReq *http.Request
+		_, err := New(nil).
+			OnError(func(req *http.Request, err error) { hookReq = req }).
+			SetUrl("http://127.0.0.1:1/").
+			Get()
+
+		assert.Error(t, err)
+		assert.NotNil(t, hookReq)
+	})
+
+	t.Run("error hook on build error", func(t *testing.T) {
+		var called bool
+		_, err := New(nil).
+			OnError(func(req *http.Request, err error) {
+				called = true
+				assert.Nil(t, req)
+			}).
+			SetUrl("").
+			Get()
+
+		assert.Error(t, err)
+		assert.True(t, called)
+	})
+}
+
+func TestCircuitBreakerMiddlewareRefusalIsLogged(t *testing.T) {
+	cb := NewCircuitBreaker(CircuitBreakerOption{MaxFailures: 1, HalfOpenLimit: 1, ResetTimeout: time.Minute})
+	r := New(&HCL{Cb: cb})
+	r.Cb.state = OPEN
+	r.Cb.lastFailTime = time.Now()
+
+	var err error
+	output := captureOutput(func() {
+		_, err = r.EnableLog(false).SetUrl("http://127.0.0.1:1/").Get()
+	})
+
+	assert.Equal(t, errRefuse, err)
+	assert.Contains(t, output, `"level":"error"`)
+	assert.Contains(t, output, errRefuse.Error())
+}
+
+func TestAttemptMiddlewareCountsAttempts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	retryOnce := func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				return nil, err
+			}
+			resp.Body.Close()
+			return next.RoundTrip(req)
+		})
+	}
+
+	metrics := newFakeMetrics()
+	_, err := New(&HCL{Metrics: metrics}).Use(retryOnce).SetUrl(server.URL).Get()
+
+	assert.NoError(t, err)
+	assert.Len(t, metrics.requests, 2)
+	assert.Equal(t, 1, metrics.retries)
+}
+
+func TestReplayWithoutGetBody(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "http://svc/orders", strings.NewReader("body"))
+	req.GetBody = nil
+
+	_, err := replay(req)
+	assert.EqualError(t, err, "failed to replay request: the body cannot be read again")
+}
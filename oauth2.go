@@ -0,0 +1,319 @@
+package hcl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type Token struct {
+	AccessToken string    `json:"access_token"`
+	TokenType   string    `json:"token_type,omitempty"`
+	Expiry      time.Time `json:"expiry,omitempty"`
+}
+
+func (t *Token) valid(delta time.Duration) bool {
+	if t == nil || t.AccessToken == "" {
+		return false
+	}
+	return t.Expiry.IsZero() || time.Now().Add(delta).Before(t.Expiry)
+}
+
+// TokenSource provides access tokens for the Authorization header.
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// refreshableTokenSource is implemented by token sources that can replace a
+// token the upstream rejected with a 401.
+type refreshableTokenSource interface {
+	TokenSource
+	Refresh(ctx context.Context, rejected *Token) (*Token, error)
+}
+
+// TokenCache stores tokens outside the process so they can be shared, Get
+// returns nil without error when there is no token.
+type TokenCache interface {
+	Get(ctx context.Context, key string) (*Token, error)
+	Set(ctx context.Context, key string, token *Token) error
+}
+
+type ClientCredentialsOption struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// EndpointParams are extra form values sent to the token endpoint, e.g. audience.
+	EndpointParams url.Values
+	// AuthInBody sends the client credentials as form values instead of basic auth.
+	AuthInBody bool
+	// ExpiryDelta refreshes tokens this long before they expire, defaults to 30 seconds.
+	ExpiryDelta time.Duration
+	// Client is used to call the token endpoint, defaults to http.DefaultClient.
+	Client *http.Client
+	// Cache shares tokens between instances, see NewRedisTokenCache.
+	Cache TokenCache
+}
+
+// ClientCredentials is an OAuth2 client credentials token source. Tokens are
+// cached until shortly before they expire and concurrent refreshes are collapsed
+// into one call to the token endpoint.
+type ClientCredentials struct {
+	options ClientCredentialsOption
+	mu      sync.Mutex
+	token   *Token
+	group   flightGroup
+}
+
+func NewClientCredentials(options ClientCredentialsOption) *ClientCredentials {
+	if options.ExpiryDelta <= 0 {
+		options.ExpiryDelta = 30 * time.Second
+	}
+	if options.Client == nil {
+		options.Client = http.DefaultClient
+	}
+
+	return &ClientCredentials{options: options}
+}
+
+func (c *ClientCredentials) Token(ctx context.Context) (*Token, error) {
+	if token := c.current(); token.valid(c.options.ExpiryDelta) {
+		return token, nil
+	}
+
+	return c.load(ctx, nil)
+}
+
+// Refresh replaces a token rejected by the upstream. Concurrent callers that
+// were rejected with the same token share one refresh.
+func (c *ClientCredentials) Refresh(ctx context.Context, rejected *Token) (*Token, error) {
+	c.mu.Lock()
+	if rejected != nil && c.token != nil && c.token.AccessToken == rejected.AccessToken {
+		c.token = nil
+	}
+	c.mu.Unlock()
+
+	return c.load(ctx, rejected)
+}
+
+func (c *ClientCredentials) current() *Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+func (c *ClientCredentials) load(ctx context.Context, rejected *Token) (*Token, error) {
+	val, err, _ := c.group.do("token", func() (interface{}, error) {
+		if token := c.current(); token.valid(c.options.ExpiryDelta) {
+			return token, nil
+		}
+
+		// the fetch is shared, one caller giving up must not fail the others,
+		// it is bounded by the client timeout instead
+		ctx := context.WithoutCancel(ctx)
+		if timeout := c.options.Client.Timeout; timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		if c.options.Cache != nil {
+			token, err := c.options.Cache.Get(ctx, c.cacheKey())
+			if err == nil && token.valid(c.options.ExpiryDelta) && !sameToken(token, rejected) {
+				c.store(token)
+				return token, nil
+			}
+		}
+
+		token, err := c.fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if c.options.Cache != nil {
+			_ = c.options.Cache.Set(ctx, c.cacheKey(), token)
+		}
+		c.store(token)
+		return token, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return val.(*Token), nil
+}
+
+func (c *ClientCredentials) store(token *Token) {
+	c.mu.Lock()
+	c.token = token
+	c.mu.Unlock()
+}
+
+func sameToken(a, b *Token) bool {
+	return a != nil && b != nil && a.AccessToken == b.AccessToken
+}
+
+func (c *ClientCredentials) cacheKey() string {
+	sum := sha256.Sum256([]byte(c.options.TokenURL + "\n" + c.options.ClientID + "\n" + strings.Join(c.options.Scopes, " ")))
+	return "hcl:oauth2:" + hex.EncodeToString(sum[:8])
+}
+
+func (c *ClientCredentials) fetch(ctx context.Context) (*Token, error) {
+	form := url.Values{}
+	for k, v := range c.options.EndpointParams {
+		form[k] = v
+	}
+	form.Set("grant_type", "client_credentials")
+	if len(c.options.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.options.Scopes, " "))
+	}
+	if c.options.AuthInBody {
+		form.Set("client_id", c.options.ClientID)
+		form.Set("client_secret", c.options.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.options.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(contentType, contentTypeFormData)
+	if !c.options.AuthInBody {
+		req.SetBasicAuth(url.QueryEscape(c.options.ClientID), url.QueryEscape(c.options.ClientSecret))
+	}
+
+	resp, err := c.options.Client.Do(req)
+	if err != nil {
+		return nil, errors.New("failed to fetch oauth2 token: " + err.Error())
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, errors.New("failed to read oauth2 token: " + err.Error())
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("failed to fetch oauth2 token: status %d: %s", resp.StatusCode, body)
+	}
+
+	var payload struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err = json.Unmarshal(body, &payload); err != nil {
+		return nil, errors.New("failed to decode oauth2 token: " + err.Error())
+	}
+	if payload.AccessToken == "" {
+		return nil, errors.New("failed to fetch oauth2 token: empty access_token")
+	}
+
+	token := &Token{AccessToken: payload.AccessToken, TokenType: payload.TokenType}
+	if payload.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(payload.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// RedisTokenCache shares tokens through Redis so a fleet of instances uses one token.
+type RedisTokenCache struct {
+	Client *redis.Client
+	// Prefix is prepended to every key.
+	Prefix string
+}
+
+func NewRedisTokenCache(conf *RedisTokenCache) *RedisTokenCache {
+	return &RedisTokenCache{
+		Client: conf.Client,
+		Prefix: conf.Prefix,
+	}
+}
+
+func (c *RedisTokenCache) Get(ctx context.Context, key string) (*Token, error) {
+	b, err := c.Client.Get(ctx, c.Prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	token := &Token{}
+	if err = json.Unmarshal(b, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (c *RedisTokenCache) Set(ctx context.Context, key string, token *Token) error {
+	b, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	var ttl time.Duration
+	if !token.Expiry.IsZero() {
+		ttl = time.Until(token.Expiry)
+		if ttl <= 0 {
+			return nil
+		}
+	}
+
+	return c.Client.Set(ctx, c.Prefix+key, b, ttl).Err()
+}
+
+// tokenMiddleware sets the bearer token and retries once with a fresh token
+// when the upstream answers 401.
+func tokenMiddleware(source TokenSource) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			token, err := source.Token(req.Context())
+			if err != nil {
+				return nil, err
+			}
+
+			resp, err := next.RoundTrip(withToken(req, token))
+			if err != nil || resp.StatusCode != http.StatusUnauthorized {
+				return resp, err
+			}
+
+			refresher, ok := source.(refreshableTokenSource)
+			if !ok || !replayable(req) {
+				return resp, nil
+			}
+
+			fresh, err := refresher.Refresh(req.Context(), token)
+			if err != nil || sameToken(fresh, token) {
+				return resp, nil
+			}
+			drainBody(resp)
+
+			retry, err := replay(req)
+			if err != nil {
+				return nil, err
+			}
+			return next.RoundTrip(withToken(retry, fresh))
+		})
+	}
+}
+
+func withToken(req *http.Request, token *Token) *http.Request {
+	out := req.Clone(req.Context())
+	tokenType := token.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+	out.Header.Set(headerAuthorization, tokenType+" "+token.AccessToken)
+	return out
+}
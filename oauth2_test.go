@@ -0,0 +1,228 @@
+package hcl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+type tokenServer struct {
+	*httptest.Server
+	fetches   int32
+	expiresIn int
+	delay     time.Duration
+}
+
+func newTokenServer(t *testing.T, expiresIn int) *tokenServer {
+	ts := &tokenServer{expiresIn: expiresIn}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		assert.Equal(t, "client", id)
+		assert.Equal(t, "secret", secret)
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+
+		time.Sleep(ts.delay)
+		n := atomic.AddInt32(&ts.fetches, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":%d}`, n, ts.expiresIn)
+	}))
+	return ts
+}
+
+func (ts *tokenServer) source(cache TokenCache) *ClientCredentials {
+	return NewClientCredentials(ClientCredentialsOption{
+		TokenURL:     ts.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Scopes:       []string{"profile"},
+		Cache:        cache,
+	})
+}
+
+func TestClientCredentialsCachesToken(t *testing.T) {
+	ts := newTokenServer(t, 3600)
+	defer ts.Close()
+
+	source := ts.source(nil)
+	first, err := source.Token(context.Background())
+	assert.NoError(t, err)
+	second, err := source.Token(context.Background())
+	assert.NoError(t, err)
+
+	assert.Equal(t, "token-1", first.AccessToken)
+	assert.Equal(t, first, second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&ts.fetches))
+}
+
+func TestClientCredentialsRefreshesBeforeExpiry(t *testing.T) {
+	// expires_in is within the default 30 seconds expiry delta
+	ts := newTokenServer(t, 10)
+	defer ts.Close()
+
+	source := ts.source(nil)
+	_, _ = source.Token(context.Background())
+	token, err := source.Token(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, "token-2", token.AccessToken)
+}
+
+func TestClientCredentialsSingleflight(t *testing.T) {
+	ts := newTokenServer(t, 3600)
+	ts.delay = 50 * time.Millisecond
+	defer ts.Close()
+
+	source := ts.source(nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := source.Token(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, "token-1", token.AccessToken)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&ts.fetches))
+}
+
+func TestClientCredentialsSharedFetchOutlivesCaller(t *testing.T) {
+	ts := newTokenServer(t, 3600)
+	ts.delay = 50 * time.Millisecond
+	defer ts.Close()
+
+	source := ts.source(nil)
+
+	// the caller that starts the fetch gives up before it completes
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = source.Token(ctx)
+	}()
+	time.Sleep(5 * time.Millisecond)
+
+	token, err := source.Token(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "token-1", token.AccessToken)
+	<-done
+	assert.Equal(t, int32(1), atomic.LoadInt32(&ts.fetches))
+}
+
+func TestClientCredentialsTokenEndpointError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, `{"error":"invalid_client"}`)
+	}))
+	defer server.Close()
+
+	source := NewClientCredentials(ClientCredentialsOption{TokenURL: server.URL, ClientID: "client"})
+	_, err := source.Token(context.Background())
+	assert.ErrorContains(t, err, "invalid_client")
+}
+
+func TestTokenSourceRetriesOnceOn401(t *testing.T) {
+	ts := newTokenServer(t, 3600)
+	defer ts.Close()
+
+	var (
+		calls  int32
+		bodies []string
+		mu     sync.Mutex
+	)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(b))
+		mu.Unlock()
+
+		// the first token is revoked upstream
+		if r.Header.Get("Authorization") != "Bearer token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer api.Close()
+
+	resp, err := New(&HCL{TokenSource: ts.source(nil)}).
+		SetUrl(api.URL).
+		SetJsonPayload(map[string]string{"msisdn": "0812"}).
+		Post()
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	assert.Equal(t, []string{`{"msisdn":"0812"}`, `{"msisdn":"0812"}`}, bodies)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&ts.fetches))
+}
+
+func TestTokenSourceDoesNotRetryTwice(t *testing.T) {
+	ts := newTokenServer(t, 3600)
+	defer ts.Close()
+
+	var calls int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer api.Close()
+
+	resp, err := New(&HCL{TokenSource: ts.source(nil)}).SetUrl(api.URL).Get()
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestRedisTokenCache(t *testing.T) {
+	ts := newTokenServer(t, 3600)
+	defer ts.Close()
+
+	db, mock := redismock.NewClientMock()
+	cache := NewRedisTokenCache(&RedisTokenCache{Client: db, Prefix: "svc:"})
+	source := ts.source(cache)
+	key := "svc:" + source.cacheKey()
+
+	t.Run("uses the shared token", func(t *testing.T) {
+		shared, _ := json.Marshal(&Token{AccessToken: "shared", Expiry: time.Now().Add(time.Hour)})
+		mock.ExpectGet(key).SetVal(string(shared))
+
+		token, err := source.Token(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "shared", token.AccessToken)
+		assert.Equal(t, int32(0), atomic.LoadInt32(&ts.fetches))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("stores a fetched token", func(t *testing.T) {
+		mock.ExpectGet(key).SetVal(`{"access_token":"shared"}`)
+		mock.CustomMatch(func(expected, actual []interface{}) error {
+			if actual[0] != "set" || actual[1] != key {
+				return fmt.Errorf("unexpected command %v", actual)
+			}
+			return nil
+		}).ExpectSet(key, nil, time.Hour).SetVal("OK")
+
+		token, err := source.Refresh(context.Background(), &Token{AccessToken: "shared"})
+		assert.NoError(t, err)
+		assert.Equal(t, "token-1", token.AccessToken)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,249 @@
+package hcl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrRateLimited is matched by the RateLimitError returned in fail-fast mode.
+var ErrRateLimited = errors.New("request refused. the rate limit is exceeded")
+
+// RateLimitError is returned when a request is refused by the rate limiter.
+type RateLimitError struct {
+	Key        string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s for %s, retry after %s", ErrRateLimited.Error(), e.Key, e.RetryAfter)
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// RateLimiter admits requests per key.
+type RateLimiter interface {
+	// Allow takes a token for key when one is available, otherwise it takes
+	// nothing and returns how long to wait before trying again.
+	Allow(ctx context.Context, key string) (time.Duration, error)
+}
+
+type RateLimitMode int
+
+const (
+	// RateLimitWait waits for a token until the request context is done.
+	RateLimitWait RateLimitMode = iota
+	// RateLimitFailFast refuses the request with a RateLimitError.
+	RateLimitFailFast
+)
+
+type TokenBucketOption struct {
+	// Rate requests are admitted per Per, Per defaults to one second.
+	Rate int
+	Per  time.Duration
+	// Burst is the bucket size, defaults to Rate.
+	Burst int
+}
+
+// TokenBucket is an in-memory rate limiter with one bucket per key.
+type TokenBucket struct {
+	perSecond float64
+	burst     float64
+	now       func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(options TokenBucketOption) *TokenBucket {
+	if options.Per <= 0 {
+		options.Per = time.Second
+	}
+	if options.Burst <= 0 {
+		options.Burst = options.Rate
+	}
+
+	return &TokenBucket{
+		perSecond: float64(options.Rate) / options.Per.Seconds(),
+		burst:     float64(options.Burst),
+		now:       time.Now,
+		buckets:   make(map[string]*bucket),
+	}
+}
+
+func (tb *TokenBucket) Allow(_ context.Context, key string) (time.Duration, error) {
+	if tb.perSecond <= 0 {
+		return 0, errors.New("rate limit must be greater than zero")
+	}
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := tb.now()
+	b, ok := tb.buckets[key]
+	if !ok {
+		b = &bucket{tokens: tb.burst, last: now}
+		tb.buckets[key] = b
+	}
+
+	b.tokens = math.Min(tb.burst, b.tokens+now.Sub(b.last).Seconds()*tb.perSecond)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, nil
+	}
+	return time.Duration((1 - b.tokens) / tb.perSecond * float64(time.Second)), nil
+}
+
+// gcraScript implements GCRA with the Redis clock so every instance shares
+// one time source. It stores the theoretical arrival time in microseconds
+// and returns the microseconds to wait, 0 when the request is admitted.
+var gcraScript = redis.NewScript(`
+local emission = tonumber(ARGV[1])
+local tolerance = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then
+	tat = now
+end
+
+local allow_at = tat + emission - tolerance
+if now < allow_at then
+	return math.ceil(allow_at - now)
+end
+
+local new_tat = tat + emission
+redis.call('SET', KEYS[1], string.format('%.0f', new_tat), 'PX', math.ceil((new_tat - now) / 1000))
+return 0
+`)
+
+// RateLimiterRedis is a GCRA rate limiter shared by every instance using the
+// same Redis, e.g. for a quota of 100 rps across all pods.
+type RateLimiterRedis struct {
+	Client *redis.Client
+	// Rate requests are admitted per Per, Per defaults to one second.
+	Rate int
+	Per  time.Duration
+	// Burst is the number of requests admitted at once, defaults to 1.
+	Burst int
+	// Prefix is prepended to every key.
+	Prefix string
+}
+
+func NewRateLimiterRedis(conf *RateLimiterRedis) *RateLimiterRedis {
+	limiter := &RateLimiterRedis{
+		Client: conf.Client,
+		Rate:   conf.Rate,
+		Per:    conf.Per,
+		Burst:  conf.Burst,
+		Prefix: conf.Prefix,
+	}
+	if limiter.Per <= 0 {
+		limiter.Per = time.Second
+	}
+	if limiter.Burst <= 0 {
+		limiter.Burst = 1
+	}
+	return limiter
+}
+
+func (l *RateLimiterRedis) Allow(ctx context.Context, key string) (time.Duration, error) {
+	if l.Rate <= 0 {
+		return 0, errors.New("rate limit must be greater than zero")
+	}
+
+	// the script counts in microseconds, a higher rate would truncate the
+	// emission interval to 0 and admit everything
+	emission := max(l.Per.Microseconds()/int64(l.Rate), 1)
+	tolerance := emission * int64(l.Burst)
+
+	wait, err := gcraScript.Run(ctx, l.Client, []string{l.Prefix + key}, emission, tolerance).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(wait) * time.Microsecond, nil
+}
+
+func (r *Request) SetRateLimitKey(key string) *Request {
+	// Check if the request object is nil
+	if r == nil {
+		return nil
+	}
+
+	if key == "" {
+		r.errs = append(r.errs, errors.New("rate limit key cannot be empty"))
+		return r
+	}
+
+	r.rateLimitKey = key
+	return r
+}
+
+// rateLimitMiddleware admits every attempt through the rate limiter, the key
+// defaults to the URL host.
+func (r *Request) rateLimitMiddleware(next http.RoundTripper) http.RoundTripper {
+	if r.rateLimiter == nil {
+		return next
+	}
+
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		key := r.rateLimitKey
+		if key == "" {
+			key = req.URL.Host
+		}
+
+		start := time.Now()
+		err := r.waitRateLimit(req.Context(), key)
+		exchangeFrom(req.Context()).addRateLimitWait(time.Since(start))
+		if err != nil {
+			return nil, err
+		}
+
+		return next.RoundTrip(req)
+	})
+}
+
+func (r *Request) waitRateLimit(ctx context.Context, key string) error {
+	for {
+		wait, err := r.rateLimiter.Allow(ctx, key)
+		if err != nil {
+			return errors.New("failed to check rate limit: " + err.Error())
+		}
+		if wait <= 0 {
+			return nil
+		}
+
+		refused := &RateLimitError{Key: key, RetryAfter: wait}
+		if r.rateLimitMode == RateLimitFailFast {
+			return refused
+		}
+		// give up early when the token would arrive after the deadline
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return refused
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package hcl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newCountingServer(calls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func TestTokenBucket(t *testing.T) {
+	clock := newFakeClock()
+	tb := NewTokenBucket(TokenBucketOption{Rate: 10, Burst: 2})
+	tb.now = clock.Now
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		wait, err := tb.Allow(ctx, "a")
+		assert.NoError(t, err)
+		assert.Zero(t, wait)
+	}
+
+	wait, _ := tb.Allow(ctx, "a")
+	assert.Equal(t, 100*time.Millisecond, wait)
+
+	// keys have their own bucket
+	wait, _ = tb.Allow(ctx, "b")
+	assert.Zero(t, wait)
+
+	clock.Advance(50 * time.Millisecond)
+	wait, _ = tb.Allow(ctx, "a")
+	assert.Equal(t, 50*time.Millisecond, wait)
+
+	clock.Advance(50 * time.Millisecond)
+	wait, _ = tb.Allow(ctx, "a")
+	assert.Zero(t, wait)
+}
+
+func TestRateLimitWait(t *testing.T) {
+	var calls int32
+	server := newCountingServer(&calls)
+	defer server.Close()
+
+	hcl := &HCL{RateLimiter: NewTokenBucket(TokenBucketOption{Rate: 20, Burst: 1})}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err := New(hcl).SetUrl(server.URL).Get()
+		assert.NoError(t, err)
+	}
+
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestRateLimitFailFast(t *testing.T) {
+	var calls int32
+	server := newCountingServer(&calls)
+	defer server.Close()
+
+	hcl := &HCL{
+		RateLimiter:   NewTokenBucket(TokenBucketOption{Rate: 1, Per: time.Minute}),
+		RateLimitMode: RateLimitFailFast,
+	}
+
+	_, err := New(hcl).SetUrl(server.URL).SetRateLimitKey("partner").Get()
+	assert.NoError(t, err)
+
+	_, err = New(hcl).SetUrl(server.URL).SetRateLimitKey("partner").Get()
+	assert.ErrorIs(t, err, ErrRateLimited)
+
+	var rateLimitErr *RateLimitError
+	assert.True(t, errors.As(err, &rateLimitErr))
+	assert.Equal(t, "partner", rateLimitErr.Key)
+	assert.Greater(t, rateLimitErr.RetryAfter, 59*time.Second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestRateLimitRespectsContext(t *testing.T) {
+	var calls int32
+	server := newCountingServer(&calls)
+	defer server.Close()
+
+	limiter := NewTokenBucket(TokenBucketOption{Rate: 1, Per: time.Minute})
+	_, _ = New(&HCL{RateLimiter: limiter}).SetUrl(server.URL).Get()
+
+	// the token arrives after the deadline so the request is refused right away
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	_, err := New(&HCL{Context: ctx, RateLimiter: limiter}).SetUrl(server.URL).Get()
+	assert.ErrorIs(t, err, ErrRateLimited)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	// a cancelled wait returns the context error
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err = New(&HCL{Context: ctx, RateLimiter: limiter}).SetUrl(server.URL).Get()
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestRateLimitWaitInLog(t *testing.T) {
+	var calls int32
+	server := newCountingServer(&calls)
+	defer server.Close()
+
+	hcl := &HCL{RateLimiter: NewTokenBucket(TokenBucketOption{Rate: 20, Burst: 1})}
+	_, _ = New(hcl).SetUrl(server.URL).Get()
+
+	output := captureOutput(func() {
+		_, _ = New(hcl).EnableLog(false).SetUrl(server.URL).Get()
+	})
+	assert.Contains(t, output, `"rateLimitWaitMs":`)
+}
+
+func TestRateLimiterRedis(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	limiter := NewRateLimiterRedis(&RateLimiterRedis{Client: db, Rate: 100, Burst: 10, Prefix: "rl:"})
+	ctx := context.Background()
+
+	// 100 rps is one request per 10ms, a burst of 10 tolerates 100ms
+	mock.ExpectEvalSha(gcraScript.Hash(), []string{"rl:partner"}, int64(10000), int64(100000)).SetVal(int64(0))
+	mock.ExpectEvalSha(gcraScript.Hash(), []string{"rl:partner"}, int64(10000), int64(100000)).SetVal(int64(2500))
+
+	wait, err := limiter.Allow(ctx, "partner")
+	assert.NoError(t, err)
+	assert.Zero(t, wait)
+
+	wait, err = limiter.Allow(ctx, "partner")
+	assert.NoError(t, err)
+	assert.Equal(t, 2500*time.Microsecond, wait)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRateLimiterRedisHighRate(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	limiter := NewRateLimiterRedis(&RateLimiterRedis{Client: db, Rate: 10_000_000})
+
+	// the emission interval is clamped to the microsecond resolution of the script
+	mock.ExpectEvalSha(gcraScript.Hash(), []string{"rl"}, int64(1), int64(1)).SetVal(int64(1))
+	wait, err := limiter.Allow(context.Background(), "rl")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Microsecond, wait)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRateLimiterRedisError(t *testing.T) {
+	var calls int32
+	server := newCountingServer(&calls)
+	defer server.Close()
+
+	db, mock := redismock.NewClientMock()
+	limiter := NewRateLimiterRedis(&RateLimiterRedis{Client: db, Rate: 100})
+	mock.ExpectEvalSha(gcraScript.Hash(), []string{server.URL[len("http://"):]}, int64(10000), int64(10000)).
+		SetErr(errors.New("connection refused"))
+
+	_, err := New(&HCL{RateLimiter: limiter}).SetUrl(server.URL).Get()
+	assert.EqualError(t, err, "failed to check rate limit: connection refused")
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls))
+}
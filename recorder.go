@@ -0,0 +1,343 @@
+package hcl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+type RecorderMode int
+
+const (
+	// RecorderAuto replays the recorded interactions and records the missing ones.
+	RecorderAuto RecorderMode = iota
+	// RecorderRecord sends every request and replaces the cassette on Save.
+	RecorderRecord
+	// RecorderReplay only replays, a request without interaction fails.
+	RecorderReplay
+)
+
+// MatchOn selects the parts of a request that identify an interaction.
+type MatchOn int
+
+const (
+	MatchMethod MatchOn = 1 << iota
+	MatchURL
+	MatchBody
+	// MatchHeaders compares the headers listed in RecorderOption.Headers.
+	MatchHeaders
+)
+
+type RecorderOption struct {
+	// Path of the cassette, YAML for .yaml and .yml, JSON otherwise.
+	Path string
+	Mode RecorderMode
+	// Match defaults to MatchMethod | MatchURL.
+	Match   MatchOn
+	Headers []string
+	// Masks scrub headers, query parameters and bodies before they are
+	// written, requests are matched on their masked form.
+	Masks []*MaskConfig
+	// Transport sends the requests that are recorded, defaults to the
+	// transport of the shared client.
+	Transport http.RoundTripper
+}
+
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions" yaml:"interactions"`
+}
+
+type Interaction struct {
+	Request  RecordedRequest  `json:"request" yaml:"request"`
+	Response RecordedResponse `json:"response" yaml:"response"`
+}
+
+// BodyBase64 is the Encoding of recorded bodies that are not valid UTF-8.
+const BodyBase64 = "base64"
+
+type RecordedRequest struct {
+	Method   string      `json:"method" yaml:"method"`
+	URL      string      `json:"url" yaml:"url"`
+	Header   http.Header `json:"header,omitempty" yaml:"header,omitempty"`
+	Body     string      `json:"body,omitempty" yaml:"body,omitempty"`
+	Encoding string      `json:"encoding,omitempty" yaml:"encoding,omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode int         `json:"statusCode" yaml:"statusCode"`
+	Header     http.Header `json:"header,omitempty" yaml:"header,omitempty"`
+	Body       string      `json:"body,omitempty" yaml:"body,omitempty"`
+	// Encoding is BodyBase64 for binary bodies, empty for text.
+	Encoding string `json:"encoding,omitempty" yaml:"encoding,omitempty"`
+}
+
+// Recorder is a http.RoundTripper that records interactions to a cassette
+// and replays them, for tests against real upstreams. Plug it in with
+// HCL.Client and call Save once the test is done.
+type Recorder struct {
+	options RecorderOption
+
+	mu       sync.Mutex
+	cassette Cassette
+	used     map[*Interaction]bool
+	changed  bool
+}
+
+func NewRecorder(options RecorderOption) (*Recorder, error) {
+	if options.Path == "" {
+		return nil, errors.New("cassette path cannot be empty")
+	}
+	if options.Match == 0 {
+		options.Match = MatchMethod | MatchURL
+	}
+	if options.Transport == nil {
+		options.Transport = defaultHTTPClient().Transport
+	}
+
+	rec := &Recorder{options: options, used: make(map[*Interaction]bool)}
+	if options.Mode == RecorderRecord {
+		return rec, nil
+	}
+
+	b, err := os.ReadFile(options.Path)
+	if errors.Is(err, os.ErrNotExist) && options.Mode == RecorderAuto {
+		return rec, nil
+	}
+	if err != nil {
+		return nil, errors.New("failed to read cassette: " + err.Error())
+	}
+	if err = rec.unmarshal(b); err != nil {
+		return nil, errors.New("failed to parse cassette: " + err.Error())
+	}
+	return rec, nil
+}
+
+func (rec *Recorder) isYAML() bool {
+	ext := strings.ToLower(filepath.Ext(rec.options.Path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+func (rec *Recorder) unmarshal(b []byte) error {
+	if rec.isYAML() {
+		return yaml.Unmarshal(b, &rec.cassette)
+	}
+	return json.Unmarshal(b, &rec.cassette)
+}
+
+func (rec *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = b
+		req.Body = io.NopCloser(bytes.NewReader(b))
+	}
+	recorded := rec.recordRequest(req, body)
+
+	if rec.options.Mode != RecorderRecord {
+		if i := rec.find(recorded, rec.options.Mode == RecorderReplay); i != nil {
+			return i.Response.response(req), nil
+		}
+		if rec.options.Mode == RecorderReplay {
+			return nil, errors.New("no recorded interaction for " + recorded.Method + " " + recorded.URL)
+		}
+	}
+
+	resp, err := rec.options.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	rec.mu.Lock()
+	i := &Interaction{Request: recorded, Response: rec.recordResponse(resp, respBody)}
+	rec.cassette.Interactions = append(rec.cassette.Interactions, i)
+	rec.used[i] = true
+	rec.changed = true
+	rec.mu.Unlock()
+
+	return resp, nil
+}
+
+// find returns the first unused interaction that matches, or with reuse the
+// last matching one when all of them were replayed.
+func (rec *Recorder) find(req RecordedRequest, reuse bool) *Interaction {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	var last *Interaction
+	for _, i := range rec.cassette.Interactions {
+		if !rec.matches(req, i.Request) {
+			continue
+		}
+		if !rec.used[i] {
+			rec.used[i] = true
+			return i
+		}
+		if reuse {
+			last = i
+		}
+	}
+	return last
+}
+
+func (rec *Recorder) matches(a, b RecordedRequest) bool {
+	match := rec.options.Match
+	if match&MatchMethod != 0 && a.Method != b.Method {
+		return false
+	}
+	if match&MatchURL != 0 && a.URL != b.URL {
+		return false
+	}
+	if match&MatchBody != 0 && (a.Body != b.Body || a.Encoding != b.Encoding) {
+		return false
+	}
+	if match&MatchHeaders != 0 {
+		for _, name := range rec.options.Headers {
+			if strings.Join(a.Header.Values(name), ",") != strings.Join(b.Header.Values(name), ",") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (rec *Recorder) recordRequest(req *http.Request, body []byte) RecordedRequest {
+	u := *req.URL
+	u.RawQuery = maskQuery(u.Query(), rec.options.Masks).Encode()
+
+	recorded := RecordedRequest{
+		Method: req.Method,
+		URL:    u.String(),
+		Header: maskHeader(req.Header, rec.options.Masks),
+	}
+	recorded.Body, recorded.Encoding = rec.recordBody(recorded.Header, body)
+	return recorded
+}
+
+func (rec *Recorder) recordResponse(resp *http.Response, body []byte) RecordedResponse {
+	recorded := RecordedResponse{
+		StatusCode: resp.StatusCode,
+		Header:     maskHeader(resp.Header, rec.options.Masks),
+	}
+	recorded.Body, recorded.Encoding = rec.recordBody(recorded.Header, body)
+	return recorded
+}
+
+// recordBody decodes gzip and deflate bodies so they can be masked, and drops
+// their Content-Encoding from header. A body that still cannot be parsed in a
+// masked content type is replaced, the other binary bodies are kept as base64
+// since YAML and JSON strings cannot hold them.
+func (rec *Recorder) recordBody(header http.Header, body []byte) (string, string) {
+	if decoded, err := decodeContent(header.Get("Content-Encoding"), body); err == nil {
+		body = decoded
+		header.Del("Content-Encoding")
+	}
+
+	masked := maskPayload(header.Get(contentType), string(body), rec.options.Masks)
+	if !utf8.ValidString(masked) {
+		return base64.StdEncoding.EncodeToString([]byte(masked)), BodyBase64
+	}
+	return masked, ""
+}
+
+// decodeContent decodes a gzip or deflate body.
+func decodeContent(encoding string, body []byte) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		r, err = zlib.NewReader(bytes.NewReader(body))
+	default:
+		return nil, errors.New("unsupported content encoding: " + encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func (r RecordedResponse) response(req *http.Request) *http.Response {
+	header := r.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+
+	body := []byte(r.Body)
+	if r.Encoding == BodyBase64 {
+		body, _ = base64.StdEncoding.DecodeString(r.Body)
+	}
+	// masking changes the length of the recorded body
+	if header.Get("Content-Length") != "" {
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+
+	return &http.Response{
+		Status:        strconv.Itoa(r.StatusCode) + " " + http.StatusText(r.StatusCode),
+		StatusCode:    r.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// Save writes the cassette when new interactions were recorded.
+func (rec *Recorder) Save() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if !rec.changed {
+		return nil
+	}
+
+	var (
+		b   []byte
+		err error
+	)
+	if rec.isYAML() {
+		b, err = yaml.Marshal(&rec.cassette)
+	} else {
+		b, err = json.MarshalIndent(&rec.cassette, "", "  ")
+	}
+	if err != nil {
+		return errors.New("failed to encode cassette: " + err.Error())
+	}
+
+	if err = os.MkdirAll(filepath.Dir(rec.options.Path), 0o755); err != nil {
+		return errors.New("failed to write cassette: " + err.Error())
+	}
+	if err = os.WriteFile(rec.options.Path, b, 0o644); err != nil {
+		return errors.New("failed to write cassette: " + err.Error())
+	}
+
+	rec.changed = false
+	return nil
+}
@@ -0,0 +1,169 @@
+package hcl
+
+import (
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorderRecordsAndReplays(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		if n == 1 {
+			w.Write([]byte(`{"id":"1","token":"secret-token"}`))
+			return
+		}
+		w.Write([]byte(`{"id":"2","token":"secret-token"}`))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "cassettes", "profile.yaml")
+	masks := []*MaskConfig{
+		{Field: "token", MaskType: FullMask},
+		{Field: "Authorization", MaskType: FullMask},
+		{Field: "apiKey", MaskType: FullMask},
+	}
+
+	rec, err := NewRecorder(RecorderOption{Path: path, Masks: masks})
+	assert.NoError(t, err)
+	hcl := &HCL{Client: &http.Client{Transport: rec}}
+
+	for i := 0; i < 2; i++ {
+		resp, err := New(hcl).SetUrl(server.URL+"/profile").SetQueryParam("apiKey", "k-123").
+			SetHeader("Authorization", "Bearer abc").Get()
+		assert.NoError(t, err)
+		body, _ := resp.ByteResult()
+		assert.Contains(t, string(body), "secret-token")
+	}
+	assert.NoError(t, rec.Save())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), "secret-token")
+	assert.NotContains(t, string(b), "Bearer abc")
+	assert.NotContains(t, string(b), "k-123")
+
+	// the interactions are replayed in order without the server
+	server.Close()
+	rec, err = NewRecorder(RecorderOption{Path: path, Mode: RecorderReplay, Masks: masks})
+	assert.NoError(t, err)
+	hcl = &HCL{Client: &http.Client{Transport: rec}}
+
+	for _, id := range []string{"1", "2", "2"} {
+		resp, err := New(hcl).SetUrl(server.URL+"/profile").SetQueryParam("apiKey", "k-123").Get()
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		body, _ := resp.ByteResult()
+		assert.Contains(t, string(body), `"id":"`+id+`"`)
+	}
+
+	_, err = New(hcl).SetUrl(server.URL + "/other").Get()
+	assert.ErrorContains(t, err, "no recorded interaction for GET "+server.URL+"/other")
+}
+
+func TestRecorderMatchesBodyAndHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Tenant")))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	options := RecorderOption{Path: path, Match: MatchMethod | MatchURL | MatchBody | MatchHeaders, Headers: []string{"X-Tenant"}}
+	rec, err := NewRecorder(options)
+	assert.NoError(t, err)
+	hcl := &HCL{Client: &http.Client{Transport: rec}}
+
+	for _, tenant := range []string{"a", "b"} {
+		_, err = New(hcl).SetUrl(server.URL).SetHeader("X-Tenant", tenant).SetJsonPayload(map[string]string{"id": "1"}).Post()
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, rec.Save())
+	server.Close()
+
+	options.Mode = RecorderReplay
+	rec, err = NewRecorder(options)
+	assert.NoError(t, err)
+	hcl = &HCL{Client: &http.Client{Transport: rec}}
+
+	resp, err := New(hcl).SetUrl(server.URL).SetHeader("X-Tenant", "b").SetJsonPayload(map[string]string{"id": "1"}).Post()
+	assert.NoError(t, err)
+	body, _ := resp.ByteResult()
+	assert.Equal(t, "b", string(body))
+
+	_, err = New(hcl).SetUrl(server.URL).SetHeader("X-Tenant", "b").SetJsonPayload(map[string]string{"id": "2"}).Post()
+	assert.Error(t, err)
+
+	_, err = NewRecorder(RecorderOption{Path: filepath.Join(t.TempDir(), "missing.json"), Mode: RecorderReplay})
+	assert.ErrorContains(t, err, "failed to read cassette")
+}
+
+func TestRecorderBinaryBodies(t *testing.T) {
+	image := []byte{0x89, 'P', 'N', 'G', 0xff, 0x00, 0xfe}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/image" {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(image)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/gzip":
+			w.Header().Set("Content-Encoding", "gzip")
+			zw := gzip.NewWriter(w)
+			zw.Write([]byte(`{"token":"a-long-secret-token"}`))
+			zw.Close()
+		case "/brotli":
+			w.Header().Set("Content-Encoding", "br")
+			w.Write([]byte{0x1b, 0xff, 0x00, 's', 'e', 'c', 'r', 'e', 't'})
+		default:
+			w.Write([]byte(`{"token":"a-long-secret-token"}`))
+		}
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "binary.json")
+	rec, _ := NewRecorder(RecorderOption{Path: path, Masks: []*MaskConfig{{Field: "token", MaskType: FullMask}}})
+	hcl := &HCL{Client: &http.Client{Transport: rec}}
+	New(hcl).SetUrl(server.URL + "/image").Get()
+	New(hcl).SetUrl(server.URL + "/token").Get()
+	// an explicit Accept-Encoding keeps the transport from decoding the body
+	New(hcl).SetUrl(server.URL+"/gzip").SetHeader("Accept-Encoding", "gzip").Get()
+	New(hcl).SetUrl(server.URL+"/brotli").SetHeader("Accept-Encoding", "br").Get()
+	assert.NoError(t, rec.Save())
+	server.Close()
+
+	// encoded bodies are decoded and masked, or replaced when they cannot be
+	cassette, _ := os.ReadFile(path)
+	assert.NotContains(t, string(cassette), "secret")
+	assert.Equal(t, `{"token":"*******************"}`, rec.cassette.Interactions[2].Response.Body)
+	assert.Empty(t, rec.cassette.Interactions[2].Response.Header.Get("Content-Encoding"))
+	assert.Equal(t, maskedUnparseableBody, rec.cassette.Interactions[3].Response.Body)
+
+	rec, _ = NewRecorder(RecorderOption{Path: path, Mode: RecorderReplay})
+	hcl = &HCL{Client: &http.Client{Transport: rec}}
+
+	resp, err := New(hcl).SetUrl(server.URL + "/image").Get()
+	assert.NoError(t, err)
+	body, _ := resp.ByteResult()
+	assert.Equal(t, image, body)
+	assert.Equal(t, BodyBase64, rec.cassette.Interactions[0].Response.Encoding)
+	assert.Equal(t, "200 OK", resp.Status)
+
+	// the masked body is shorter than the recorded Content-Length
+	resp, err = New(hcl).SetUrl(server.URL + "/token").Get()
+	assert.NoError(t, err)
+	body, _ = resp.ByteResult()
+	assert.NotContains(t, string(body), "a-long-secret-token")
+	assert.Equal(t, strconv.Itoa(len(body)), resp.Header.Get("Content-Length"))
+	assert.Equal(t, int64(len(body)), resp.ContentLength)
+}
@@ -13,6 +13,9 @@ import (
 	"net/url"
 	"os"
 	"strconv"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type RequestMethod string
@@ -51,6 +54,27 @@ type Request struct {
 	isRepeatableLog bool
 	closeRequest    bool
 	errHttpCodes    []int
+	tracing         *tracing
+	metrics         Metrics
+	route           string
+	middlewares     []Middleware
+	beforeHooks     []BeforeRequestHook
+	afterHooks      []AfterResponseHook
+	errorHooks      []ErrorHook
+	auth            credentials
+	signer          Signer
+	cache           *Cache
+	coalescer       *Coalescer
+	rateLimiter     RateLimiter
+	rateLimitMode   RateLimitMode
+	rateLimitKey    string
+	bulkhead        *Bulkhead
+	bulkheadKey     string
+	adaptiveLimiter *AdaptiveLimiter
+	hedger          *Hedger
+	balancer        *Balancer
+	faults          *FaultInjector
+	har             *HARRecorder
 }
 
 type HCL struct {
@@ -58,6 +82,61 @@ type HCL struct {
 	Client  *http.Client
 	Cb      *CircuitBreaker
 	CbRedis *CircuitBreakerRedis
+	// Bulkhead caps the in-flight requests per host, or per key set with
+	// SetBulkheadKey, see NewBulkhead.
+	Bulkhead *Bulkhead
+	// AdaptiveLimiter adjusts the concurrency per host from latency and
+	// errors instead of a fixed cap, see NewAdaptiveLimiter.
+	AdaptiveLimiter *AdaptiveLimiter
+	// Hedger re-sends slow idempotent requests, the first response wins,
+	// see NewHedger.
+	Hedger *Hedger
+	// Balancer sends every request to one of several base URLs, see NewBalancer.
+	Balancer *Balancer
+	// Faults adds latency and errors to the requests of this client when it
+	// is enabled, see NewFaultInjector.
+	Faults *FaultInjector
+	// HAR records every exchange with its timings, see NewHARRecorder.
+	HAR *HARRecorder
+	// TracerProvider enables an OpenTelemetry client span per attempt when set.
+	TracerProvider trace.TracerProvider
+	// Propagator injects the trace context into outgoing headers.
+	// Defaults to W3C trace-context (traceparent/tracestate).
+	Propagator propagation.TextMapPropagator
+	// Metrics receives request and circuit breaker measurements, see
+	// NewPrometheusMetrics and NewExpvarMetrics.
+	Metrics Metrics
+	// Middlewares wrap every request sent by this client, the first one is the outermost.
+	Middlewares     []Middleware
+	OnBeforeRequest []BeforeRequestHook
+	OnAfterResponse []AfterResponseHook
+	OnError         []ErrorHook
+	// BasicAuth, BearerToken and APIKey are the default credentials of every
+	// request, they can be overridden per request and are redacted from the log.
+	BasicAuth   *BasicAuth
+	BearerToken string
+	APIKey      *APIKey
+	// TokenSource sets a bearer token on every request and retries once with a
+	// fresh token on 401, see NewClientCredentials.
+	TokenSource TokenSource
+	// DigestAuth answers Digest challenges, see NewDigestAuth.
+	DigestAuth *DigestAuth
+	// TLS verifies servers and presents client certificates with material that
+	// is reloaded from disk, see NewTLSManager. It is applied to a copy of Client.
+	TLS *TLSManager
+	// Cache serves GET responses following RFC 9111, see NewCache.
+	Cache *Cache
+	// Coalescer collapses identical concurrent requests into one upstream
+	// call, see NewCoalescer.
+	Coalescer *Coalescer
+	// RateLimiter admits every attempt per key, the URL host unless set with
+	// SetRateLimitKey, see NewTokenBucket and NewRateLimiterRedis.
+	RateLimiter RateLimiter
+	// RateLimitMode is RateLimitWait by default.
+	RateLimitMode RateLimitMode
+	// Signer signs every attempt right before it is sent, see NewHMACSigner
+	// and NewAWSV4Signer.
+	Signer Signer
 }
 
 func New(hcl *HCL) *Request {
@@ -66,26 +145,66 @@ func New(hcl *HCL) *Request {
 		client  *http.Client
 		cb      *CircuitBreaker
 		cbRedis *CircuitBreakerRedis
+		tr      *tracing
+		metrics Metrics
 	)
 
 	if hcl != nil {
 		ctx = hcl.Context
 		client = hcl.Client
+		if hcl.TLS != nil {
+			client = hcl.TLS.client(client)
+		}
 		cb = cloneCircuitBreaker(hcl.Cb)
 		cbRedis = cloneCircuitBreakerRedis(hcl.CbRedis)
+		tr = newTracing(hcl.TracerProvider, hcl.Propagator)
+		metrics = hcl.Metrics
 	}
 
 	if ctx == nil {
 		ctx = context.Background()
 	}
+	if client == nil {
+		client = defaultHTTPClient()
+	}
 
-	return &Request{
+	r := &Request{
 		ctx:     ctx,
 		client:  client,
 		Cb:      cb,
 		cbRedis: cbRedis,
+		tracing: tr,
+		metrics: metrics,
 		header:  make(http.Header),
 	}
+
+	if hcl != nil {
+		r.Use(hcl.Middlewares...)
+		r.OnBeforeRequest(hcl.OnBeforeRequest...)
+		r.OnAfterResponse(hcl.OnAfterResponse...)
+		r.OnError(hcl.OnError...)
+		r.auth = credentials{
+			basic:       hcl.BasicAuth,
+			bearer:      hcl.BearerToken,
+			apiKey:      hcl.APIKey,
+			tokenSource: hcl.TokenSource,
+			digest:      hcl.DigestAuth,
+		}
+		r.signer = hcl.Signer
+		r.cache = hcl.Cache
+		r.coalescer = hcl.Coalescer
+		r.rateLimiter = hcl.RateLimiter
+		r.rateLimitMode = hcl.RateLimitMode
+		r.bulkhead = hcl.Bulkhead
+		r.adaptiveLimiter = hcl.AdaptiveLimiter
+		r.hedger = hcl.Hedger
+		r.balancer = hcl.Balancer
+		r.faults = hcl.Faults
+		r.har = hcl.HAR
+	}
+	r.observeCircuitBreakers()
+
+	return r
 }
 
 // Helper functions for New
@@ -93,10 +212,20 @@ func cloneCircuitBreaker(cb *CircuitBreaker) *CircuitBreaker {
 	if cb == nil {
 		return nil
 	}
+
+	// the state is only set on the shared breaker by a HealthChecker
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
 	return &CircuitBreaker{
 		failureCount:  cb.failureCount,
+		successCount:  cb.successCount,
+		state:         cb.state,
+		lastFailTime:  cb.lastFailTime,
+		maxFailures:   cb.maxFailures,
 		resetTimeout:  cb.resetTimeout,
 		halfOpenLimit: cb.halfOpenLimit,
+		onStateChange: cb.onStateChange,
 	}
 }
 
@@ -109,6 +238,7 @@ func cloneCircuitBreakerRedis(cbRedis *CircuitBreakerRedis) *CircuitBreakerRedis
 		Client:       cbRedis.Client,
 		FailureLimit: cbRedis.FailureLimit,
 		ResetTimeout: cbRedis.ResetTimeout,
+		states:       cbRedis.states,
 	}
 }
 
@@ -134,6 +264,13 @@ func (r *Request) turnOffLog() {
 	r.log = nil
 }
 
+func (r *Request) finishLog() {
+	r.log.writeLog()
+	if !r.isRepeatableLog {
+		r.turnOffLog()
+	}
+}
+
 func (r *Request) SetUrl(uri string) *Request {
 	// Check if the request object is nil
 	if r == nil {
@@ -435,6 +572,18 @@ func (r *Request) SetCircuitBreakerKey(key string) *Request {
 	return r
 }
 
+// SetRouteTemplate sets the route label used by metrics, e.g. "/networkprofile/{id}",
+// so the raw path does not end up as a high cardinality label.
+func (r *Request) SetRouteTemplate(route string) *Request {
+	// Check if the request object is nil
+	if r == nil {
+		return nil
+	}
+
+	r.route = route
+	return r
+}
+
 func (r *Request) CloseRequestAfterResponse() *Request {
 	// Check if the request object is nil
 	if r == nil {
@@ -566,72 +715,30 @@ func (r *Request) sendRequest(method RequestMethod) (*Response, error) {
 	return r.chooseExecutionStrategy()
 }
 
-// chooseExecutionStrategy determines which execution method to use based on circuit breaker configuration
+// chooseExecutionStrategy assembles the request pipeline from the configured
+// circuit breakers, middlewares and hooks and executes the request through it
 func (r *Request) chooseExecutionStrategy() (*Response, error) {
 	// Check if the request object is nil
 	if r == nil {
 		return nil, errors.New("failed to execute process, please initiate first")
 	}
 
-	// Pre-execution circuit breaker checks
-	var cbErr error
-	if r.Cb != nil && !r.Cb.allow() {
-		cbErr = errRefuse
-	} else if r.cbRedis != nil {
-		cbErr = r.cbRedis.allowRequest(r.cbKey)
-	}
-
-	if cbErr != nil {
-		if r.log != nil {
-			r.log.setError(cbErr)
-		}
-		return nil, cbErr
-	}
-
-	// Execute the request
-	resp, err := r.executeRequest()
-	if err != nil {
-		return nil, err
-	}
-
-	// Post-execution circuit breaker updates
-	r.updateCircuitBreaker(resp.StatusCode)
-
-	return resp, nil
+	return r.executeRequest(r.roundTripper())
 }
 
-// executeRequest handles the common HTTP request execution logic
-func (r *Request) executeRequest() (*Response, error) {
+// executeRequest builds the HTTP request and sends it through the pipeline
+func (r *Request) executeRequest(transport http.RoundTripper) (*Response, error) {
 	if r == nil {
 		return nil, fmt.Errorf("request cannot be nil, please initiate library")
 	}
 
 	if r.client == nil {
-		r.client = http.DefaultClient
-	}
-
-	// Initialize logging
-	if r.log != nil {
-		r.log.initiate()
-		r.log.setRequest(&http.Request{
-			Method: r.method,
-			URL:    r.url,
-			Header: r.header,
-		})
-
-		defer func() {
-			r.log.writeLog()
-			if !r.isRepeatableLog {
-				r.turnOffLog()
-			}
-		}()
+		r.client = defaultHTTPClient()
 	}
 
 	// Fetch errors if any
 	if err := r.fetchErrors(); err != nil {
-		if r.log != nil {
-			r.log.setError(err)
-		}
+		r.logBuildError(err)
 		return nil, err
 	}
 
@@ -640,12 +747,24 @@ func (r *Request) executeRequest() (*Response, error) {
 		r.ctx = context.Background()
 	}
 
+	// Buffer the body so it can be replayed by retries
+	var body io.Reader
+	if r.body != nil {
+		b, err := io.ReadAll(r.body)
+		if err != nil {
+			r.logBuildError(err)
+			return nil, err
+		}
+		r.body.Close()
+		// keep the body for ToCurl
+		r.body = io.NopCloser(bytes.NewReader(b))
+		body = bytes.NewReader(b)
+	}
+
 	// Create HTTP request
-	req, err := http.NewRequestWithContext(r.ctx, r.method, r.url.String(), r.body)
+	req, err := http.NewRequestWithContext(withExchange(r.ctx), r.method, r.url.String(), body)
 	if err != nil {
-		if r.log != nil {
-			r.log.setError(err)
-		}
+		r.logBuildError(err)
 		return nil, err
 	}
 
@@ -654,58 +773,70 @@ func (r *Request) executeRequest() (*Response, error) {
 	if r.closeRequest {
 		req.Close = true
 	}
+	r.auth.apply(req)
 
-	// Log the request
-	if r.log != nil {
-		r.log.setRequest(req)
-	}
-
-	// Execute request
-	resp, err := r.client.Do(req)
+	resp, err := transport.RoundTrip(req)
 	if err != nil {
-		if r.log != nil {
-			r.log.setError(err)
-		}
 		return nil, err
 	}
 
-	// Log the response
-	if r.log != nil {
-		r.log.setResponse(resp)
+	return (*Response)(resp), nil
+}
+
+// logBuildError reports an error raised before the request could be sent
+func (r *Request) logBuildError(err error) {
+	r.runErrorHooks(nil, err)
+
+	if r.log == nil {
+		return
 	}
 
-	return (*Response)(resp), nil
+	r.log.initiate()
+	r.log.setRequest(&http.Request{
+		Method: r.method,
+		URL:    r.url,
+		Header: r.header,
+	})
+	r.log.setError(err)
+	r.finishLog()
 }
 
 // updateCircuitBreaker updates the circuit breaker state based on response status
 func (r *Request) updateCircuitBreaker(statusCode int) {
+	r.reportCircuitBreaker(!r.isErrorStatus(statusCode))
+}
+
+// defaultErrHttpCodes count as failed requests unless
+// SetErrorHttpCodesCircuitBreaker sets others
+var defaultErrHttpCodes = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// isErrorStatus reports whether the status code counts as a failed request,
+// it only reads the request so hedged attempts may call it concurrently
+func (r *Request) isErrorStatus(statusCode int) bool {
 	if len(r.errHttpCodes) <= 0 {
-		// Define error status codes
-		r.errHttpCodes = []int{
-			http.StatusRequestTimeout,
-			http.StatusTooManyRequests,
-			http.StatusInternalServerError,
-			http.StatusBadGateway,
-			http.StatusServiceUnavailable,
-			http.StatusGatewayTimeout,
-		}
+		return inArray(statusCode, defaultErrHttpCodes)
 	}
 
-	isErrorStatus := inArray(statusCode, r.errHttpCodes)
+	return inArray(statusCode, r.errHttpCodes)
+}
 
+// reportCircuitBreaker records the outcome of a request in the circuit breaker
+func (r *Request) reportCircuitBreaker(success bool) {
 	// Update in-memory circuit breaker
 	if r.Cb != nil {
-		success := true
-		if isErrorStatus {
-			success = false
-		}
-
 		r.Cb.reportResult(success)
 	} else if r.cbRedis != nil { // Update Redis-based circuit breaker
-		if isErrorStatus {
-			r.cbRedis.recordFailure(r.cbKey)
-		} else {
+		if success {
 			r.cbRedis.reset(r.cbKey)
+		} else {
+			r.cbRedis.recordFailure(r.cbKey)
 		}
 	}
 }
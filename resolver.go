@@ -0,0 +1,264 @@
+package hcl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Resolver returns the endpoints of a logical service name, see
+// BalancerOption.Resolver. Implementations must be safe for concurrent use.
+type Resolver interface {
+	Resolve(ctx context.Context, service string) ([]Endpoint, error)
+}
+
+// ResolverFunc adapts a function to Resolver.
+type ResolverFunc func(ctx context.Context, service string) ([]Endpoint, error)
+
+func (f ResolverFunc) Resolve(ctx context.Context, service string) ([]Endpoint, error) {
+	return f(ctx, service)
+}
+
+type DNSResolverOption struct {
+	// Scheme of the endpoints, defaults to "http".
+	Scheme string
+	// SRV looks up the _Service._Proto.<service> SRV records, e.g. Service
+	// "http" and Proto "tcp", their weight and priority are kept. Without it
+	// the A/AAAA records of the service name are used with Port.
+	SRV     bool
+	Service string
+	Proto   string
+	Port    int
+	// SRV records are looked up again after their TTL, at least a second.
+	// TTL is used for the A/AAAA records, whose TTL the system resolver does
+	// not return, defaults to 30s. Stale records are kept when a lookup fails.
+	TTL time.Duration
+	// Nameservers answer the SRV queries, "host:port", defaults to the ones of
+	// /etc/resolv.conf. Without any the system resolver is used with TTL.
+	Nameservers []string
+}
+
+// DNSResolver discovers endpoints from DNS SRV or A/AAAA records.
+type DNSResolver struct {
+	options         DNSResolverOption
+	lookupSRV       func(ctx context.Context, service, proto, name string) ([]*net.SRV, time.Duration, error)
+	lookupSystemSRV func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	lookupHost      func(ctx context.Context, host string) ([]string, error)
+	now             func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]resolvedEntry
+	group   flightGroup
+}
+
+type resolvedEntry struct {
+	endpoints []Endpoint
+	expires   time.Time
+}
+
+func NewDNSResolver(options DNSResolverOption) *DNSResolver {
+	if options.Scheme == "" {
+		options.Scheme = "http"
+	}
+	if options.Proto == "" {
+		options.Proto = "tcp"
+	}
+	if options.TTL <= 0 {
+		options.TTL = 30 * time.Second
+	}
+
+	d := &DNSResolver{
+		options:         options,
+		lookupSystemSRV: net.DefaultResolver.LookupSRV,
+		lookupHost:      net.DefaultResolver.LookupHost,
+		now:             time.Now,
+		entries:         make(map[string]resolvedEntry),
+	}
+	d.lookupSRV = d.lookupSRVWithTTL
+	return d
+}
+
+func (d *DNSResolver) Resolve(ctx context.Context, service string) ([]Endpoint, error) {
+	d.mu.Lock()
+	entry, ok := d.entries[service]
+	d.mu.Unlock()
+	if ok && d.now().Before(entry.expires) {
+		return entry.endpoints, nil
+	}
+
+	val, err, _ := d.group.do(service, func() (interface{}, error) {
+		// the lookup is shared, one caller giving up must not fail the others
+		endpoints, ttl, err := d.lookup(context.WithoutCancel(ctx), service)
+		if err != nil {
+			return nil, err
+		}
+
+		d.mu.Lock()
+		d.entries[service] = resolvedEntry{endpoints: endpoints, expires: d.now().Add(max(ttl, time.Second))}
+		d.mu.Unlock()
+		return endpoints, nil
+	})
+	if err != nil {
+		if ok {
+			return entry.endpoints, nil
+		}
+		return nil, err
+	}
+	return val.([]Endpoint), nil
+}
+
+// lookup returns the endpoints of service and how long they may be kept.
+func (d *DNSResolver) lookup(ctx context.Context, service string) ([]Endpoint, time.Duration, error) {
+	var endpoints []Endpoint
+	ttl := d.options.TTL
+	if d.options.SRV {
+		records, recordTTL, err := d.lookupSRV(ctx, d.options.Service, d.options.Proto, service)
+		if err != nil {
+			return nil, 0, err
+		}
+		ttl = recordTTL
+		for _, srv := range records {
+			host := strings.TrimSuffix(srv.Target, ".")
+			endpoints = append(endpoints, Endpoint{
+				URL:      d.options.Scheme + "://" + net.JoinHostPort(host, strconv.Itoa(int(srv.Port))),
+				Weight:   int(srv.Weight),
+				Priority: int(srv.Priority),
+			})
+		}
+	} else {
+		addrs, err := d.lookupHost(ctx, service)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		port := strconv.Itoa(d.options.Port)
+		for _, addr := range addrs {
+			hostPort := addr
+			if d.options.Port > 0 {
+				hostPort = net.JoinHostPort(addr, port)
+			} else if strings.Contains(addr, ":") {
+				hostPort = "[" + addr + "]"
+			}
+			endpoints = append(endpoints, Endpoint{URL: d.options.Scheme + "://" + hostPort})
+		}
+	}
+
+	if len(endpoints) <= 0 {
+		return nil, 0, errors.New("no records found for " + service)
+	}
+	return endpoints, ttl, nil
+}
+
+type FileResolverOption struct {
+	// Path of a JSON or YAML file, by extension, that maps every service to
+	// its endpoints:
+	//
+	//	billing:
+	//	  - url: http://10.0.0.1:8080
+	//	    weight: 2
+	Path string
+	// ReloadInterval is how often the modification time of the file is
+	// checked, defaults to 5s.
+	ReloadInterval time.Duration
+	// OnReloadError is called when a changed file cannot be read, the last
+	// endpoints are kept.
+	OnReloadError func(err error)
+}
+
+// FileResolver discovers endpoints from a file that is reloaded when it changes.
+type FileResolver struct {
+	options FileResolverOption
+
+	mu        sync.RWMutex
+	services  map[string][]Endpoint
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+func NewFileResolver(options FileResolverOption) (*FileResolver, error) {
+	if options.ReloadInterval <= 0 {
+		options.ReloadInterval = 5 * time.Second
+	}
+
+	f := &FileResolver{options: options}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload reads the file again.
+func (f *FileResolver) Reload() error {
+	info, err := os.Stat(f.options.Path)
+	if err != nil {
+		return errors.New("failed to read endpoints file: " + err.Error())
+	}
+	b, err := os.ReadFile(f.options.Path)
+	if err != nil {
+		return errors.New("failed to read endpoints file: " + err.Error())
+	}
+
+	var services map[string][]Endpoint
+	switch strings.ToLower(filepath.Ext(f.options.Path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &services)
+	default:
+		err = json.Unmarshal(b, &services)
+	}
+	if err != nil {
+		return errors.New("failed to parse endpoints file: " + err.Error())
+	}
+
+	f.mu.Lock()
+	f.services = services
+	f.modTime = info.ModTime()
+	f.checkedAt = time.Now()
+	f.mu.Unlock()
+	return nil
+}
+
+// reloadIfChanged reloads the file when its modification time changed, at
+// most once per ReloadInterval.
+func (f *FileResolver) reloadIfChanged() {
+	f.mu.RLock()
+	due := time.Since(f.checkedAt) >= f.options.ReloadInterval
+	modTime := f.modTime
+	f.mu.RUnlock()
+	if !due {
+		return
+	}
+
+	f.mu.Lock()
+	f.checkedAt = time.Now()
+	f.mu.Unlock()
+
+	info, err := os.Stat(f.options.Path)
+	if err == nil && info.ModTime().Equal(modTime) {
+		return
+	}
+	if err = f.Reload(); err != nil && f.options.OnReloadError != nil {
+		f.options.OnReloadError(err)
+	}
+}
+
+func (f *FileResolver) Resolve(_ context.Context, service string) ([]Endpoint, error) {
+	f.reloadIfChanged()
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	endpoints, ok := f.services[service]
+	if !ok {
+		return nil, errors.New("unknown service " + service)
+	}
+	return endpoints, nil
+}
@@ -0,0 +1,220 @@
+package hcl
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// errNoSRVRecords is returned by a nameserver that knows the name but has no
+// SRV record for it, the next search domain is tried.
+var errNoSRVRecords = errors.New("no SRV records")
+
+// resolvConf is the part of /etc/resolv.conf needed to query SRV records.
+type resolvConf struct {
+	nameservers []string
+	search      []string
+	ndots       int
+}
+
+func readResolvConf(path string) resolvConf {
+	conf := resolvConf{ndots: 1}
+	f, err := os.Open(path)
+	if err != nil {
+		return conf
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			conf.nameservers = append(conf.nameservers, net.JoinHostPort(fields[1], "53"))
+		case "search", "domain":
+			conf.search = fields[1:]
+		case "options":
+			for _, option := range fields[1:] {
+				if n, ok := strings.CutPrefix(option, "ndots:"); ok {
+					if ndots, err := strconv.Atoi(n); err == nil {
+						conf.ndots = ndots
+					}
+				}
+			}
+		}
+	}
+	return conf
+}
+
+// names returns the fully qualified names to query for name, following the
+// search domains like the system resolver.
+func (c resolvConf) names(name string) []string {
+	if strings.HasSuffix(name, ".") {
+		return []string{name}
+	}
+
+	var names []string
+	for _, domain := range c.search {
+		names = append(names, name+"."+strings.TrimSuffix(domain, ".")+".")
+	}
+	if strings.Count(name, ".") >= c.ndots {
+		return append([]string{name + "."}, names...)
+	}
+	return append(names, name+".")
+}
+
+// lookupSRVWithTTL queries the nameservers for the SRV records of
+// _service._proto.name and returns them with their lowest TTL.
+func (d *DNSResolver) lookupSRVWithTTL(ctx context.Context, service, proto, name string) ([]*net.SRV, time.Duration, error) {
+	conf := readResolvConf("/etc/resolv.conf")
+	if len(d.options.Nameservers) > 0 {
+		conf.nameservers = d.options.Nameservers
+	}
+	if len(conf.nameservers) <= 0 {
+		// without a nameserver the TTL of the records is unknown
+		_, records, err := d.lookupSystemSRV(ctx, service, proto, name)
+		return records, d.options.TTL, err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+	}
+
+	var lastErr error
+	for _, fqdn := range conf.names("_" + service + "._" + proto + "." + name) {
+		for _, server := range conf.nameservers {
+			records, ttl, err := exchangeSRV(ctx, server, fqdn)
+			if err == nil {
+				return records, ttl, nil
+			}
+			lastErr = err
+			// the name is not there, the other nameservers would say the same
+			if errors.Is(err, errNoSRVRecords) {
+				break
+			}
+		}
+	}
+	return nil, 0, errors.New("failed to lookup SRV records of " + name + ": " + lastErr.Error())
+}
+
+// exchangeSRV sends one SRV query over UDP and again over TCP when the answer
+// is truncated.
+func exchangeSRV(ctx context.Context, server, fqdn string) ([]*net.SRV, time.Duration, error) {
+	qname, err := dnsmessage.NewName(fqdn)
+	if err != nil {
+		return nil, 0, err
+	}
+	id := uint16(rand.Uint32())
+	query, err := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET}},
+	}).Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	answer, err := dnsExchange(ctx, "udp", server, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	var msg dnsmessage.Message
+	if err = msg.Unpack(answer); err != nil {
+		return nil, 0, err
+	}
+	if msg.Truncated {
+		if answer, err = dnsExchange(ctx, "tcp", server, query); err != nil {
+			return nil, 0, err
+		}
+		if err = msg.Unpack(answer); err != nil {
+			return nil, 0, err
+		}
+	}
+	if msg.ID != id {
+		return nil, 0, errors.New("mismatched DNS answer id")
+	}
+
+	switch msg.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, 0, errNoSRVRecords
+	default:
+		return nil, 0, errors.New("DNS server answered " + msg.RCode.String())
+	}
+
+	var records []*net.SRV
+	var ttl uint32
+	for _, rr := range msg.Answers {
+		srv, ok := rr.Body.(*dnsmessage.SRVResource)
+		if !ok {
+			continue
+		}
+		if len(records) <= 0 || rr.Header.TTL < ttl {
+			ttl = rr.Header.TTL
+		}
+		records = append(records, &net.SRV{
+			Target:   srv.Target.String(),
+			Port:     srv.Port,
+			Priority: srv.Priority,
+			Weight:   srv.Weight,
+		})
+	}
+	if len(records) <= 0 {
+		return nil, 0, errNoSRVRecords
+	}
+	return records, time.Duration(ttl) * time.Second, nil
+}
+
+// dnsExchange sends a packed query and returns the packed answer, TCP
+// messages are prefixed with their length.
+func dnsExchange(ctx context.Context, network, server string, query []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if network == "udp" {
+		if _, err = conn.Write(query); err != nil {
+			return nil, err
+		}
+		answer := make([]byte, 65535)
+		n, err := conn.Read(answer)
+		if err != nil {
+			return nil, err
+		}
+		return answer[:n], nil
+	}
+
+	framed := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+	if _, err = conn.Write(append(framed, query...)); err != nil {
+		return nil, err
+	}
+	var size [2]byte
+	if _, err = io.ReadFull(conn, size[:]); err != nil {
+		return nil, err
+	}
+	answer := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err = io.ReadFull(conn, answer); err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
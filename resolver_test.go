@@ -0,0 +1,242 @@
+package hcl
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestBalancerResolvesServiceName(t *testing.T) {
+	a, b := newNamedServer("a"), newNamedServer("b")
+	defer a.Close()
+	defer b.Close()
+
+	endpoints := map[string][]Endpoint{"billing": {{URL: a.URL}}}
+	resolver := ResolverFunc(func(ctx context.Context, service string) ([]Endpoint, error) {
+		list, ok := endpoints[service]
+		if !ok {
+			return nil, errors.New("unknown service " + service)
+		}
+		return list, nil
+	})
+
+	balancer, err := NewBalancer(BalancerOption{Resolver: resolver})
+	assert.NoError(t, err)
+	hcl := &HCL{Balancer: balancer}
+
+	resp, err := New(hcl).SetUrl("hcl://billing/invoices").Get()
+	assert.NoError(t, err)
+	body, _ := resp.ByteResult()
+	assert.Equal(t, "a /invoices ", string(body))
+
+	// a changed list is picked up by the next request
+	endpoints["billing"] = []Endpoint{{URL: b.URL}}
+	resp, _ = New(hcl).SetUrl("hcl://billing/invoices").Get()
+	body, _ = resp.ByteResult()
+	assert.Equal(t, "b /invoices ", string(body))
+	assert.Len(t, balancer.Endpoints("billing"), 1)
+
+	_, err = New(hcl).SetUrl("hcl://orders/1").Get()
+	assert.ErrorContains(t, err, "failed to resolve service orders: unknown service orders")
+
+	// other URLs are not balanced without static endpoints
+	resp, err = New(hcl).SetUrl(a.URL + "/direct").Get()
+	assert.NoError(t, err)
+	body, _ = resp.ByteResult()
+	assert.Equal(t, "a /direct ", string(body))
+}
+
+func TestDNSResolverSRV(t *testing.T) {
+	clock := newFakeClock()
+	lookups := 0
+	d := NewDNSResolver(DNSResolverOption{SRV: true, Service: "http", TTL: time.Hour})
+	d.now = clock.Now
+	d.lookupSRV = func(ctx context.Context, service, proto, name string) ([]*net.SRV, time.Duration, error) {
+		lookups++
+		assert.Equal(t, "http", service)
+		assert.Equal(t, "tcp", proto)
+		if lookups > 2 {
+			return nil, 0, errors.New("server misbehaving")
+		}
+		return []*net.SRV{
+			{Target: "billing-1.svc.", Port: 8080, Weight: 3},
+			{Target: "billing-dr.svc.", Port: 8080, Weight: 1, Priority: 10},
+		}, time.Minute, nil
+	}
+
+	expected := []Endpoint{
+		{URL: "http://billing-1.svc:8080", Weight: 3},
+		{URL: "http://billing-dr.svc:8080", Weight: 1, Priority: 10},
+	}
+	endpoints, err := d.Resolve(context.Background(), "billing")
+	assert.NoError(t, err)
+	assert.Equal(t, expected, endpoints)
+
+	_, _ = d.Resolve(context.Background(), "billing")
+	assert.Equal(t, 1, lookups)
+
+	// the records are looked up again after their TTL
+	clock.Advance(2 * time.Minute)
+	_, _ = d.Resolve(context.Background(), "billing")
+	assert.Equal(t, 2, lookups)
+
+	// stale records are kept when the lookup fails
+	clock.Advance(2 * time.Minute)
+	endpoints, err = d.Resolve(context.Background(), "billing")
+	assert.NoError(t, err)
+	assert.Equal(t, expected, endpoints)
+}
+
+// newDNSServer answers SRV queries with records of the given TTL, the UDP
+// answer is truncated so the records come over TCP.
+func newDNSServer(t *testing.T, ttl uint32) string {
+	answer := func(query []byte, truncated bool) []byte {
+		var msg dnsmessage.Message
+		assert.NoError(t, msg.Unpack(query))
+		msg.Response, msg.Truncated = true, truncated
+		if !truncated && msg.Questions[0].Name.String() == "_http._tcp.billing.svc." {
+			target, _ := dnsmessage.NewName("billing-1.svc.")
+			msg.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: msg.Questions[0].Name, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET, TTL: ttl},
+				Body:   &dnsmessage.SRVResource{Target: target, Port: 8080, Weight: 3, Priority: 1},
+			}}
+		} else if !truncated {
+			msg.RCode = dnsmessage.RCodeNameError
+		}
+		b, err := msg.Pack()
+		assert.NoError(t, err)
+		return b
+	}
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		udp.Close()
+		tcp.Close()
+	})
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			udp.WriteTo(answer(buf[:n], true), addr)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			var size [2]byte
+			io.ReadFull(conn, size[:])
+			query := make([]byte, binary.BigEndian.Uint16(size[:]))
+			io.ReadFull(conn, query)
+			b := answer(query, false)
+			conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(b))), b...))
+			conn.Close()
+		}
+	}()
+	return udp.LocalAddr().String()
+}
+
+func TestDNSResolverSRVRecordTTL(t *testing.T) {
+	d := NewDNSResolver(DNSResolverOption{SRV: true, Service: "http", Nameservers: []string{newDNSServer(t, 90)}})
+
+	records, ttl, err := d.lookupSRV(context.Background(), "http", "tcp", "billing.svc")
+	assert.NoError(t, err)
+	assert.Equal(t, 90*time.Second, ttl)
+	assert.Equal(t, []*net.SRV{{Target: "billing-1.svc.", Port: 8080, Weight: 3, Priority: 1}}, records)
+
+	_, _, err = d.lookupSRV(context.Background(), "http", "tcp", "unknown.svc")
+	assert.Error(t, err)
+}
+
+func TestResolvConfNames(t *testing.T) {
+	conf := resolvConf{search: []string{"ns.svc.cluster.local", "svc.cluster.local"}, ndots: 5}
+	assert.Equal(t, []string{
+		"_http._tcp.billing.ns.svc.cluster.local.",
+		"_http._tcp.billing.svc.cluster.local.",
+		"_http._tcp.billing.",
+	}, conf.names("_http._tcp.billing"))
+
+	conf.ndots = 1
+	assert.Equal(t, "_http._tcp.billing.", conf.names("_http._tcp.billing")[0])
+	assert.Equal(t, []string{"billing.example.com."}, conf.names("billing.example.com."))
+}
+
+func TestDNSResolverHost(t *testing.T) {
+	d := NewDNSResolver(DNSResolverOption{Scheme: "https", Port: 8443})
+	d.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		return []string{"10.0.0.1", "fd00::1"}, nil
+	}
+
+	endpoints, err := d.Resolve(context.Background(), "billing")
+	assert.NoError(t, err)
+	assert.Equal(t, []Endpoint{{URL: "https://10.0.0.1:8443"}, {URL: "https://[fd00::1]:8443"}}, endpoints)
+}
+
+func TestFileResolver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("billing:\n  - url: http://10.0.0.1:8080\n    weight: 2\n"), 0o600))
+
+	var reloadErr error
+	f, err := NewFileResolver(FileResolverOption{
+		Path:           path,
+		ReloadInterval: time.Millisecond,
+		OnReloadError:  func(err error) { reloadErr = err },
+	})
+	assert.NoError(t, err)
+
+	endpoints, err := f.Resolve(context.Background(), "billing")
+	assert.NoError(t, err)
+	assert.Equal(t, []Endpoint{{URL: "http://10.0.0.1:8080", Weight: 2}}, endpoints)
+
+	_, err = f.Resolve(context.Background(), "orders")
+	assert.EqualError(t, err, "unknown service orders")
+
+	// a changed file is reloaded
+	time.Sleep(5 * time.Millisecond)
+	assert.NoError(t, os.WriteFile(path, []byte("billing:\n  - url: http://10.0.0.2:8080\n"), 0o600))
+	assert.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+	time.Sleep(5 * time.Millisecond)
+
+	endpoints, _ = f.Resolve(context.Background(), "billing")
+	assert.Equal(t, []Endpoint{{URL: "http://10.0.0.2:8080"}}, endpoints)
+
+	// a broken file keeps the last endpoints
+	assert.NoError(t, os.WriteFile(path, []byte("billing: ["), 0o600))
+	assert.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second)))
+	time.Sleep(5 * time.Millisecond)
+
+	endpoints, _ = f.Resolve(context.Background(), "billing")
+	assert.Equal(t, []Endpoint{{URL: "http://10.0.0.2:8080"}}, endpoints)
+	assert.ErrorContains(t, reloadErr, "failed to parse endpoints file")
+}
+
+func TestFileResolverJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"billing":[{"url":"http://10.0.0.1:8080"}]}`), 0o600))
+
+	f, err := NewFileResolver(FileResolverOption{Path: path})
+	assert.NoError(t, err)
+	endpoints, _ := f.Resolve(context.Background(), "billing")
+	assert.Equal(t, []Endpoint{{URL: "http://10.0.0.1:8080"}}, endpoints)
+
+	_, err = NewFileResolver(FileResolverOption{Path: filepath.Join(t.TempDir(), "missing.json")})
+	assert.ErrorContains(t, err, "failed to read endpoints file")
+}
@@ -0,0 +1,422 @@
+package hcl
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signer signs a request right before it is sent. It is called again for every
+// attempt, so signatures that include a timestamp are always fresh.
+type Signer interface {
+	Sign(req *http.Request) error
+}
+
+// SignerFunc adapts a function to Signer.
+type SignerFunc func(req *http.Request) error
+
+func (f SignerFunc) Sign(req *http.Request) error {
+	return f(req)
+}
+
+func (r *Request) SetSigner(signer Signer) *Request {
+	// Check if the request object is nil
+	if r == nil {
+		return nil
+	}
+
+	r.signer = signer
+	return r
+}
+
+// signMiddleware is the innermost layer of the pipeline, every attempt is
+// signed on its own copy of the request.
+func (r *Request) signMiddleware(next http.RoundTripper) http.RoundTripper {
+	if r.signer == nil {
+		return next
+	}
+
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		signed := req.Clone(req.Context())
+		if err := r.signer.Sign(signed); err != nil {
+			return nil, errors.New("failed to sign request: " + err.Error())
+		}
+		return next.RoundTrip(signed)
+	})
+}
+
+// readBody returns the request body without consuming it.
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return io.ReadAll(body)
+	}
+
+	b, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(b))
+	return b, nil
+}
+
+func hashHex(h func() hash.Hash, b []byte) string {
+	sum := h()
+	sum.Write(b)
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+func hmacSum(h func() hash.Hash, key []byte, data string) []byte {
+	mac := hmac.New(h, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery sorts the query by key then value and encodes it the way
+// AWS does, spaces become %20.
+func canonicalQuery(u *url.URL) string {
+	query := u.Query()
+	if len(query) <= 0 {
+		return ""
+	}
+
+	// the encoded keys are sorted on their own, "a" comes before "a1"
+	encoded := make(map[string][]string, len(query))
+	keys := make([]string, 0, len(query))
+	for key, values := range query {
+		k := uriEncode(key)
+		for _, value := range values {
+			encoded[k] = append(encoded[k], uriEncode(value))
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(query))
+	for _, key := range keys {
+		values := encoded[key]
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, key+"="+value)
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func canonicalPath(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// uriEncode escapes everything but the RFC 3986 unreserved characters.
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		default:
+			b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return b.String()
+}
+
+// canonicalHeaders returns the lower cased, sorted names and the
+// "name:value\n" block of the given headers. host is read from the request.
+func canonicalHeaders(req *http.Request, names []string) (string, string) {
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "host" {
+			values[name] = requestHost(req)
+			continue
+		}
+
+		var trimmed []string
+		for _, v := range req.Header.Values(name) {
+			trimmed = append(trimmed, strings.Join(strings.Fields(v), " "))
+		}
+		values[name] = strings.Join(trimmed, ",")
+	}
+
+	sorted := make([]string, 0, len(values))
+	for name := range values {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var b strings.Builder
+	for _, name := range sorted {
+		b.WriteString(name + ":" + values[name] + "\n")
+	}
+	return strings.Join(sorted, ";"), b.String()
+}
+
+func requestHost(req *http.Request) string {
+	if req.Host != "" {
+		return req.Host
+	}
+	return req.URL.Host
+}
+
+type HMACSignerOption struct {
+	KeyID  string
+	Secret []byte
+	// Hash defaults to SHA-256.
+	Hash func() hash.Hash
+	// SignedHeaders are included in the signature, names are case insensitive.
+	SignedHeaders []string
+	// SignatureHeader receives the signature, defaults to X-Signature.
+	SignatureHeader string
+	// TimestampHeader receives the unix timestamp, defaults to X-Timestamp.
+	TimestampHeader string
+	// BodyHashHeader receives the body hash when set, e.g. X-Content-SHA256.
+	BodyHashHeader string
+	// Base64 encodes the signature with base64 instead of hex.
+	Base64 bool
+	// Format builds the SignatureHeader value, defaults to the bare signature.
+	Format func(keyID, timestamp, signature string) string
+	// Clock defaults to time.Now.
+	Clock func() time.Time
+}
+
+// HMACSigner signs the string
+//
+//	METHOD\nPATH\nSORTED_QUERY\nCANONICAL_HEADERS\nTIMESTAMP\nBODY_HASH
+//
+// where CANONICAL_HEADERS are the lower cased "name:value" lines of the
+// signed headers and BODY_HASH is the hex hash of the body.
+type HMACSigner struct {
+	options HMACSignerOption
+}
+
+func NewHMACSigner(options HMACSignerOption) *HMACSigner {
+	if options.Hash == nil {
+		options.Hash = sha256.New
+	}
+	if options.SignatureHeader == "" {
+		options.SignatureHeader = "X-Signature"
+	}
+	if options.TimestampHeader == "" {
+		options.TimestampHeader = "X-Timestamp"
+	}
+	if options.Clock == nil {
+		options.Clock = time.Now
+	}
+
+	return &HMACSigner{options: options}
+}
+
+func (s *HMACSigner) Sign(req *http.Request) error {
+	if len(s.options.Secret) <= 0 {
+		return errors.New("hmac secret cannot be empty")
+	}
+
+	body, err := readBody(req)
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(s.options.Clock().Unix(), 10)
+	bodyHash := hashHex(s.options.Hash, body)
+
+	req.Header.Set(s.options.TimestampHeader, timestamp)
+	if s.options.BodyHashHeader != "" {
+		req.Header.Set(s.options.BodyHashHeader, bodyHash)
+	}
+
+	mac := hmacSum(s.options.Hash, s.options.Secret, s.stringToSign(req, timestamp, bodyHash))
+	signature := hex.EncodeToString(mac)
+	if s.options.Base64 {
+		signature = base64.StdEncoding.EncodeToString(mac)
+	}
+	if s.options.Format != nil {
+		signature = s.options.Format(s.options.KeyID, timestamp, signature)
+	}
+
+	req.Header.Set(s.options.SignatureHeader, signature)
+	return nil
+}
+
+func (s *HMACSigner) stringToSign(req *http.Request, timestamp, bodyHash string) string {
+	_, headers := canonicalHeaders(req, s.options.SignedHeaders)
+	return strings.Join([]string{
+		req.Method,
+		canonicalPath(req.URL),
+		canonicalQuery(req.URL),
+		headers,
+		timestamp,
+		bodyHash,
+	}, "\n")
+}
+
+const (
+	awsAlgorithm       = "AWS4-HMAC-SHA256"
+	awsTimeFormat      = "20060102T150405Z"
+	awsDateFormat      = "20060102"
+	awsHeaderDate      = "X-Amz-Date"
+	awsHeaderToken     = "X-Amz-Security-Token"
+	awsHeaderSHA256    = "X-Amz-Content-Sha256"
+	awsUnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// awsUnsignedHeaders are never signed because proxies and the transport may
+// change them.
+var awsUnsignedHeaders = []string{"authorization", "user-agent", "x-amzn-trace-id", "expect", "traceparent", "tracestate"}
+
+type AWSV4SignerOption struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Region          string
+	Service         string
+	// UnsignedPayload skips hashing the body, only supported by some services such as S3.
+	UnsignedPayload bool
+	// DisableURIPathEscaping signs the path as it is sent, without normalizing
+	// it or encoding its segments a second time, as S3 requires. It is implied
+	// by Service "s3".
+	DisableURIPathEscaping bool
+	// Clock defaults to time.Now.
+	Clock func() time.Time
+}
+
+// AWSV4Signer implements AWS Signature Version 4 with the Authorization header.
+type AWSV4Signer struct {
+	options AWSV4SignerOption
+}
+
+func NewAWSV4Signer(options AWSV4SignerOption) *AWSV4Signer {
+	if options.Clock == nil {
+		options.Clock = time.Now
+	}
+
+	return &AWSV4Signer{options: options}
+}
+
+func (s *AWSV4Signer) Sign(req *http.Request) error {
+	if s.options.AccessKeyID == "" || s.options.SecretAccessKey == "" {
+		return errors.New("aws credentials cannot be empty")
+	}
+	if s.options.Region == "" || s.options.Service == "" {
+		return errors.New("aws region and service cannot be empty")
+	}
+
+	payloadHash := awsUnsignedPayload
+	if !s.options.UnsignedPayload {
+		body, err := readBody(req)
+		if err != nil {
+			return err
+		}
+		payloadHash = hashHex(sha256.New, body)
+	}
+
+	now := s.options.Clock().UTC()
+	req.Header.Del(headerAuthorization)
+	req.Header.Set(awsHeaderDate, now.Format(awsTimeFormat))
+	if s.options.SessionToken != "" {
+		req.Header.Set(awsHeaderToken, s.options.SessionToken)
+	}
+	if s.options.Service == "s3" {
+		req.Header.Set(awsHeaderSHA256, payloadHash)
+	}
+
+	signedHeaders, canonicalRequest := s.canonicalRequest(req, payloadHash)
+	scope := strings.Join([]string{now.Format(awsDateFormat), s.options.Region, s.options.Service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		awsAlgorithm,
+		now.Format(awsTimeFormat),
+		scope,
+		hashHex(sha256.New, []byte(canonicalRequest)),
+	}, "\n")
+
+	signature := hex.EncodeToString(hmacSum(sha256.New, s.signingKey(now), stringToSign))
+	req.Header.Set(headerAuthorization, awsAlgorithm+
+		" Credential="+s.options.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+
+		", Signature="+signature)
+	return nil
+}
+
+func (s *AWSV4Signer) canonicalRequest(req *http.Request, payloadHash string) (string, string) {
+	names := []string{"host"}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if !inArray(lower, awsUnsignedHeaders) {
+			names = append(names, lower)
+		}
+	}
+
+	signedHeaders, headers := canonicalHeaders(req, names)
+	return signedHeaders, strings.Join([]string{
+		req.Method,
+		s.canonicalPath(req.URL),
+		canonicalQuery(req.URL),
+		headers,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+}
+
+// canonicalPath removes the dot segments and empty segments of the path and
+// encodes every segment twice, except for S3.
+func (s *AWSV4Signer) canonicalPath(u *url.URL) string {
+	if s.options.DisableURIPathEscaping || s.options.Service == "s3" {
+		return canonicalPath(u)
+	}
+
+	path := u.EscapedPath()
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		switch segment {
+		case "", ".":
+		case "..":
+			if len(segments) > 0 {
+				segments = segments[:len(segments)-1]
+			}
+		default:
+			if raw, err := url.PathUnescape(segment); err == nil {
+				segment = uriEncode(raw)
+			}
+			segments = append(segments, uriEncode(segment))
+		}
+	}
+
+	canonical := "/" + strings.Join(segments, "/")
+	if len(segments) > 0 && strings.HasSuffix(path, "/") {
+		canonical += "/"
+	}
+	return canonical
+}
+
+func (s *AWSV4Signer) signingKey(now time.Time) []byte {
+	key := hmacSum(sha256.New, []byte("AWS4"+s.options.SecretAccessKey), now.Format(awsDateFormat))
+	key = hmacSum(sha256.New, key, s.options.Region)
+	key = hmacSum(sha256.New, key, s.options.Service)
+	return hmacSum(sha256.New, key, "aws4_request")
+}
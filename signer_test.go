@@ -0,0 +1,222 @@
+package hcl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var awsTestTime = time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+func TestAWSV4Signer(t *testing.T) {
+	// Test vectors from the AWS Signature Version 4 test suite and the IAM
+	// ListUsers example of the AWS documentation.
+	t.Run("get-vanilla", func(t *testing.T) {
+		signer := NewAWSV4Signer(AWSV4SignerOption{
+			AccessKeyID:     "AKIDEXAMPLE",
+			SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+			Region:          "us-east-1",
+			Service:         "service",
+			Clock:           func() time.Time { return awsTestTime },
+		})
+
+		req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+		assert.NoError(t, signer.Sign(req))
+
+		assert.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+		assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+			"SignedHeaders=host;x-amz-date, "+
+			"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+			req.Header.Get("Authorization"))
+	})
+
+	t.Run("iam list users", func(t *testing.T) {
+		signer := NewAWSV4Signer(AWSV4SignerOption{
+			AccessKeyID:     "AKIDEXAMPLE",
+			SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+			Region:          "us-east-1",
+			Service:         "iam",
+			Clock:           func() time.Time { return awsTestTime },
+		})
+
+		req, _ := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Version=2010-05-08&Action=ListUsers", nil)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+		assert.NoError(t, signer.Sign(req))
+
+		assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, "+
+			"SignedHeaders=content-type;host;x-amz-date, "+
+			"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+			req.Header.Get("Authorization"))
+	})
+
+	signature := func(t *testing.T, options AWSV4SignerOption, rawURL string) string {
+		options.AccessKeyID = "AKIDEXAMPLE"
+		options.SecretAccessKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+		options.Region = "us-east-1"
+		options.Service = "service"
+		options.Clock = func() time.Time { return awsTestTime }
+
+		req, _ := http.NewRequest(http.MethodGet, rawURL, nil)
+		assert.NoError(t, NewAWSV4Signer(options).Sign(req))
+		_, sig, _ := strings.Cut(req.Header.Get("Authorization"), "Signature=")
+		return sig
+	}
+
+	t.Run("normalized paths", func(t *testing.T) {
+		for rawURL, want := range map[string]string{
+			// get-slashes, get-slash-pointless-dot, get-relative-relative and get-unreserved
+			"https://example.amazonaws.com//example//":                                                         "9a624bd73a37c9a373b5312afbebe7a714a789de108f0bdfe846570885f57e84",
+			"https://example.amazonaws.com/./example":                                                          "ef75d96142cf21edca26f06005da7988e4f8dc83a165a80865db7089db637ec5",
+			"https://example.amazonaws.com/example1/example2/../..":                                            "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+			"https://example.amazonaws.com/-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz": "07ef7494c76fa4850883e2b006601f940f8a34d404d0cfa977f52a65bbf5f24f",
+		} {
+			assert.Equal(t, want, signature(t, AWSV4SignerOption{}, rawURL), rawURL)
+		}
+	})
+
+	t.Run("path segments are encoded twice", func(t *testing.T) {
+		signer := NewAWSV4Signer(AWSV4SignerOption{Service: "service"})
+		for rawURL, want := range map[string]string{
+			"https://example.amazonaws.com/example space/": "/example%2520space/",
+			"https://example.amazonaws.com/x%2Fy":          "/x%252Fy",
+			"https://example.amazonaws.com/a:b/c+d@e":      "/a%253Ab/c%252Bd%2540e",
+			"https://example.amazonaws.com/\u1234":         "/%25E1%2588%25B4",
+		} {
+			u, _ := url.Parse(rawURL)
+			assert.Equal(t, want, signer.canonicalPath(u), rawURL)
+		}
+	})
+
+	t.Run("single encoding", func(t *testing.T) {
+		// get-space and get-utf8 are encoded once from the raw path
+		options := AWSV4SignerOption{DisableURIPathEscaping: true}
+		assert.Equal(t, "652487583200325589f1fba4c7e578f72c47cb61beeca81406b39ddec1366741",
+			signature(t, options, "https://example.amazonaws.com/example space/"))
+		assert.Equal(t, "8318018e0b0f223aa2bbf98705b62bb787dc9c0e678f255a891fd03141be5d85",
+			signature(t, options, "https://example.amazonaws.com/\u1234"))
+
+		u, _ := url.Parse("https://bucket.s3.amazonaws.com/a b//c")
+		assert.Equal(t, "/a%20b//c", NewAWSV4Signer(AWSV4SignerOption{Service: "s3"}).canonicalPath(u))
+	})
+
+	t.Run("query order", func(t *testing.T) {
+		// keys are sorted before the "=" is added, then the values of a key
+		u, _ := url.Parse("https://ec2.amazonaws.com/?a1=x&a=2&a=1&Filter.1.Value.10=b&Filter.1.Value.1=c&b=a%20b")
+		assert.Equal(t, "Filter.1.Value.1=c&Filter.1.Value.10=b&a=1&a=2&a1=x&b=a%20b", canonicalQuery(u))
+	})
+
+	t.Run("missing credentials", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+		assert.Error(t, NewAWSV4Signer(AWSV4SignerOption{Region: "us-east-1", Service: "s3"}).Sign(req))
+	})
+}
+
+func TestHMACSigner(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	signer := NewHMACSigner(HMACSignerOption{
+		KeyID:          "partner",
+		Secret:         []byte("secret"),
+		SignedHeaders:  []string{"Host", "Content-Type"},
+		BodyHashHeader: "X-Content-SHA256",
+		Format: func(keyID, timestamp, signature string) string {
+			return "keyId=" + keyID + ",signature=" + signature
+		},
+		Clock: func() time.Time { return now },
+	})
+
+	req, _ := http.NewRequest(http.MethodPost, "https://api.example.com/v1/orders?b=2&a=1&a=0", strings.NewReader(`{"id":1}`))
+	req.Header.Set("Content-Type", "application/json")
+	assert.NoError(t, signer.Sign(req))
+
+	bodyHash := sha256.Sum256([]byte(`{"id":1}`))
+	stringToSign := "POST\n/v1/orders\na=0&a=1&b=2\n" +
+		"content-type:application/json\nhost:api.example.com\n\n" +
+		"1700000000\n" + hex.EncodeToString(bodyHash[:])
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(stringToSign))
+
+	assert.Equal(t, "1700000000", req.Header.Get("X-Timestamp"))
+	assert.Equal(t, hex.EncodeToString(bodyHash[:]), req.Header.Get("X-Content-SHA256"))
+	assert.Equal(t, "keyId=partner,signature="+hex.EncodeToString(mac.Sum(nil)), req.Header.Get("X-Signature"))
+
+	// the body is still readable after signing
+	body, _ := io.ReadAll(req.Body)
+	assert.Equal(t, `{"id":1}`, string(body))
+}
+
+func TestRequestSignerResignsEveryAttempt(t *testing.T) {
+	var (
+		mu         sync.Mutex
+		signatures []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		signatures = append(signatures, r.Header.Get("X-Signature"))
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	tick := time.Unix(1700000000, 0)
+	signer := NewHMACSigner(HMACSignerOption{
+		Secret: []byte("secret"),
+		Clock: func() time.Time {
+			tick = tick.Add(time.Second)
+			return tick
+		},
+	})
+
+	retry := func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				return nil, err
+			}
+			drainBody(resp)
+
+			again, err := replay(req)
+			if err != nil {
+				return nil, err
+			}
+			return next.RoundTrip(again)
+		})
+	}
+
+	resp, err := New(&HCL{Signer: signer, Middlewares: []Middleware{retry}}).
+		SetUrl(server.URL).
+		SetJsonPayload(map[string]string{"id": "1"}).
+		Post()
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, signatures, 2)
+	assert.NotEmpty(t, signatures[0])
+	assert.NotEqual(t, signatures[0], signatures[1])
+}
+
+func TestRequestSignerError(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	_, err := New(nil).
+		SetSigner(SignerFunc(func(req *http.Request) error { return errors.New("no key") })).
+		SetUrl(server.URL).
+		Get()
+
+	assert.EqualError(t, err, "failed to sign request: no key")
+	assert.False(t, called)
+}
@@ -0,0 +1,95 @@
+package hcl
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// errFlightPanicked is returned to the callers that shared a call that panicked.
+var errFlightPanicked = errors.New("shared call panicked")
+
+// flightGroup collapses concurrent calls with the same key into one execution.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+	dup int
+}
+
+// do executes fn once for all callers that share key while it is in flight.
+// shared reports whether the result was given to more than one caller.
+func (g *flightGroup) do(key string, fn func() (interface{}, error)) (val interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if c, ok := g.calls[key]; ok {
+		c.dup++
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err, true
+	}
+
+	c := &flightCall{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	// the waiters and later callers must not block when fn panics, the
+	// panic is raised again in this caller
+	defer func() {
+		if p := recover(); p != nil {
+			c.val, c.err = nil, fmt.Errorf("%w: %v", errFlightPanicked, p)
+			g.finish(key, c)
+			panic(p)
+		}
+	}()
+
+	c.val, c.err = fn()
+	shared = g.finish(key, c)
+
+	return c.val, c.err, shared
+}
+
+// doRecover is like do but turns a panic of fn into errFlightPanicked, for
+// calls run in their own goroutine where a panic would end the process.
+func (g *flightGroup) doRecover(key string, fn func() (interface{}, error)) (val interface{}, err error, shared bool) {
+	defer func() {
+		if p := recover(); p != nil {
+			val, err, shared = nil, fmt.Errorf("%w: %v", errFlightPanicked, p), false
+		}
+	}()
+	return g.do(key, fn)
+}
+
+// finish removes the call and releases its waiters.
+func (g *flightGroup) finish(key string, c *flightCall) bool {
+	g.mu.Lock()
+	delete(g.calls, key)
+	shared := c.dup > 0
+	g.mu.Unlock()
+	c.wg.Done()
+	return shared
+}
+
+type flightResult struct {
+	val    interface{}
+	err    error
+	shared bool
+}
+
+// doChan is like do but returns a channel so callers can stop waiting.
+func (g *flightGroup) doChan(key string, fn func() (interface{}, error)) <-chan flightResult {
+	ch := make(chan flightResult, 1)
+	go func() {
+		val, err, shared := g.doRecover(key, fn)
+		ch <- flightResult{val: val, err: err, shared: shared}
+	}()
+	return ch
+}
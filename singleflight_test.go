@@ -0,0 +1,62 @@
+package hcl
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlightGroupPanic(t *testing.T) {
+	var g flightGroup
+	started, release := make(chan struct{}), make(chan struct{})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.PanicsWithValue(t, "boom", func() {
+			_, _, _ = g.do("key", func() (interface{}, error) {
+				close(started)
+				<-release
+				panic("boom")
+			})
+		})
+	}()
+
+	<-started
+	var err error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err, _ = g.do("key", func() (interface{}, error) { return nil, nil })
+	}()
+	assert.Eventually(t, func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return g.calls["key"].dup == 1
+	}, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.True(t, errors.Is(err, errFlightPanicked))
+
+	// the key is released for later callers
+	val, err, _ := g.do("key", func() (interface{}, error) { return "ok", nil })
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", val)
+}
+
+func TestFlightGroupPanicInGoroutine(t *testing.T) {
+	var g flightGroup
+
+	// the panic is returned instead of ending the process
+	result := <-g.doChan("key", func() (interface{}, error) { panic("boom") })
+	assert.ErrorIs(t, result.err, errFlightPanicked)
+	assert.Contains(t, result.err.Error(), "boom")
+
+	val, err, _ := g.doRecover("key", func() (interface{}, error) { return "ok", nil })
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", val)
+}
@@ -0,0 +1,164 @@
+package hcl
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timings holds the connection phases of a single attempt.
+// Phases that did not happen, e.g. DNS on a reused connection, are zero.
+type Timings struct {
+	DNS          time.Duration
+	Connect      time.Duration
+	TLSHandshake time.Duration
+	// TimeToFirstByte is the time between writing the request and receiving
+	// the first response byte, i.e. the time spent waiting on the upstream.
+	TimeToFirstByte time.Duration
+	// BodyRead is the time spent reading the response body, it is only known
+	// once the body has been read to the end.
+	BodyRead   time.Duration
+	ConnReused bool
+}
+
+type timingsKey struct{}
+
+type timingsRecorder struct {
+	mu           sync.Mutex
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	t            Timings
+	onBodyRead   func(time.Duration)
+}
+
+func withTimings(req *http.Request) (*http.Request, *timingsRecorder) {
+	rec := &timingsRecorder{}
+	ctx := context.WithValue(req.Context(), timingsKey{}, rec)
+	ctx = httptrace.WithClientTrace(ctx, rec.clientTrace())
+	return req.WithContext(ctx), rec
+}
+
+func timingsFromContext(ctx context.Context) *timingsRecorder {
+	if ctx == nil {
+		return nil
+	}
+	rec, _ := ctx.Value(timingsKey{}).(*timingsRecorder)
+	return rec
+}
+
+func (rec *timingsRecorder) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			rec.mu.Lock()
+			rec.dnsStart = time.Now()
+			rec.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			rec.mu.Lock()
+			rec.t.DNS = time.Since(rec.dnsStart)
+			rec.mu.Unlock()
+		},
+		ConnectStart: func(string, string) {
+			rec.mu.Lock()
+			rec.connectStart = time.Now()
+			rec.mu.Unlock()
+		},
+		ConnectDone: func(string, string, error) {
+			rec.mu.Lock()
+			rec.t.Connect = time.Since(rec.connectStart)
+			rec.mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			rec.mu.Lock()
+			rec.tlsStart = time.Now()
+			rec.mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			rec.mu.Lock()
+			rec.t.TLSHandshake = time.Since(rec.tlsStart)
+			rec.mu.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			rec.mu.Lock()
+			rec.t.ConnReused = info.Reused
+			rec.mu.Unlock()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			rec.mu.Lock()
+			rec.wroteRequest = time.Now()
+			rec.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			rec.mu.Lock()
+			rec.firstByte = time.Now()
+			if !rec.wroteRequest.IsZero() {
+				rec.t.TimeToFirstByte = rec.firstByte.Sub(rec.wroteRequest)
+			}
+			rec.mu.Unlock()
+		},
+	}
+}
+
+func (rec *timingsRecorder) timings() Timings {
+	if rec == nil {
+		return Timings{}
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.t
+}
+
+// wrapBody measures how long it takes to read the response body to the end.
+func (rec *timingsRecorder) wrapBody(resp *http.Response) {
+	if rec == nil || resp == nil || resp.Body == nil {
+		return
+	}
+	resp.Body = &timedBody{ReadCloser: resp.Body, rec: rec}
+}
+
+func (rec *timingsRecorder) bodyDone() {
+	rec.mu.Lock()
+	if rec.t.BodyRead > 0 || rec.firstByte.IsZero() {
+		rec.mu.Unlock()
+		return
+	}
+	rec.t.BodyRead = time.Since(rec.firstByte)
+	d, onBodyRead := rec.t.BodyRead, rec.onBodyRead
+	rec.mu.Unlock()
+
+	if onBodyRead != nil {
+		onBodyRead(d)
+	}
+}
+
+type timedBody struct {
+	io.ReadCloser
+	rec  *timingsRecorder
+	done bool
+}
+
+func (b *timedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if errors.Is(err, io.EOF) && !b.done {
+		b.done = true
+		b.rec.bodyDone()
+	}
+	return n, err
+}
+
+// Timings returns the connection timings of the attempt that produced the response.
+func (r *Response) Timings() Timings {
+	if r == nil || r.Request == nil {
+		return Timings{}
+	}
+	return timingsFromContext(r.Request.Context()).timings()
+}
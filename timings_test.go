@@ -0,0 +1,76 @@
+package hcl
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseTimings(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer server.Close()
+
+	metrics := newFakeMetrics()
+	r := New(&HCL{Client: server.Client(), Metrics: metrics})
+
+	resp, err := r.SetUrl(server.URL).Get()
+	assert.NoError(t, err)
+
+	timings := resp.Timings()
+	assert.False(t, timings.ConnReused)
+	assert.Greater(t, timings.Connect, time.Duration(0))
+	assert.Greater(t, timings.TLSHandshake, time.Duration(0))
+	assert.GreaterOrEqual(t, timings.TimeToFirstByte, 20*time.Millisecond)
+	assert.Equal(t, time.Duration(0), timings.BodyRead)
+
+	_, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Greater(t, resp.Timings().BodyRead, time.Duration(0))
+
+	assert.Equal(t, 1, metrics.phases["connect"])
+	assert.Equal(t, 1, metrics.phases["tls"])
+	assert.Equal(t, 1, metrics.phases["ttfb"])
+	assert.Equal(t, 1, metrics.phases["body_read"])
+
+	resp, err = r.SetUrl(server.URL).Get()
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	timings = resp.Timings()
+	assert.True(t, timings.ConnReused)
+	assert.Equal(t, time.Duration(0), timings.Connect)
+	assert.Equal(t, time.Duration(0), timings.TLSHandshake)
+}
+
+func TestResponseTimingsWithoutRequest(t *testing.T) {
+	var resp *Response
+	assert.Equal(t, Timings{}, resp.Timings())
+	assert.Equal(t, Timings{}, (&Response{}).Timings())
+}
+
+func TestLogTimings(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`ok`))
+	}))
+	defer server.Close()
+
+	var resp *Response
+	output := captureOutput(func() {
+		var err error
+		resp, err = New(nil).EnableLog(false).SetUrl(server.URL).Get()
+		assert.NoError(t, err)
+	})
+
+	assert.Contains(t, output, `"latencyMs":`)
+	assert.Contains(t, output, `"timings":{"dnsMs":`)
+	assert.Contains(t, output, `"connReused":false`)
+	assert.Greater(t, resp.Timings().BodyRead, time.Duration(0))
+}
@@ -0,0 +1,301 @@
+package hcl
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+type TLSOption struct {
+	// CertFile and KeyFile, or CertPEM and KeyPEM, hold the client certificate
+	// for mTLS. Files are reloaded when they change.
+	CertFile string
+	KeyFile  string
+	CertPEM  []byte
+	KeyPEM   []byte
+	// CAFile or CAPEM replace the system roots used to verify servers.
+	CAFile string
+	CAPEM  []byte
+	// Pins maps a host to the accepted base64 SHA-256 hashes of a SubjectPublicKeyInfo,
+	// the connection is refused unless a certificate of the verified chain matches.
+	Pins map[string][]string
+	// ServerName overrides the name verified in the server certificate.
+	ServerName string
+	// MinVersion defaults to TLS 1.2.
+	MinVersion uint16
+	// ReloadInterval is how often files are checked for changes, defaults to 30 seconds.
+	ReloadInterval time.Duration
+	// OnReloadError is called when changed files cannot be loaded, the previous
+	// certificates stay in use.
+	OnReloadError func(err error)
+}
+
+// TLSManager holds the TLS material of a client and reloads it from disk
+// without restarting, see HCL.TLS.
+type TLSManager struct {
+	options TLSOption
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	roots     *x509.CertPool
+	modTimes  map[string]time.Time
+	checkedAt time.Time
+
+	clients sync.Map
+}
+
+func NewTLSManager(options TLSOption) (*TLSManager, error) {
+	if (options.CertFile == "") != (options.KeyFile == "") {
+		return nil, errors.New("tls cert file and key file must be set together")
+	}
+	if (len(options.CertPEM) == 0) != (len(options.KeyPEM) == 0) {
+		return nil, errors.New("tls cert pem and key pem must be set together")
+	}
+	if options.MinVersion == 0 {
+		options.MinVersion = tls.VersionTLS12
+	}
+	if options.ReloadInterval <= 0 {
+		options.ReloadInterval = 30 * time.Second
+	}
+
+	m := &TLSManager{options: options}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Reload loads the certificates again, it is called automatically when the
+// files change.
+func (m *TLSManager) Reload() error {
+	cert, roots, modTimes, err := m.load()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.cert, m.roots, m.modTimes = cert, roots, modTimes
+	m.checkedAt = time.Now()
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *TLSManager) load() (*tls.Certificate, *x509.CertPool, map[string]time.Time, error) {
+	modTimes := make(map[string]time.Time)
+	read := func(name string, inline []byte) ([]byte, error) {
+		if name == "" {
+			return inline, nil
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[name] = info.ModTime()
+		return os.ReadFile(name)
+	}
+
+	var cert *tls.Certificate
+	certPEM, err := read(m.options.CertFile, m.options.CertPEM)
+	if err != nil {
+		return nil, nil, nil, errors.New("failed to read tls certificate: " + err.Error())
+	}
+	keyPEM, err := read(m.options.KeyFile, m.options.KeyPEM)
+	if err != nil {
+		return nil, nil, nil, errors.New("failed to read tls key: " + err.Error())
+	}
+	if len(certPEM) > 0 {
+		pair, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, nil, nil, errors.New("failed to load tls key pair: " + err.Error())
+		}
+		cert = &pair
+	}
+
+	var roots *x509.CertPool
+	caPEM, err := read(m.options.CAFile, m.options.CAPEM)
+	if err != nil {
+		return nil, nil, nil, errors.New("failed to read tls ca: " + err.Error())
+	}
+	if len(caPEM) > 0 {
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(caPEM) {
+			return nil, nil, nil, errors.New("failed to load tls ca: no certificate found")
+		}
+	}
+
+	return cert, roots, modTimes, nil
+}
+
+// reloadIfChanged reloads the files when their modification time changed,
+// at most once per ReloadInterval.
+func (m *TLSManager) reloadIfChanged() {
+	m.mu.RLock()
+	due := time.Since(m.checkedAt) >= m.options.ReloadInterval
+	modTimes := m.modTimes
+	m.mu.RUnlock()
+	if !due || len(modTimes) <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	m.checkedAt = time.Now()
+	m.mu.Unlock()
+
+	for name, modTime := range modTimes {
+		info, err := os.Stat(name)
+		if err == nil && info.ModTime().Equal(modTime) {
+			continue
+		}
+
+		if err = m.Reload(); err != nil && m.options.OnReloadError != nil {
+			m.options.OnReloadError(err)
+		}
+		return
+	}
+}
+
+func (m *TLSManager) current() (*tls.Certificate, *x509.CertPool) {
+	m.reloadIfChanged()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cert, m.roots
+}
+
+// Config returns a tls.Config with the current roots that presents the latest
+// client certificate and checks the pins of the server name.
+func (m *TLSManager) Config() *tls.Config {
+	_, roots := m.current()
+	return &tls.Config{
+		MinVersion:           m.options.MinVersion,
+		ServerName:           m.options.ServerName,
+		RootCAs:              roots,
+		GetClientCertificate: m.clientCertificate,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return m.verifyPins(cs.ServerName, cs.VerifiedChains)
+		},
+	}
+}
+
+func (m *TLSManager) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	cert, _ := m.current()
+	if cert == nil {
+		// no certificate is sent, the server decides whether it is required
+		return &tls.Certificate{}, nil
+	}
+	return cert, nil
+}
+
+// dialTLS builds the config of every connection at dial time, so reloaded
+// roots are used without rebuilding the transport and pins are checked
+// against the dialed host, including IP addresses that are not sent as SNI.
+func (m *TLSManager) dialTLS(dial func(ctx context.Context, network, addr string) (net.Conn, error), nextProtos []string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+
+		cfg := m.Config()
+		cfg.NextProtos = nextProtos
+		if cfg.ServerName == "" {
+			cfg.ServerName = host
+		}
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return m.verifyPins(host, cs.VerifiedChains)
+		}
+
+		tlsConn := tls.Client(conn, cfg)
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+}
+
+func (m *TLSManager) verifyPins(host string, chains [][]*x509.Certificate) error {
+	pins, ok := m.options.Pins[host]
+	if !ok {
+		return nil
+	}
+
+	for _, chain := range chains {
+		for _, cert := range chain {
+			if inArray(SPKIHash(cert), pins) {
+				return nil
+			}
+		}
+	}
+	return errors.New("tls: no certificate matches the pins of " + host)
+}
+
+// SPKIHash returns the base64 SHA-256 hash of the certificate public key, the
+// format used by TLSOption.Pins.
+func SPKIHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Transport returns a copy of base, or of http.DefaultTransport when nil,
+// that dials TLS connections with the managed certificates. HTTPS through a
+// proxy uses TLSClientConfig, whose roots are the ones loaded when the
+// transport was built.
+func (m *TLSManager) Transport(base *http.Transport) *http.Transport {
+	if base == nil {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+
+	tr := base.Clone()
+	tr.TLSClientConfig = m.Config()
+
+	dial := tr.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	nextProtos := []string{"http/1.1"}
+	if tr.ForceAttemptHTTP2 {
+		nextProtos = []string{"h2", "http/1.1"}
+	}
+	tr.DialTLSContext = m.dialTLS(dial, nextProtos)
+	return tr
+}
+
+// client returns the client to use with the managed TLS config. The derived
+// client is built once per base client so connections are pooled.
+func (m *TLSManager) client(base *http.Client) *http.Client {
+	key := base
+	if key == nil {
+		key = defaultHTTPClient()
+	}
+	if c, ok := m.clients.Load(key); ok {
+		return c.(*http.Client)
+	}
+
+	derived := *key
+	switch tr := key.Transport.(type) {
+	case nil:
+		derived.Transport = m.Transport(nil)
+	case *http.Transport:
+		derived.Transport = m.Transport(tr)
+	default:
+		// unknown round trippers are left as is
+		return key
+	}
+
+	c, _ := m.clients.LoadOrStore(key, &derived)
+	return c.(*http.Client)
+}
@@ -0,0 +1,192 @@
+package hcl
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{"localhost"},
+		IsCA:         isCA,
+
+		BasicConstraintsValid: true,
+	}
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	assert.NoError(t, err)
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// newMTLSServer returns a server that requires a client certificate signed by
+// ca and echoes its common name.
+func newMTLSServer(t *testing.T, ca, server *testCert) *httptest.Server {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	pair, err := tls.X509KeyPair(server.certPEM, server.keyPEM)
+	assert.NoError(t, err)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Client", r.TLS.PeerCertificates[0].Subject.CommonName)
+		w.WriteHeader(http.StatusOK)
+	}))
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	ts.StartTLS()
+	return ts
+}
+
+func TestTLSManagerMutualTLS(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, true)
+	server := newMTLSServer(t, ca, newTestCert(t, "server", ca, false))
+	defer server.Close()
+	client := newTestCert(t, "client-1", ca, false)
+
+	m, err := NewTLSManager(TLSOption{CertPEM: client.certPEM, KeyPEM: client.keyPEM, CAPEM: ca.certPEM})
+	assert.NoError(t, err)
+
+	resp, err := New(&HCL{TLS: m}).SetUrl(server.URL).Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "client-1", resp.Header.Get("X-Client"))
+
+	// the derived client is shared between requests
+	assert.Same(t, m.client(nil), m.client(nil))
+}
+
+func TestTLSManagerRejectsUnknownServer(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, true)
+	other := newTestCert(t, "other-ca", nil, true)
+	server := newMTLSServer(t, ca, newTestCert(t, "server", ca, false))
+	defer server.Close()
+	client := newTestCert(t, "client", ca, false)
+
+	m, err := NewTLSManager(TLSOption{CertPEM: client.certPEM, KeyPEM: client.keyPEM, CAPEM: other.certPEM})
+	assert.NoError(t, err)
+
+	_, err = New(&HCL{TLS: m}).SetUrl(server.URL).Get()
+	assert.ErrorContains(t, err, "certificate signed by unknown authority")
+}
+
+func TestTLSManagerPins(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, true)
+	serverCert := newTestCert(t, "server", ca, false)
+	server := newMTLSServer(t, ca, serverCert)
+	defer server.Close()
+	client := newTestCert(t, "client", ca, false)
+
+	pinned := func(pins ...string) error {
+		m, err := NewTLSManager(TLSOption{
+			CertPEM: client.certPEM,
+			KeyPEM:  client.keyPEM,
+			CAPEM:   ca.certPEM,
+			Pins:    map[string][]string{"127.0.0.1": pins},
+		})
+		assert.NoError(t, err)
+		_, err = New(&HCL{TLS: m}).SetUrl(server.URL).Get()
+		return err
+	}
+
+	assert.NoError(t, pinned(SPKIHash(serverCert.cert)))
+	assert.NoError(t, pinned("unknown", SPKIHash(ca.cert)))
+	assert.ErrorContains(t, pinned("unknown"), "no certificate matches the pins of 127.0.0.1")
+}
+
+func TestTLSManagerReload(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, true)
+	server := newMTLSServer(t, ca, newTestCert(t, "server", ca, false))
+	defer server.Close()
+
+	dir := t.TempDir()
+	write := func(c *testCert, modTime time.Time) {
+		for name, b := range map[string][]byte{"client.crt": c.certPEM, "client.key": c.keyPEM, "ca.crt": ca.certPEM} {
+			path := filepath.Join(dir, name)
+			assert.NoError(t, os.WriteFile(path, b, 0o600))
+			assert.NoError(t, os.Chtimes(path, modTime, modTime))
+		}
+	}
+	write(newTestCert(t, "client-1", ca, false), time.Now().Add(-time.Minute))
+
+	m, err := NewTLSManager(TLSOption{
+		CertFile:       filepath.Join(dir, "client.crt"),
+		KeyFile:        filepath.Join(dir, "client.key"),
+		CAFile:         filepath.Join(dir, "ca.crt"),
+		ReloadInterval: time.Nanosecond,
+	})
+	assert.NoError(t, err)
+
+	// a new connection is needed to present the new certificate
+	get := func() string {
+		resp, err := New(&HCL{TLS: m}).SetUrl(server.URL).CloseRequestAfterResponse().Get()
+		assert.NoError(t, err)
+		return resp.Header.Get("X-Client")
+	}
+	assert.Equal(t, "client-1", get())
+
+	write(newTestCert(t, "client-2", ca, false), time.Now())
+	assert.Equal(t, "client-2", get())
+
+	// a broken file keeps the previous certificate
+	var reloadErr error
+	m.options.OnReloadError = func(err error) { reloadErr = err }
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "client.key"), []byte("broken"), 0o600))
+	assert.Equal(t, "client-2", get())
+	assert.Error(t, reloadErr)
+}
+
+func TestNewTLSManagerErrors(t *testing.T) {
+	_, err := NewTLSManager(TLSOption{CertFile: "client.crt"})
+	assert.Error(t, err)
+
+	_, err = NewTLSManager(TLSOption{CAPEM: []byte("not a certificate")})
+	assert.Error(t, err)
+
+	_, err = NewTLSManager(TLSOption{CAFile: filepath.Join(t.TempDir(), "missing.crt")})
+	assert.Error(t, err)
+}
@@ -0,0 +1,155 @@
+package hcl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/Rahmatulah12/hcl"
+
+const (
+	eventCircuitBreakerRefused = "circuit_breaker.refused"
+	eventRetry                 = "http.retry"
+)
+
+type tracing struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+func newTracing(tp trace.TracerProvider, propagator propagation.TextMapPropagator) *tracing {
+	if tp == nil {
+		return nil
+	}
+
+	if propagator == nil {
+		propagator = propagation.TraceContext{}
+	}
+
+	return &tracing{
+		tracer:     tp.Tracer(tracerName),
+		propagator: propagator,
+	}
+}
+
+// startSpan starts a client span for one attempt and injects the trace context
+// into the outgoing headers.
+// Query parameters listed in sensitive are redacted from the span attributes.
+func (t *tracing) startSpan(req *http.Request, attempt int, sensitive []string) (*http.Request, trace.Span) {
+	if t == nil {
+		return req, nil
+	}
+
+	ctx, span := t.tracer.Start(req.Context(), req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(requestAttributes(req.Method, req.URL, sensitive)...),
+	)
+
+	// a hedge runs alongside the attempt, it is not a resend
+	if attempt > 0 && !isHedge(req.Context()) {
+		span.SetAttributes(semconv.HTTPRequestResendCount(attempt))
+		span.AddEvent(eventRetry, trace.WithAttributes(semconv.HTTPRequestResendCount(attempt)))
+	}
+
+	req = req.WithContext(ctx)
+	req.Header = req.Header.Clone()
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	t.propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	return req, span
+}
+
+func (t *tracing) endSpan(span trace.Span, resp *http.Response, err error) {
+	if t == nil || span == nil {
+		return
+	}
+	defer span.End()
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetAttributes(semconv.ErrorTypeKey.String(errorType(err)))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetAttributes(semconv.ErrorTypeKey.String(strconv.Itoa(resp.StatusCode)))
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+	}
+}
+
+// refused records a request that was rejected by the circuit breaker before
+// reaching the network.
+func (t *tracing) refused(ctx context.Context, method string, u *url.URL, sensitive []string, err error) {
+	if t == nil {
+		return
+	}
+
+	_, span := t.tracer.Start(ctx, method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(requestAttributes(method, u, sensitive)...),
+	)
+	defer span.End()
+
+	span.AddEvent(eventCircuitBreakerRefused)
+	span.SetAttributes(semconv.ErrorTypeKey.String(errorType(err)))
+	span.SetStatus(codes.Error, err.Error())
+}
+
+func requestAttributes(method string, u *url.URL, sensitive []string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{semconv.HTTPRequestMethodKey.String(method)}
+	if u == nil {
+		return attrs
+	}
+
+	full := *u
+	full.User = nil
+	full.RawQuery = redactQuery(full.RawQuery, sensitive)
+	attrs = append(attrs,
+		semconv.URLFull(full.String()),
+		semconv.ServerAddress(full.Hostname()),
+	)
+
+	port := full.Port()
+	if port == "" {
+		switch full.Scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		}
+	}
+	if p, err := strconv.Atoi(port); err == nil {
+		attrs = append(attrs, semconv.ServerPort(p))
+	}
+
+	return attrs
+}
+
+func errorType(err error) string {
+	switch {
+	case errors.Is(err, errRefuse):
+		return "circuit_breaker_open"
+	case errors.Is(err, ErrBulkheadFull):
+		return "bulkhead_full"
+	case errors.Is(err, ErrNoEndpoint):
+		return "no_endpoint"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	}
+	return "_OTHER"
+}
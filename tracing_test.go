@@ -0,0 +1,149 @@
+package hcl
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestTracerProvider() (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	return tp, exporter
+}
+
+func spanAttr(span tracetest.SpanStub, key string) (interface{}, bool) {
+	for _, attr := range span.Attributes {
+		if string(attr.Key) == key {
+			return attr.Value.AsInterface(), true
+		}
+	}
+	return nil, false
+}
+
+func TestTracingClientSpan(t *testing.T) {
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	tp, exporter := newTestTracerProvider()
+	resp, err := New(&HCL{TracerProvider: tp}).SetUrl(server.URL + "/profile").Get()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+
+	span := spans[0]
+	assert.Equal(t, "GET", span.Name)
+	assert.Equal(t, trace.SpanKindClient, span.SpanKind)
+	assert.Equal(t, codes.Unset, span.Status.Code)
+
+	method, _ := spanAttr(span, string(semconv.HTTPRequestMethodKey))
+	assert.Equal(t, "GET", method)
+	status, _ := spanAttr(span, "http.response.status_code")
+	assert.Equal(t, int64(http.StatusOK), status)
+	full, _ := spanAttr(span, "url.full")
+	assert.Equal(t, server.URL+"/profile", full)
+
+	assert.NotEmpty(t, traceparent)
+	assert.Contains(t, traceparent, span.SpanContext.TraceID().String())
+	assert.Contains(t, traceparent, span.SpanContext.SpanID().String())
+}
+
+func TestTracingParentPropagation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	tp, exporter := newTestTracerProvider()
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+
+	_, err := New(&HCL{Context: ctx, TracerProvider: tp}).SetUrl(server.URL).Get()
+	parent.End()
+	assert.NoError(t, err)
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, parent.SpanContext().TraceID(), spans[0].SpanContext.TraceID())
+	assert.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent.SpanID())
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+}
+
+func TestTracingCircuitBreakerRefused(t *testing.T) {
+	tp, exporter := newTestTracerProvider()
+	cb := NewCircuitBreaker(CircuitBreakerOption{MaxFailures: 1, HalfOpenLimit: 1, ResetTimeout: time.Minute})
+
+	req := New(&HCL{TracerProvider: tp, Cb: cb})
+	req.Cb.state = OPEN
+	req.Cb.lastFailTime = time.Now()
+
+	_, err := req.SetUrl("http://localhost:1/profile").Get()
+	assert.Equal(t, errRefuse, err)
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+	assert.Len(t, spans[0].Events, 1)
+	assert.Equal(t, eventCircuitBreakerRefused, spans[0].Events[0].Name)
+}
+
+func TestTracingRetryEvent(t *testing.T) {
+	tp, exporter := newTestTracerProvider()
+	tr := newTracing(tp, nil)
+
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com/a", nil)
+	_, span := tr.startSpan(req, 1, nil)
+	tr.endSpan(span, nil, context.DeadlineExceeded)
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, eventRetry, spans[0].Events[0].Name)
+	resend, _ := spanAttr(spans[0], "http.request.resend_count")
+	assert.Equal(t, int64(1), resend)
+	errType, _ := spanAttr(spans[0], "error.type")
+	assert.Equal(t, "timeout", errType)
+	port, _ := spanAttr(spans[0], "server.port")
+	assert.Equal(t, int64(443), port)
+}
+
+func TestTracingHedgeIsNotAResend(t *testing.T) {
+	tp, exporter := newTestTracerProvider()
+	tr := newTracing(tp, nil)
+
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com/a", nil)
+	req = req.WithContext(context.WithValue(req.Context(), hedgeKey{}, true))
+	_, span := tr.startSpan(req, 1, nil)
+	tr.endSpan(span, &http.Response{StatusCode: http.StatusOK}, nil)
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Empty(t, spans[0].Events)
+	_, ok := spanAttr(spans[0], "http.request.resend_count")
+	assert.False(t, ok)
+}
+
+func TestTracingDisabled(t *testing.T) {
+	assert.Nil(t, newTracing(nil, nil))
+
+	var tr *tracing
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com/a", nil)
+	out, span := tr.startSpan(req, 0, nil)
+	assert.Equal(t, req, out)
+	assert.Nil(t, span)
+	tr.endSpan(span, nil, nil)
+	tr.refused(context.Background(), http.MethodGet, req.URL, nil, errRefuse)
+}
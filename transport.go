@@ -0,0 +1,243 @@
+package hcl
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type TransportPreset int
+
+const (
+	// PresetDefault suits most services calling a handful of upstreams.
+	PresetDefault TransportPreset = iota
+	// PresetHighThroughput keeps large idle pools for many concurrent calls to the same hosts.
+	PresetHighThroughput
+	// PresetLowLatency fails fast on slow dials, handshakes and upstreams.
+	PresetLowLatency
+)
+
+// TransportOption configures NewTransport. Zero values are taken from the preset.
+type TransportOption struct {
+	Preset                TransportPreset
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	MaxConnsPerHost       int
+	IdleConnTimeout       time.Duration
+	DialTimeout           time.Duration
+	KeepAlive             time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	ExpectContinueTimeout time.Duration
+	DisableHTTP2          bool
+	DisableKeepAlives     bool
+	// DisableProxyFromEnvironment ignores HTTP_PROXY, HTTPS_PROXY and NO_PROXY.
+	DisableProxyFromEnvironment bool
+	// DNSCacheTTL caches resolved addresses for this long, 0 disables the cache.
+	DNSCacheTTL time.Duration
+	// TLS sets the certificates of the transport, see NewTLSManager.
+	TLS *TLSManager
+}
+
+var transportPresets = map[TransportPreset]TransportOption{
+	PresetDefault: {
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		DialTimeout:           30 * time.Second,
+		KeepAlive:             30 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	},
+	PresetHighThroughput: {
+		MaxIdleConns:          1000,
+		MaxIdleConnsPerHost:   100,
+		IdleConnTimeout:       90 * time.Second,
+		DialTimeout:           10 * time.Second,
+		KeepAlive:             30 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	},
+	PresetLowLatency: {
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   50,
+		IdleConnTimeout:       60 * time.Second,
+		DialTimeout:           2 * time.Second,
+		KeepAlive:             15 * time.Second,
+		TLSHandshakeTimeout:   3 * time.Second,
+		ResponseHeaderTimeout: 5 * time.Second,
+		ExpectContinueTimeout: 500 * time.Millisecond,
+	},
+}
+
+// NewTransport builds an http.Transport from a preset, fields set in options
+// override the preset.
+func NewTransport(options TransportOption) *http.Transport {
+	options = options.withPreset()
+
+	dialer := &net.Dialer{Timeout: options.DialTimeout, KeepAlive: options.KeepAlive}
+	dial := dialer.DialContext
+	if options.DNSCacheTTL > 0 {
+		dial = newDNSCache(options.DNSCacheTTL, options.DialTimeout, net.DefaultResolver.LookupHost).dialer(dialer)
+	}
+
+	tr := &http.Transport{
+		DialContext:           dial,
+		ForceAttemptHTTP2:     !options.DisableHTTP2,
+		MaxIdleConns:          options.MaxIdleConns,
+		MaxIdleConnsPerHost:   options.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       options.MaxConnsPerHost,
+		IdleConnTimeout:       options.IdleConnTimeout,
+		TLSHandshakeTimeout:   options.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: options.ResponseHeaderTimeout,
+		ExpectContinueTimeout: options.ExpectContinueTimeout,
+		DisableKeepAlives:     options.DisableKeepAlives,
+	}
+	if !options.DisableProxyFromEnvironment {
+		tr.Proxy = http.ProxyFromEnvironment
+	}
+	if options.DisableHTTP2 {
+		// a non-nil empty map disables the automatic HTTP/2 upgrade
+		tr.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	if options.TLS != nil {
+		return options.TLS.Transport(tr)
+	}
+	return tr
+}
+
+func (o TransportOption) withPreset() TransportOption {
+	preset, ok := transportPresets[o.Preset]
+	if !ok {
+		preset = transportPresets[PresetDefault]
+	}
+
+	if o.MaxIdleConns == 0 {
+		o.MaxIdleConns = preset.MaxIdleConns
+	}
+	if o.MaxIdleConnsPerHost == 0 {
+		o.MaxIdleConnsPerHost = preset.MaxIdleConnsPerHost
+	}
+	if o.IdleConnTimeout == 0 {
+		o.IdleConnTimeout = preset.IdleConnTimeout
+	}
+	if o.DialTimeout == 0 {
+		o.DialTimeout = preset.DialTimeout
+	}
+	if o.KeepAlive == 0 {
+		o.KeepAlive = preset.KeepAlive
+	}
+	if o.TLSHandshakeTimeout == 0 {
+		o.TLSHandshakeTimeout = preset.TLSHandshakeTimeout
+	}
+	if o.ResponseHeaderTimeout == 0 {
+		o.ResponseHeaderTimeout = preset.ResponseHeaderTimeout
+	}
+	if o.ExpectContinueTimeout == 0 {
+		o.ExpectContinueTimeout = preset.ExpectContinueTimeout
+	}
+	return o
+}
+
+var (
+	defaultClientOnce sync.Once
+	defaultClient     *http.Client
+)
+
+// defaultHTTPClient is shared by every request created without HCL.Client so
+// connections are pooled across them.
+func defaultHTTPClient() *http.Client {
+	defaultClientOnce.Do(func() {
+		defaultClient = &http.Client{Transport: NewTransport(TransportOption{})}
+	})
+	return defaultClient
+}
+
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// dnsCache keeps resolved addresses for a fixed TTL, a lookup is bounded by
+// timeout.
+type dnsCache struct {
+	ttl        time.Duration
+	timeout    time.Duration
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+
+	mu      sync.Mutex
+	entries map[string]dnsEntry
+	group   flightGroup
+}
+
+func newDNSCache(ttl, timeout time.Duration, lookupHost func(ctx context.Context, host string) ([]string, error)) *dnsCache {
+	return &dnsCache{ttl: ttl, timeout: timeout, lookupHost: lookupHost, entries: make(map[string]dnsEntry)}
+}
+
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.addrs, nil
+	}
+
+	val, err, _ := c.group.do(host, func() (interface{}, error) {
+		// the lookup is shared, one caller giving up must not fail the others
+		lookupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.timeout)
+		defer cancel()
+		addrs, err := c.lookupHost(lookupCtx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		c.entries[host] = dnsEntry{addrs: addrs, expires: time.Now().Add(c.ttl)}
+		c.mu.Unlock()
+		return addrs, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return val.([]string), nil
+}
+
+// dialer resolves the host through the cache and dials the addresses in
+// order until one connects, an address with a port overrides the dialed port.
+func (c *dnsCache) dialer(d *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return d.DialContext(ctx, network, addr)
+		}
+
+		addrs, err := c.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		if len(addrs) <= 0 {
+			return nil, errors.New("no address found for " + host)
+		}
+
+		var errs []error
+		for _, ip := range addrs {
+			target := ip
+			if _, _, err := net.SplitHostPort(ip); err != nil {
+				target = net.JoinHostPort(ip, port)
+			}
+			conn, err := d.DialContext(ctx, network, target)
+			if err == nil {
+				return conn, nil
+			}
+			errs = append(errs, err)
+		}
+		return nil, errors.Join(errs...)
+	}
+}
@@ -0,0 +1,91 @@
+package hcl
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTransportPresets(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		tr := NewTransport(TransportOption{})
+		assert.Equal(t, 100, tr.MaxIdleConns)
+		assert.Equal(t, 10, tr.MaxIdleConnsPerHost)
+		assert.Equal(t, 90*time.Second, tr.IdleConnTimeout)
+		assert.Equal(t, 30*time.Second, tr.ResponseHeaderTimeout)
+		assert.True(t, tr.ForceAttemptHTTP2)
+		assert.NotNil(t, tr.Proxy)
+	})
+
+	t.Run("high throughput", func(t *testing.T) {
+		tr := NewTransport(TransportOption{Preset: PresetHighThroughput})
+		assert.Equal(t, 1000, tr.MaxIdleConns)
+		assert.Equal(t, 100, tr.MaxIdleConnsPerHost)
+	})
+
+	t.Run("low latency with overrides", func(t *testing.T) {
+		tr := NewTransport(TransportOption{
+			Preset:                      PresetLowLatency,
+			MaxIdleConnsPerHost:         5,
+			DisableHTTP2:                true,
+			DisableProxyFromEnvironment: true,
+		})
+		assert.Equal(t, 5, tr.MaxIdleConnsPerHost)
+		assert.Equal(t, 3*time.Second, tr.TLSHandshakeTimeout)
+		assert.Equal(t, 5*time.Second, tr.ResponseHeaderTimeout)
+		assert.False(t, tr.ForceAttemptHTTP2)
+		assert.NotNil(t, tr.TLSNextProto)
+		assert.Nil(t, tr.Proxy)
+	})
+}
+
+func TestDNSCache(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	closed, _ := url.Parse(closedURL())
+
+	var lookups int32
+	cache := newDNSCache(time.Minute, time.Second, func(ctx context.Context, host string) ([]string, error) {
+		atomic.AddInt32(&lookups, 1)
+		assert.Equal(t, "profile.service.test", host)
+		// the first address refuses connections
+		return []string{closed.Host, "127.0.0.1"}, nil
+	})
+
+	tr := NewTransport(TransportOption{DisableKeepAlives: true, DisableProxyFromEnvironment: true})
+	tr.DialContext = cache.dialer(&net.Dialer{Timeout: time.Second})
+	client := &http.Client{Transport: tr}
+
+	for i := 0; i < 3; i++ {
+		resp, err := New(&HCL{Client: client}).SetUrl("http://profile.service.test:" + port).Get()
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&lookups))
+}
+
+func TestDNSCacheLookupTimeout(t *testing.T) {
+	cache := newDNSCache(time.Minute, 20*time.Millisecond, func(ctx context.Context, host string) ([]string, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	// a hung resolver does not hold the shared lookup open
+	_, err := cache.lookup(context.Background(), "profile.service.test")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestNewUsesSharedDefaultClient(t *testing.T) {
+	assert.Same(t, New(nil).client, New(&HCL{}).client)
+	assert.NotSame(t, http.DefaultClient, New(nil).client)
+}
@@ -1,8 +1,20 @@
 package hcl
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
 	"reflect"
+	"strconv"
 	"strings"
 )
 
@@ -25,6 +37,26 @@ func convertInterfaceToJson(data interface{}) string {
 }
 
 func maskString(input string, config *MaskConfig) string {
+	maskType := config.MaskType
+	switch maskType {
+	case HashMask:
+		// an unkeyed hash of a PIN or MSISDN is reversed with a dictionary
+		if len(config.HashKey) <= 0 {
+			maskType = FullMask
+			break
+		}
+		return hashString(input, config.HashKey)
+	case EmailMask:
+		return maskEmail(input, config)
+	case PhoneMask:
+		return maskPhone(input, config)
+	case CustomMask:
+		if config.Masker == nil {
+			return strings.Repeat("*", 5)
+		}
+		return config.Masker(input)
+	}
+
 	length := len(input)
 	if length <= 1 {
 		return "*"
@@ -34,7 +66,7 @@ func maskString(input string, config *MaskConfig) string {
 		return "*"
 	}
 
-	switch config.MaskType {
+	switch maskType {
 	case Default:
 		return strings.Repeat("*", 5)
 	case FullMask:
@@ -57,6 +89,91 @@ func maskString(input string, config *MaskConfig) string {
 	}
 }
 
+func hashString(input string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(input))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func maskEmail(input string, config *MaskConfig) string {
+	at := strings.LastIndex(input, "@")
+	if at <= 0 || at == len(input)-1 {
+		return strings.Repeat("*", 5)
+	}
+
+	local, domain := input[:at], input[at:]
+	showFirst := config.ShowFirst
+	if showFirst <= 0 {
+		showFirst = 1
+	}
+	if showFirst >= len(local) {
+		return strings.Repeat("*", len(local)) + domain
+	}
+
+	return local[:showFirst] + strings.Repeat("*", len(local)-showFirst) + domain
+}
+
+func maskPhone(input string, config *MaskConfig) string {
+	showFirst, showLast := config.ShowFirst, config.ShowLast
+	if showFirst <= 0 && showLast <= 0 {
+		showFirst, showLast = 4, 3
+	}
+
+	digits := 0
+	for _, c := range input {
+		if c >= '0' && c <= '9' {
+			digits++
+		}
+	}
+
+	if digits == 0 {
+		return strings.Repeat("*", 5)
+	}
+	if showFirst+showLast >= digits {
+		showFirst, showLast = 0, 0
+	}
+
+	var b strings.Builder
+	idx := 0
+	for _, c := range input {
+		if c < '0' || c > '9' {
+			b.WriteRune(c)
+			continue
+		}
+		if idx < showFirst || idx >= digits-showLast {
+			b.WriteRune(c)
+		} else {
+			b.WriteByte('*')
+		}
+		idx++
+	}
+	return b.String()
+}
+
+func maskNumber(value interface{}, config *MaskConfig) interface{} {
+	if config.KeepType {
+		return reflect.Zero(reflect.TypeOf(value)).Interface()
+	}
+
+	switch config.MaskType {
+	case HashMask, PhoneMask, CustomMask:
+		return maskString(numberToString(value), config)
+	default:
+		return "*****"
+	}
+}
+
+func numberToString(value interface{}) string {
+	switch v := value.(type) {
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return strconv.FormatInt(reflect.ValueOf(v).Int(), 10)
+	}
+}
+
 func shouldMask(key string, configs []*MaskConfig) (bool, *MaskConfig) {
 	lowerKey := strings.ToLower(key)
 	for _, config := range configs {
@@ -71,10 +188,8 @@ func maskValue(value interface{}, config *MaskConfig) interface{} {
 	switch v := value.(type) {
 	case string:
 		return maskString(v, config)
-	case int, int8, int16, int32, int64:
-		return "*****"
-	case float32, float64:
-		return "*****"
+	case int, int8, int16, int32, int64, float32, float64:
+		return maskNumber(v, config)
 	case []interface{}:
 		for i, item := range v {
 			v[i] = maskValue(item, config)
@@ -136,7 +251,7 @@ func maskValue(value interface{}, config *MaskConfig) interface{} {
 }
 
 func maskJSON(jsonStr string, configs []*MaskConfig) string {
-	var data map[string]interface{}
+	var data interface{}
 	if err := json.Unmarshal([]byte(jsonStr), &data); err != nil {
 		return ""
 	}
@@ -146,15 +261,279 @@ func maskJSON(jsonStr string, configs []*MaskConfig) string {
 	return convertInterfaceToJson(data)
 }
 
-func maskNestedJSON(data map[string]interface{}, configs []*MaskConfig) {
-	for key, value := range data {
-		if isMasked, config := shouldMask(key, configs); isMasked {
-			(data)[key] = maskValue(value, config)
-		} else if nestedMap, ok := value.(map[string]interface{}); ok {
-			maskNestedJSON(nestedMap, configs)
-			(data)[key] = nestedMap
+// maskNestedJSON masks the fields of the objects in data, including the ones
+// nested in arrays.
+func maskNestedJSON(data interface{}, configs []*MaskConfig) {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if isMasked, config := shouldMask(key, configs); isMasked {
+				v[key] = maskValue(value, config)
+			} else {
+				maskNestedJSON(value, configs)
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			maskNestedJSON(item, configs)
+		}
+	}
+}
+
+// maskedUnparseableBody replaces a body that should be masked but cannot be
+// parsed, so secrets never leave the process unmasked.
+const maskedUnparseableBody = "[unparseable body masked]"
+
+// maskPayload masks a request or response body according to its content type.
+// Bodies of other content types are returned unchanged.
+func maskPayload(ct, body string, configs []*MaskConfig) string {
+	if body == "" || len(configs) <= 0 {
+		return body
+	}
+
+	mediaType, params, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return body
+	}
+
+	var masked string
+	switch {
+	case mediaType == contentTypeJSON || strings.HasSuffix(mediaType, "+json"):
+		masked = maskJSON(body, configs)
+	case mediaType == contentTypeXML || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		masked = maskXML(body, configs)
+	case mediaType == contentTypeFormData:
+		masked = maskFormURLEncoded(body, configs)
+	case mediaType == "multipart/form-data":
+		masked = maskMultipart(body, params["boundary"], configs)
+	default:
+		return body
+	}
+
+	if masked == "" {
+		return maskedUnparseableBody
+	}
+	return masked
+}
+
+// maskHeader returns a copy of header with the values of the masked fields masked.
+func maskHeader(header http.Header, configs []*MaskConfig) http.Header {
+	masked := header.Clone()
+	for name, values := range masked {
+		if found, config := shouldMask(name, configs); found {
+			for i, value := range values {
+				values[i] = maskString(value, config)
+			}
+		}
+	}
+	return masked
+}
+
+// maskQuery masks the values of the masked fields in place.
+func maskQuery(query url.Values, configs []*MaskConfig) url.Values {
+	for name, values := range query {
+		if found, config := shouldMask(name, configs); found {
+			for i, value := range values {
+				values[i] = maskString(value, config)
+			}
+		}
+	}
+	return query
+}
+
+// shouldMaskPath reports whether an element or attribute matches a config,
+// either by its name or by its slash separated path, e.g. "Body/Pin".
+func shouldMaskPath(name, path string, configs []*MaskConfig) (bool, *MaskConfig) {
+	lowerName := strings.ToLower(name)
+	lowerPath := strings.ToLower(path)
+	for _, config := range configs {
+		field := strings.ToLower(config.Field)
+		if field == "" {
+			continue
+		}
+		if field == lowerName || field == lowerPath || strings.HasSuffix(lowerPath, "/"+field) {
+			return true, config
+		}
+	}
+	return false, nil
+}
+
+func maskXML(xmlStr string, configs []*MaskConfig) string {
+	type element struct {
+		path   string
+		config *MaskConfig
+	}
+
+	var (
+		out   strings.Builder
+		stack []element
+		last  int64
+	)
+
+	decoder := xml.NewDecoder(strings.NewReader(xmlStr))
+	decoder.Strict = false
+
+	for {
+		start := decoder.InputOffset()
+		token, err := decoder.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return ""
+		}
+		end := decoder.InputOffset()
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			path := t.Name.Local
+			var config *MaskConfig
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				path = parent.path + "/" + path
+				config = parent.config
+			}
+			if ok, c := shouldMaskPath(t.Name.Local, path, configs); ok {
+				config = c
+			}
+			stack = append(stack, element{path: path, config: config})
+
+			if masked, ok := maskXMLAttrs(t, path, xmlStr[start:end], configs); ok {
+				out.WriteString(xmlStr[last:start])
+				out.WriteString(masked)
+				last = end
+			}
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			if len(stack) <= 0 || stack[len(stack)-1].config == nil {
+				continue
+			}
+			text := strings.TrimSpace(string(t))
+			if text == "" {
+				continue
+			}
+			var escaped bytes.Buffer
+			_ = xml.EscapeText(&escaped, []byte(maskString(text, stack[len(stack)-1].config)))
+			out.WriteString(xmlStr[last:start])
+			out.WriteString(escaped.String())
+			last = end
+		}
+	}
+
+	out.WriteString(xmlStr[last:])
+	return out.String()
+}
+
+// maskXMLAttrs rebuilds a start tag when at least one of its attributes is masked.
+func maskXMLAttrs(el xml.StartElement, path, raw string, configs []*MaskConfig) (string, bool) {
+	var (
+		attrs  strings.Builder
+		masked bool
+	)
+
+	for _, attr := range el.Attr {
+		value := attr.Value
+		if ok, config := shouldMaskPath("@"+attr.Name.Local, path+"/@"+attr.Name.Local, configs); ok {
+			value = maskString(value, config)
+			masked = true
+		} else if ok, config := shouldMaskPath(attr.Name.Local, path+"/"+attr.Name.Local, configs); ok {
+			value = maskString(value, config)
+			masked = true
+		}
+
+		var escaped bytes.Buffer
+		_ = xml.EscapeText(&escaped, []byte(value))
+		attrs.WriteString(" " + xmlName(attr.Name) + `="` + escaped.String() + `"`)
+	}
+
+	if !masked {
+		return "", false
+	}
+
+	closing := ">"
+	if strings.HasSuffix(raw, "/>") {
+		closing = "/>"
+	}
+	return "<" + xmlName(el.Name) + attrs.String() + closing, true
+}
+
+func xmlName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+func maskFormURLEncoded(form string, configs []*MaskConfig) string {
+	pairs := strings.Split(form, "&")
+	for i, pair := range pairs {
+		rawKey, rawVal, found := strings.Cut(pair, "=")
+		if !found {
+			continue
 		}
+
+		key, err := url.QueryUnescape(rawKey)
+		if err != nil {
+			continue
+		}
+
+		if ok, config := shouldMask(key, configs); ok {
+			val, err := url.QueryUnescape(rawVal)
+			if err != nil {
+				val = rawVal
+			}
+			pairs[i] = rawKey + "=" + maskString(val, config)
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func maskMultipart(body, boundary string, configs []*MaskConfig) string {
+	if boundary == "" {
+		return ""
+	}
+
+	var out bytes.Buffer
+	reader := multipart.NewReader(strings.NewReader(body), boundary)
+	writer := multipart.NewWriter(&out)
+	if err := writer.SetBoundary(boundary); err != nil {
+		return ""
+	}
+
+	for {
+		part, err := reader.NextRawPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return ""
+		}
+
+		content, err := io.ReadAll(part)
+		if err != nil {
+			return ""
+		}
+
+		if ok, config := shouldMask(part.FormName(), configs); ok && part.FileName() == "" {
+			content = []byte(maskString(string(content), config))
+		}
+
+		w, err := writer.CreatePart(part.Header)
+		if err != nil {
+			return ""
+		}
+		if _, err = w.Write(content); err != nil {
+			return ""
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return ""
 	}
+	return out.String()
 }
 
 func inArray(needle interface{}, hystack interface{}) bool {
@@ -1,6 +1,8 @@
 package hcl
 
 import (
+	"bytes"
+	"mime/multipart"
 	"strings"
 	"testing"
 
@@ -131,6 +133,72 @@ func TestMaskString(t *testing.T) {
 	})
 }
 
+func TestMaskStringStrategies(t *testing.T) {
+	t.Run("hash mask is deterministic and keyed", func(t *testing.T) {
+		config := &MaskConfig{MaskType: HashMask, HashKey: []byte("secret")}
+		first := maskString("081292021531", config)
+		second := maskString("081292021531", config)
+		other := maskString("081292021531", &MaskConfig{MaskType: HashMask, HashKey: []byte("other")})
+
+		assert.Equal(t, first, second)
+		assert.NotEqual(t, first, other)
+		assert.Len(t, first, 64)
+		assert.NotContains(t, first, "081292021531")
+	})
+
+	t.Run("hash mask ignores length limit", func(t *testing.T) {
+		config := &MaskConfig{MaskType: HashMask, HashKey: []byte("secret")}
+		result := maskString(strings.Repeat("a", 300), config)
+		assert.Len(t, result, 64)
+	})
+
+	t.Run("hash mask without key falls back to full mask", func(t *testing.T) {
+		config := &MaskConfig{MaskType: HashMask}
+		assert.Equal(t, "************", maskString("081292021531", config))
+		assert.Equal(t, "***********", maskNumber(int64(81292021531), config))
+	})
+
+	t.Run("email mask", func(t *testing.T) {
+		config := &MaskConfig{MaskType: EmailMask}
+		assert.Equal(t, "j***@domain.com", maskString("john@domain.com", config))
+	})
+
+	t.Run("email mask - show first", func(t *testing.T) {
+		config := &MaskConfig{MaskType: EmailMask, ShowFirst: 2}
+		assert.Equal(t, "jo******@domain.com", maskString("john.doe@domain.com", config))
+	})
+
+	t.Run("email mask - invalid email", func(t *testing.T) {
+		config := &MaskConfig{MaskType: EmailMask}
+		assert.Equal(t, "*****", maskString("not-an-email", config))
+	})
+
+	t.Run("phone mask - default", func(t *testing.T) {
+		config := &MaskConfig{MaskType: PhoneMask}
+		assert.Equal(t, "6281******531", maskString("6281292021531", config))
+	})
+
+	t.Run("phone mask - keeps separators", func(t *testing.T) {
+		config := &MaskConfig{MaskType: PhoneMask, ShowFirst: 2, ShowLast: 2}
+		assert.Equal(t, "+62-***-****-**31", maskString("+62-812-9202-1531", config))
+	})
+
+	t.Run("phone mask - too short", func(t *testing.T) {
+		config := &MaskConfig{MaskType: PhoneMask}
+		assert.Equal(t, "****", maskString("1234", config))
+	})
+
+	t.Run("custom mask", func(t *testing.T) {
+		config := &MaskConfig{MaskType: CustomMask, Masker: strings.ToUpper}
+		assert.Equal(t, "SECRET", maskString("secret", config))
+	})
+
+	t.Run("custom mask without masker", func(t *testing.T) {
+		config := &MaskConfig{MaskType: CustomMask}
+		assert.Equal(t, "*****", maskString("secret", config))
+	})
+}
+
 func TestShouldMask(t *testing.T) {
 	configs := []*MaskConfig{
 		{Field: "password", MaskType: FullMask},
@@ -174,6 +242,17 @@ func TestMaskValue(t *testing.T) {
 		assert.Equal(t, "*****", result)
 	})
 
+	t.Run("number keeps type", func(t *testing.T) {
+		keep := &MaskConfig{MaskType: FullMask, KeepType: true}
+		assert.Equal(t, float64(0), maskValue(123.45, keep))
+		assert.Equal(t, int64(0), maskValue(int64(12345), keep))
+	})
+
+	t.Run("number with phone mask", func(t *testing.T) {
+		phone := &MaskConfig{MaskType: PhoneMask}
+		assert.Equal(t, "6281******531", maskValue(float64(6281292021531), phone))
+	})
+
 	t.Run("slice of interface", func(t *testing.T) {
 		data := []interface{}{"secret1", "secret2"}
 		result := maskValue(data, config).([]interface{})
@@ -226,6 +305,114 @@ func TestMaskJSON(t *testing.T) {
 		assert.Contains(t, result, `"password":"*********"`)
 		assert.Contains(t, result, `"credit_card":"1234********3456"`)
 	})
+
+	t.Run("arrays", func(t *testing.T) {
+		result := maskJSON(`[{"username":"john","password":"secret123"}]`, configs)
+		assert.Equal(t, `[{"password":"*********","username":"john"}]`, result)
+
+		result = maskJSON(`{"users":[{"password":"x"},[{"password":"y"}]]}`, configs)
+		assert.Equal(t, `{"users":[{"password":"*"},[{"password":"*"}]]}`, result)
+	})
+}
+
+func TestMaskXML(t *testing.T) {
+	configs := []*MaskConfig{
+		{Field: "Pin", MaskType: FullMask},
+		{Field: "Account/Number", MaskType: PartialMask, ShowFirst: 2, ShowLast: 2},
+		{Field: "@token", MaskType: Default},
+	}
+
+	t.Run("element by name", func(t *testing.T) {
+		result := maskXML(`<Request><User>john</User><Pin>123456</Pin></Request>`, configs)
+		assert.Equal(t, `<Request><User>john</User><Pin>******</Pin></Request>`, result)
+	})
+
+	t.Run("element by path", func(t *testing.T) {
+		result := maskXML(`<Request><Account><Number>12345678</Number></Account><Number>99</Number></Request>`, configs)
+		assert.Equal(t, `<Request><Account><Number>12****78</Number></Account><Number>99</Number></Request>`, result)
+	})
+
+	t.Run("nested elements under a masked element", func(t *testing.T) {
+		result := maskXML(`<Pin><Old>1111</Old><New>2222</New></Pin>`, configs)
+		assert.Equal(t, `<Pin><Old>****</Old><New>****</New></Pin>`, result)
+	})
+
+	t.Run("attributes", func(t *testing.T) {
+		result := maskXML(`<Auth token="abcdef" user="john"/>`, configs)
+		assert.Equal(t, `<Auth token="*****" user="john"/>`, result)
+	})
+
+	t.Run("namespaced soap envelope", func(t *testing.T) {
+		body := `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body><m:Pin xmlns:m="urn:x">4321</m:Pin></soap:Body></soap:Envelope>`
+		result := maskXML(body, configs)
+		assert.Equal(t, strings.Replace(body, ">4321<", ">****<", 1), result)
+	})
+
+	t.Run("invalid xml", func(t *testing.T) {
+		assert.Equal(t, "", maskXML(`<Pin>1234</Pin`, configs))
+	})
+}
+
+func TestMaskFormURLEncoded(t *testing.T) {
+	configs := []*MaskConfig{
+		{Field: "password", MaskType: FullMask},
+	}
+
+	result := maskFormURLEncoded("username=john&password=s%40cret&empty", configs)
+	assert.Equal(t, "username=john&password=******&empty", result)
+}
+
+func TestMaskMultipart(t *testing.T) {
+	configs := []*MaskConfig{
+		{Field: "pin", MaskType: FullMask},
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	_ = writer.WriteField("user", "john")
+	_ = writer.WriteField("pin", "123456")
+	_ = writer.Close()
+
+	result := maskMultipart(body.String(), writer.Boundary(), configs)
+	assert.Contains(t, result, "john")
+	assert.Contains(t, result, "******")
+	assert.NotContains(t, result, "123456")
+
+	assert.Equal(t, "", maskMultipart(body.String(), "", configs))
+}
+
+func TestMaskPayload(t *testing.T) {
+	configs := []*MaskConfig{
+		{Field: "pin", MaskType: FullMask},
+	}
+
+	t.Run("json", func(t *testing.T) {
+		result := maskPayload("application/json; charset=utf-8", `{"pin":"1234"}`, configs)
+		assert.Equal(t, `{"pin":"****"}`, result)
+	})
+
+	t.Run("xml", func(t *testing.T) {
+		result := maskPayload("text/xml", `<pin>1234</pin>`, configs)
+		assert.Equal(t, `<pin>****</pin>`, result)
+	})
+
+	t.Run("form url encoded", func(t *testing.T) {
+		result := maskPayload(contentTypeFormData, `pin=1234`, configs)
+		assert.Equal(t, `pin=****`, result)
+	})
+
+	t.Run("unknown content type", func(t *testing.T) {
+		result := maskPayload("text/plain", `pin=1234`, configs)
+		assert.Equal(t, `pin=1234`, result)
+	})
+
+	t.Run("unparseable body", func(t *testing.T) {
+		result := maskPayload(contentTypeJSON, `{"pin":`, configs)
+		assert.Equal(t, maskedUnparseableBody, result)
+
+		result = maskPayload(contentTypeJSON, `[{"pin":"1234"}]`, configs)
+		assert.Equal(t, `[{"pin":"****"}]`, result)
+	})
 }
 
 func TestInArray(t *testing.T) {